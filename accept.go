@@ -0,0 +1,140 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ghoti143/httpsig/internal/sfv"
+)
+
+var errNoAcceptableKey = errors.New("no key matches the accepted signature")
+
+// acceptSignature returns the `Accept-Signature` header value advertising the components v
+// requires, and the algorithm of its keys if they all share one. It is empty if v has no
+// components to advertise.
+func (v *verifier) acceptSignature() (string, error) {
+	if len(v.accept) == 0 {
+		return "", nil
+	}
+
+	var il sfv.InnerList
+	for _, a := range v.accept {
+		c, err := parseComponent(a)
+		if err != nil {
+			return "", err
+		}
+		il.Items = append(il.Items, c.item())
+	}
+
+	if alg := v.sharedAlg(); alg != "" {
+		il.Params = sfv.Params{{Key: "alg", Value: alg}}
+	}
+
+	return sfv.SerializeDictionary(sfv.Dictionary{{Key: "sig1", Value: il}})
+}
+
+// sharedAlg returns the algorithm of all of v's keys, or an empty string if they don't all have
+// the same one. Keys without an algorithm, or from a KeyStore or KeyResolver, could be used with
+// any algorithm, so there is no shared one.
+func (v *verifier) sharedAlg() string {
+	if v.store != nil || v.resolver != nil {
+		return ""
+	}
+
+	algs := make(map[string]bool)
+	for _, vh := range v.keys {
+		algs[vh.alg] = true
+	}
+
+	// Derived keys are always hmac-sha256.
+	if v.derive != nil {
+		algs["hmac-sha256"] = true
+	}
+
+	if len(algs) != 1 || algs[""] {
+		return ""
+	}
+
+	for alg := range algs {
+		return alg
+	}
+
+	return ""
+}
+
+// accepting returns a copy of s that signs as requested by the `Accept-Signature` header value
+// in, with the components listed and only the keys matching its keyid and alg parameters.
+func (s *signer) accepting(in string) (*signer, error) {
+	d, err := sfv.ParseDictionary(in)
+	if err != nil || len(d) == 0 {
+		return nil, errMalformedSignatureInput
+	}
+
+	il, ok := d[0].Value.(sfv.InnerList)
+	if !ok {
+		return nil, errMalformedSignatureInput
+	}
+
+	ns := *s
+	ns.exact = true
+	ns.allHeaders = false
+	ns.order = nil
+	ns.headers = nil
+	for _, it := range il.Items {
+		c, err := componentFromItem(it)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.custom.validate(c.id()); err != nil {
+			return nil, err
+		}
+
+		ns.headers = append(ns.headers, c.id())
+		if c.name == "content-digest" {
+			ns.contentDigest = true
+		}
+	}
+
+	keyID, _ := il.Params.Get("keyid")
+	alg, _ := il.Params.Get("alg")
+
+	ns.keys = make(map[string]sigHolder)
+	for k, sh := range s.keys {
+		if id, ok := keyID.(string); ok && id != k {
+			continue
+		}
+
+		// Keys configured without an algorithm may still match.
+		if a, ok := alg.(string); ok && sh.alg != "" && sh.alg != a {
+			continue
+		}
+
+		ns.keys[k] = sh
+	}
+
+	if len(ns.keys) == 0 {
+		return nil, errNoAcceptableKey
+	}
+
+	if len(ns.keys) == 1 && ns.label == "" {
+		ns.label = d[0].Key
+	}
+
+	return &ns, nil
+}
+
+// acceptSignatureHeader returns the `Accept-Signature` header of a response that asks for a
+// signature, or an empty string.
+func acceptSignatureHeader(resp *http.Response) string {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return ""
+	}
+
+	return strings.Join(resp.Header.Values("Accept-Signature"), ", ")
+}
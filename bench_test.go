@@ -0,0 +1,87 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+// benchComponents are a realistic set of components for a request with a body.
+var benchComponents = []string{"@method", "@path", "@query", "@authority", "date", "host", "content-type", "content-length"}
+
+func benchSigner(sh sigHolder) *signer {
+	s := testSigner("bench-key", sh)
+	s.headers = benchComponents
+	return s
+}
+
+func benchmarkSign(b *testing.B, sh sigHolder) {
+	s := benchSigner(sh)
+	msg := testReq()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Sign(msg); err != nil {
+			b.Fatal("signing failed:", err)
+		}
+	}
+}
+
+func benchmarkVerify(b *testing.B, sh sigHolder, vh verHolder) {
+	msg := testReq()
+	signMessage(b, benchSigner(sh), msg)
+
+	v := testVerifier("bench-key", vh)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := v.Verify(msg); err != nil {
+			b.Fatal("verification failed:", err)
+		}
+	}
+}
+
+func benchEccKey(b *testing.B) *ecdsa.PrivateKey {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal("could not generate key:", err)
+	}
+
+	return pk
+}
+
+func BenchmarkSignHmacSha256(b *testing.B) {
+	benchmarkSign(b, signHmacSha256([]byte(testSecret)))
+}
+
+func BenchmarkSignEcdsaP256(b *testing.B) {
+	benchmarkSign(b, signEccP256(benchEccKey(b)))
+}
+
+func BenchmarkSignRsaPssSha512(b *testing.B) {
+	benchmarkSign(b, signRsaPssSha512(rsaTestKey(b)))
+}
+
+func BenchmarkVerifyHmacSha256(b *testing.B) {
+	secret := []byte(testSecret)
+	benchmarkVerify(b, signHmacSha256(secret), verifyHmacSha256(secret))
+}
+
+func BenchmarkVerifyEcdsaP256(b *testing.B) {
+	pk := benchEccKey(b)
+	benchmarkVerify(b, signEccP256(pk), verifyEccP256(&pk.PublicKey))
+}
+
+func BenchmarkVerifyRsaPssSha512(b *testing.B) {
+	pk := rsaTestKey(b)
+	benchmarkVerify(b, signRsaPssSha512(pk), verifyRsaPssSha512(&pk.PublicKey))
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	errNoKeyID        = errors.New("no key id set")
+	errUnknownAlg     = errors.New("unknown algorithm")
+	errKeyAlgMismatch = errors.New("key type does not match algorithm")
+)
+
+// SigningOptions builds a SigningOption for a single key, as an alternative to combining many
+// `With*` options. Each setter returns the SigningOptions, for chaining:
+//
+//	opt, err := httpsig.NewSigningOptions().
+//		SetKeyID("my-key").
+//		SetAlgorithm("ecdsa-p256-sha256").
+//		SetKey(pk).
+//		SetComponents("@method", "@path", "content-digest").
+//		SetCreated(true).
+//		Build()
+//
+// The zero value is ready to use.
+type SigningOptions struct {
+	keyID      string
+	alg        string
+	key        interface{}
+	components []string
+	expires    time.Duration
+	nonceFunc  func() string
+	created    bool
+}
+
+// NewSigningOptions returns an empty SigningOptions.
+func NewSigningOptions() *SigningOptions {
+	return &SigningOptions{}
+}
+
+// SetKeyID sets the key id signatures are made with.
+func (o *SigningOptions) SetKeyID(keyID string) *SigningOptions {
+	o.keyID = keyID
+	return o
+}
+
+// SetAlgorithm sets the name of the signing algorithm, eg `rsa-pss-sha512` or `hmac-sha256`.
+func (o *SigningOptions) SetAlgorithm(alg string) *SigningOptions {
+	o.alg = alg
+	return o
+}
+
+// SetKey sets the signing key: an *rsa.PrivateKey or *ecdsa.PrivateKey for asymmetric
+// algorithms, or a []byte secret for hmac algorithms.
+func (o *SigningOptions) SetKey(key interface{}) *SigningOptions {
+	o.key = key
+	return o
+}
+
+// SetComponents sets exactly the components signed, as with WithSigningComponents. If unset,
+// the transport's defaults are used.
+func (o *SigningOptions) SetComponents(components ...string) *SigningOptions {
+	o.components = components
+	return o
+}
+
+// SetExpiry sets how long signatures are valid for, as with WithExpires.
+func (o *SigningOptions) SetExpiry(d time.Duration) *SigningOptions {
+	o.expires = d
+	return o
+}
+
+// SetNonceFunc sets the nonce generator, as with WithNonce.
+func (o *SigningOptions) SetNonceFunc(fn func() string) *SigningOptions {
+	o.nonceFunc = fn
+	return o
+}
+
+// SetCreated sets whether signatures include their creation time, as with WithCreated.
+func (o *SigningOptions) SetCreated(created bool) *SigningOptions {
+	o.created = created
+	return o
+}
+
+// Build checks the configuration, returning a SigningOption that applies all of it. An error is
+// returned if the key id is missing, the algorithm is unknown, the key doesn't suit the
+// algorithm, or a component is invalid.
+func (o *SigningOptions) Build() (SigningOption, error) {
+	if o.keyID == "" {
+		return nil, errNoKeyID
+	}
+
+	sh, err := sigHolderFor(o.alg, o.key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range o.components {
+		if err := validateComponent(c); err != nil {
+			return nil, err
+		}
+	}
+
+	keyID := o.keyID
+	opts := []SigningOption{&optImpl{s: func(s *signer) { s.keys[keyID] = sh }}}
+
+	if o.components != nil {
+		opts = append(opts, WithSigningComponents(o.components...))
+	}
+
+	if o.expires != 0 {
+		opts = append(opts, WithExpires(o.expires))
+	}
+
+	if o.nonceFunc != nil {
+		opts = append(opts, WithNonce(o.nonceFunc))
+	}
+
+	if o.created {
+		opts = append(opts, WithCreated())
+	}
+
+	return &optImpl{
+		s: func(s *signer) {
+			for _, opt := range opts {
+				opt.configureSign(s)
+			}
+		},
+	}, nil
+}
+
+// sigHolderFor returns the signer for the named algorithm, using key.
+func sigHolderFor(alg string, key interface{}) (sigHolder, error) {
+	mismatch := fmt.Errorf("%w: %s, %T", errKeyAlgMismatch, alg, key)
+
+	switch alg {
+	case "rsa-pss-sha512", "rsa-pkcs1-sha256", "rsa-pkcs1-sha512":
+		pk, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return sigHolder{}, mismatch
+		}
+
+		switch alg {
+		case "rsa-pss-sha512":
+			return signRsaPssSha512(pk), nil
+		case "rsa-pkcs1-sha256":
+			return signRsaPkcs1Sha256(pk), nil
+		default:
+			return signRsaPkcs1Sha512(pk), nil
+		}
+	case "ecdsa-p256-sha256", "ecdsa-p384-sha384", "ecdsa-p521-sha512":
+		pk, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return sigHolder{}, mismatch
+		}
+
+		switch {
+		case alg == "ecdsa-p256-sha256" && pk.Curve == elliptic.P256():
+			return signEccP256(pk), nil
+		case alg == "ecdsa-p384-sha384" && pk.Curve == elliptic.P384():
+			return signEccP384(pk), nil
+		case alg == "ecdsa-p521-sha512" && pk.Curve == elliptic.P521():
+			return signEccP521(pk), nil
+		default:
+			return sigHolder{}, mismatch
+		}
+	case "hmac-sha256", "hmac-sha384", "hmac-sha512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return sigHolder{}, mismatch
+		}
+
+		switch alg {
+		case "hmac-sha256":
+			return signHmacSha256(secret), nil
+		case "hmac-sha384":
+			return signHmacSha384(secret), nil
+		default:
+			return signHmacSha512(secret), nil
+		}
+	default:
+		return sigHolder{}, fmt.Errorf("%w: %q", errUnknownAlg, alg)
+	}
+}
+
+var errNoVerifyKeys = errors.New("no verification keys or key resolver set")
+
+// VerifyOptions builds a VerifyOption, as an alternative to combining many `With*` options.
+// It can be passed between initialization steps that each add keys or settings. Each method
+// returns the VerifyOptions, for chaining:
+//
+//	opt, err := httpsig.NewVerifyOptions().
+//		AddEcdsaKey("my-key", pub).
+//		SetRequiredComponents("@method", "@path").
+//		Build()
+//
+// The zero value is ready to use.
+type VerifyOptions struct {
+	keys     map[string]verHolder
+	resolver KeyResolver
+	opts     []VerifyOption
+	err      error
+}
+
+// NewVerifyOptions returns an empty VerifyOptions.
+func NewVerifyOptions() *VerifyOptions {
+	return &VerifyOptions{}
+}
+
+func (o *VerifyOptions) addKey(keyID string, vh verHolder) {
+	if o.keys == nil {
+		o.keys = make(map[string]verHolder)
+	}
+	o.keys[keyID] = vh
+}
+
+// AddHmacSha256Key adds verification using `hmac-sha256` with the given shared secret, for
+// keyID.
+func (o *VerifyOptions) AddHmacSha256Key(keyID string, secret []byte) *VerifyOptions {
+	o.addKey(keyID, verifyHmacSha256(secret))
+	return o
+}
+
+// AddEcdsaKey adds verification with the given public key, for keyID. The algorithm is chosen
+// by the key's curve: `ecdsa-p256-sha256`, `ecdsa-p384-sha384`, or `ecdsa-p521-sha512`.
+// Keys on other curves, or nil keys, cause Build to fail.
+func (o *VerifyOptions) AddEcdsaKey(keyID string, pk *ecdsa.PublicKey) *VerifyOptions {
+	vh, err := ecdsaCurveVerHolder(pk)
+	if err != nil {
+		o.err = fmt.Errorf("%w for %q", err, keyID)
+		return o
+	}
+
+	o.addKey(keyID, vh)
+	return o
+}
+
+// SetKeyResolver sets a resolver for keys not otherwise added, as with WithKeyResolver.
+func (o *VerifyOptions) SetKeyResolver(r KeyResolver) *VerifyOptions {
+	o.resolver = r
+	return o
+}
+
+// SetClockSkew sets the tolerance for expired signatures, as with WithClockSkew.
+func (o *VerifyOptions) SetClockSkew(d time.Duration) *VerifyOptions {
+	o.opts = append(o.opts, WithClockSkew(d))
+	return o
+}
+
+// SetRequiredComponents sets the components signatures must cover, as with
+// WithRequiredComponents.
+func (o *VerifyOptions) SetRequiredComponents(components ...string) *VerifyOptions {
+	o.opts = append(o.opts, WithRequiredComponents(components...))
+	return o
+}
+
+// SetNonceStore sets the store used to reject replayed nonces, as with WithNonceStore.
+func (o *VerifyOptions) SetNonceStore(store NonceStore) *VerifyOptions {
+	o.opts = append(o.opts, WithNonceStore(store))
+	return o
+}
+
+// SetErrorHandler sets the middleware's response to rejected requests, as with
+// WithErrorHandler.
+func (o *VerifyOptions) SetErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) *VerifyOptions {
+	o.opts = append(o.opts, WithErrorHandler(fn))
+	return o
+}
+
+// Build checks the configuration, returning a VerifyOption that applies all of it. An error is
+// returned if no keys or key resolver are set, or if a key is unsupported.
+func (o *VerifyOptions) Build() (VerifyOption, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+
+	if len(o.keys) == 0 && o.resolver == nil {
+		return nil, errNoVerifyKeys
+	}
+
+	keys := make(map[string]verHolder, len(o.keys))
+	for k, vh := range o.keys {
+		keys[k] = vh
+	}
+
+	opts := append([]VerifyOption(nil), o.opts...)
+	if o.resolver != nil {
+		opts = append(opts, WithKeyResolver(o.resolver))
+	}
+
+	return &optImpl{
+		v: func(v *verifier) {
+			for k, vh := range keys {
+				v.keys[k] = vh
+			}
+
+			for _, opt := range opts {
+				opt.configureVerify(v)
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSigningOptions(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	now := time.Unix(1618884475, 0)
+
+	opt, err := NewSigningOptions().
+		SetKeyID("ecc-key").
+		SetAlgorithm("ecdsa-p256-sha256").
+		SetKey(pk).
+		SetComponents("@method", "@path").
+		SetExpiry(time.Minute).
+		SetNonceFunc(func() string { return "a-nonce" }).
+		SetCreated(true).
+		Build()
+	if err != nil {
+		t.Fatal("build failed:", err)
+	}
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewSignTransport(ct, opt, withNowFunc(func() time.Time { return now }))}
+
+	resp, err := client.Get("http://example.com/foo")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	expected := `sig1=("@method" "@path");created=1618884475;keyid="ecc-key";alg="ecdsa-p256-sha256";expires=1618884535;nonce="a-nonce"`
+	if got := ct.req.Header.Get("Signature-Input"); got != expected {
+		t.Errorf("unexpected signature input.\nExpected: %s\nGot:      %s", expected, got)
+	}
+
+	v := testVerifier("ecc-key", verifyEccP256(&pk.PublicKey))
+	if _, err := v.Verify(NewRequestMessage(ct.req)); err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestSigningOptions_Errors(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	tcs := []struct {
+		name string
+		opts *SigningOptions
+		err  error
+	}{
+		{"empty", NewSigningOptions(), errNoKeyID},
+		{"no algorithm", NewSigningOptions().SetKeyID("k").SetKey(secret), errUnknownAlg},
+		{"unknown algorithm", NewSigningOptions().SetKeyID("k").SetAlgorithm("rot13").SetKey(secret), errUnknownAlg},
+		{"no key", NewSigningOptions().SetKeyID("k").SetAlgorithm("hmac-sha256"), errKeyAlgMismatch},
+		{"wrong key type", NewSigningOptions().SetKeyID("k").SetAlgorithm("rsa-pss-sha512").SetKey(secret), errKeyAlgMismatch},
+		{"wrong curve", NewSigningOptions().SetKeyID("k").SetAlgorithm("ecdsa-p256-sha256").SetKey(p384), errKeyAlgMismatch},
+		{"bad component", NewSigningOptions().SetKeyID("k").SetAlgorithm("hmac-sha256").SetKey(secret).SetComponents("@nope"), errUnknownComponent},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.opts.Build(); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v. Got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyOptions(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	pk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	if _, err := NewVerifyOptions().SetClockSkew(time.Second).Build(); !errors.Is(err, errNoVerifyKeys) {
+		t.Error("expected no keys error. Got:", err)
+	}
+
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	if _, err := NewVerifyOptions().AddEcdsaKey("k", &p224.PublicKey).Build(); !errors.Is(err, errKeyAlgMismatch) {
+		t.Error("expected unsupported curve error. Got:", err)
+	}
+
+	if _, err := NewVerifyOptions().AddEcdsaKey("k", nil).Build(); !errors.Is(err, errInvalidKey) {
+		t.Error("expected invalid key error. Got:", err)
+	}
+
+	var handled error
+	opts := NewVerifyOptions().AddHmacSha256Key("hmac-key", secret)
+	opts.AddEcdsaKey("ecc-key", &pk.PublicKey).
+		SetRequiredComponents("@method").
+		SetErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handled = err
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+	opt, err := opts.Build()
+	if err != nil {
+		t.Fatal("build failed:", err)
+	}
+
+	for keyID, so := range map[string]SigningOption{"hmac-key": WithHmacSha256("hmac-key", secret), "ecc-key": WithSignEcdsaP384Sha384("ecc-key", pk)} {
+		ct := &captureTransport{}
+		client := http.Client{Transport: NewSignTransport(ct, so)}
+
+		resp, err := client.Get("http://example.com/")
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		req := ct.req.Clone(ct.req.Context())
+		req.RequestURI = "/"
+
+		rec := httptest.NewRecorder()
+		NewVerifyMiddleware(opt)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("verification with %s failed: %v", keyID, handled)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewVerifyMiddleware(opt)(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTeapot || !IsNotSignedError(handled) {
+		t.Errorf("expected custom error handler. Got: %d, %v", rec.Code, handled)
+	}
+}
@@ -5,41 +5,340 @@
 package httpsig
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"net"
 	"net/http"
 	nurl "net/url"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/ghoti143/httpsig/internal/sfv"
 )
 
-// message is a minimal representation of an HTTP request or response, containing the values
-// needed to construct a signature.
-type message struct {
-	Method    string
-	Authority string
-	URL       *nurl.URL
-	Header    http.Header
+// Message is a minimal representation of an HTTP request or response, containing the values
+// needed to construct a signature. Use NewRequestMessage or NewResponseMessage, or build one
+// directly for requests and responses held in other types, eg by a protocol adapter.
+//
+// Responses have a StatusCode, while requests have a Method and URL. Header holds the header
+// fields, and for requests, the `Host` header.
+type Message struct {
+	Method     string
+	Authority  string
+	URL        *nurl.URL
+	StatusCode int
+	Header     http.Header
+
+	// Request is the request a response is for, if known. It is needed for the
+	// `@request-response` component.
+	Request *Message
+
+	// HTTP2 is set for requests over HTTP/2, where the derived components come from
+	// pseudo-headers, eg `:path`, rather than the request line.
+	HTTP2 bool
+
+	// Proto is the protocol of a request, eg `HTTP/1.1`. It decides the form of the
+	// `@request-target` component.
+	Proto string
+
+	// Context is the context of a request, if known, eg for custom derived components that
+	// depend on routing.
+	Context context.Context
+
+	// Derived components registered with WithCustomDerivedComponent, by name.
+	custom customComponents
 }
 
-func messageFromRequest(r *http.Request) *message {
+// customComponents are derived components outside the standard, by name. See
+// WithCustomDerivedComponent.
+type customComponents map[string]func(msg *Message) (string, error)
+
+// validate is validateComponent, but also accepts the components of cc.
+func (cc customComponents) validate(in string) error {
+	c, err := parseComponent(in)
+	if err != nil {
+		return err
+	}
+
+	if cc[c.name] != nil {
+		return nil
+	}
+
+	return validateComponent(in)
+}
+
+// on returns msg, with the components of cc available for canonicalization.
+func (cc customComponents) on(msg *Message) *Message {
+	if len(cc) == 0 {
+		return msg
+	}
+
+	m := *msg
+	m.custom = cc
+	return &m
+}
+
+// NewRequestMessage returns the message for r. The headers are copied, so later changes to r
+// don't affect the message.
+func NewRequestMessage(r *http.Request) *Message {
 	hdr := r.Header.Clone()
 	hdr.Set("Host", r.Host)
-	return &message{
+
+	// Server requests don't carry the scheme on their URL.
+	u := r.URL
+	if u.Scheme == "" {
+		cu := *u
+		cu.Scheme = "http"
+		if r.TLS != nil {
+			cu.Scheme = "https"
+		}
+		u = &cu
+	}
+
+	// The request struct holds the values of the HTTP/2 pseudo-headers, as it does the request
+	// line and Host header of HTTP/1.x, so derived components are the same for either.
+	return &Message{
 		Method:    r.Method,
 		Authority: r.Host,
-		URL:       r.URL,
+		URL:       u,
 		Header:    hdr,
+		HTTP2:     r.ProtoMajor == 2,
+		Proto:     r.Proto,
+		Context:   r.Context(),
+	}
+}
+
+// component identifies a single component covered by a signature: either a header, or a
+// derived component (with a leading `@`). Components may also have parameters, such as the
+// `name` of `"@query-param";name="foo"`.
+type component struct {
+	name   string
+	params []componentParam
+}
+
+type componentParam struct {
+	key   string
+	value string
+}
+
+// param returns the value of the named component parameter, if set.
+func (c component) param(key string) (string, bool) {
+	for _, p := range c.params {
+		if p.key == key {
+			return p.value, true
+		}
+	}
+
+	return "", false
+}
+
+// String returns the component identifier, as it appears in an inner list and in the
+// signature base.
+func (c component) String() string {
+	// Invalid components fail later, when the signature params are serialized.
+	s, _ := sfv.SerializeItem(c.item())
+	return s
+}
+
+// item returns c as a structured field item: a string, with string parameters.
+func (c component) item() sfv.Item {
+	it := sfv.Item{Value: c.name}
+	for _, p := range c.params {
+		it.Params = append(it.Params, sfv.Param{Key: p.key, Value: p.value})
+	}
+
+	return it
+}
+
+// id returns the component identifier without quotes around the name, as used in options and
+// SignatureParams, eg `@query-param;name="foo"`.
+func (c component) id() string {
+	return c.name + c.paramString()
+}
+
+func (c component) paramString() string {
+	var o string
+	for _, p := range c.params {
+		v, _ := sfv.SerializeItem(sfv.Item{Value: p.value})
+		o += fmt.Sprintf(";%s=%s", p.key, v)
+	}
+
+	return o
+}
+
+var (
+	errMalformedComponent = errors.New("malformed component identifier")
+	errUnknownComponent   = errors.New("unknown derived component")
+)
+
+// derivedComponents are the derived components (section 2.3) that can be canonicalized.
+var derivedComponents = map[string]bool{
+	"@method":         true,
+	"@target-uri":     true,
+	"@request-target": true,
+	"@authority":      true,
+	"@scheme":         true,
+	"@path":           true,
+	"@query":          true,
+	"@query-param":    true,
+	"@status":         true,
+
+	"@request-response": true,
+}
+
+// validateComponent returns an error if in is malformed, or names an unknown derived component.
+func validateComponent(in string) error {
+	c, err := parseComponent(in)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(c.name, "@") && !derivedComponents[c.name] {
+		return fmt.Errorf("%w: %q", errUnknownComponent, c.name)
 	}
+
+	return nil
+}
+
+// parseComponent parses a component identifier, like `"@query-param";name="foo"`. The quotes
+// around the name are optional, so identifiers can be conveniently given in options, eg
+// `@query-param;name="foo"`.
+func parseComponent(in string) (component, error) {
+	if !strings.HasPrefix(in, `"`) {
+		name, params := in, ""
+		if i := strings.IndexByte(in, ';'); i >= 0 {
+			name, params = in[:i], in[i:]
+		}
+		in = `"` + name + `"` + params
+	}
+
+	it, err := sfv.ParseItem(in)
+	if err != nil {
+		return component{}, errMalformedComponent
+	}
+
+	return componentFromItem(it)
 }
 
+// componentFromItem returns the component identified by a structured field item. The name
+// must be a string. Parameter values may be strings, or tokens, eg `name=foo`.
+func componentFromItem(it sfv.Item) (component, error) {
+	name, ok := it.Value.(string)
+	if !ok || name == "" {
+		return component{}, errMalformedComponent
+	}
+
+	c := component{name: strings.ToLower(name)}
+	for _, p := range it.Params {
+		var v string
+		switch pv := p.Value.(type) {
+		case string:
+			v = pv
+		case sfv.Token:
+			v = string(pv)
+		default:
+			return component{}, errMalformedComponent
+		}
+
+		c.params = append(c.params, componentParam{key: p.Key, value: v})
+	}
+
+	return c, nil
+}
+
+// canonicalizeComponent writes the component c of msg, as used in the signature base.
+func canonicalizeComponent(out io.Writer, c component, msg *Message) error {
+	if fn := msg.custom[c.name]; fn != nil {
+		return canonicalizeCustom(out, c, fn, msg)
+	}
+
+	switch {
+	case isResponseComponent(c.name) && msg.StatusCode == 0:
+		return errNotResponse
+	case !isResponseComponent(c.name) && strings.HasPrefix(c.name, "@") && msg.URL == nil:
+		return errNotRequest
+	case c.name == "@request-target" && msg.HTTP2:
+		return errRequestTargetHTTP2
+	}
+
+	// handle specialty components, section 2.3
+	switch c.name {
+	case "@status":
+		return canonicalizeStatus(out, msg.StatusCode)
+	case "@method":
+		return canonicalizeMethod(out, msg.Method)
+	case "@path":
+		return canonicalizePath(out, msg.URL.Path)
+	case "@query":
+		return canonicalizeQuery(out, msg.URL.RawQuery)
+	case "@target-uri":
+		return canonicalizeTargetURI(out, msg)
+	case "@request-target":
+		return canonicalizeRequestTarget(out, msg)
+	case "@scheme":
+		return canonicalizeScheme(out, msg.URL.Scheme)
+	case "@authority":
+		return canonicalizeAuthority(out, normalizeAuthority(msg.Authority, msg.URL.Scheme))
+	case "@query-param":
+		return canonicalizeQueryParam(out, c, msg.URL.RawQuery)
+	case "@request-response":
+		return canonicalizeRequestResponse(out, c, msg.Request)
+	default:
+		// handle default (header) components
+		return canonicalizeHeader(out, c.name, msg.Header)
+	}
+}
+
+// canonicalizeCustom writes the custom derived component c, with the value from fn.
+func canonicalizeCustom(out io.Writer, c component, fn func(msg *Message) (string, error), msg *Message) error {
+	v, err := fn(msg)
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(v, "\r\n") {
+		return &HeaderValueError{Header: c.name, Err: errHeaderNewline}
+	}
+
+	_, err = fmt.Fprintf(out, "\"%s\"%s: %s\n", c.name, c.paramString(), v)
+	return err
+}
+
+// NewResponseMessage returns the message for r, including the message for r.Request if set.
+// The headers are copied, so later changes to r don't affect the message.
+func NewResponseMessage(r *http.Response) *Message {
+	msg := &Message{
+		StatusCode: r.StatusCode,
+		Header:     r.Header.Clone(),
+	}
+
+	if r.Request != nil {
+		msg.Request = NewRequestMessage(r.Request)
+	}
+
+	return msg
+}
+
+var (
+	errNotRequest  = errors.New("request component used on a response")
+	errNotResponse = errors.New("response component used on a request")
+
+	// @request-target has no equivalent in HTTP/2, which has no request line.
+	errRequestTargetHTTP2 = errors.New("@request-target is not valid for HTTP/2")
+)
+
 func canonicalizeHeader(out io.Writer, name string, hdr http.Header) error {
 	// XXX: Structured headers are not considered, and they should be :)
 	v := hdr.Values(name)
 	if len(v) == 0 { // empty values are permitted, but no values are not
-		return fmt.Errorf("'%s' header not found", name)
+		return &MissingHeaderError{Header: name}
 	}
 
 	// Section 2.1 covers canonicalizing headers.
@@ -52,6 +351,88 @@ func canonicalizeHeader(out io.Writer, name string, hdr http.Header) error {
 	return err
 }
 
+var (
+	errHeaderNewline = errors.New("contains a newline")
+	errHeaderControl = errors.New("contains a control character")
+)
+
+// rejectNewlines is the default header sanitizer. Newlines in a header value could be used to
+// inject extra lines into the signature base.
+func rejectNewlines(name, value string) (string, error) {
+	if strings.ContainsAny(value, "\r\n") {
+		return "", errHeaderNewline
+	}
+
+	return value, nil
+}
+
+// StrictHeaderSanitizer is a header sanitizer for WithHeaderSanitization that rejects header
+// values with newlines, or any other control characters, including tabs.
+func StrictHeaderSanitizer(name, value string) (string, error) {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '\r' || c == '\n' {
+			return "", errHeaderNewline
+		} else if c < 0x20 || c == 0x7f {
+			return "", errHeaderControl
+		}
+	}
+
+	return value, nil
+}
+
+// sanitizeHeaders returns msg with the values of the headers among items passed through
+// sanitize, or rejectNewlines if it is nil. msg is only copied if a value changes.
+func sanitizeHeaders(msg *Message, items []string, sanitize func(name, value string) (string, error)) (*Message, error) {
+	if sanitize == nil {
+		sanitize = rejectNewlines
+	}
+
+	var hdr http.Header
+	for _, item := range items {
+		c, err := parseComponent(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(c.name, "@") {
+			continue
+		}
+
+		values := msg.Header.Values(c.name)
+
+		var sanitized []string
+		for i, v := range values {
+			sv, err := sanitize(c.name, v)
+			if err != nil {
+				return nil, &HeaderValueError{Header: c.name, Err: err}
+			}
+
+			if sv != v && sanitized == nil {
+				sanitized = append([]string(nil), values...)
+			}
+
+			if sanitized != nil {
+				sanitized[i] = sv
+			}
+		}
+
+		if sanitized != nil {
+			if hdr == nil {
+				hdr = msg.Header.Clone()
+			}
+			hdr[http.CanonicalHeaderKey(c.name)] = sanitized
+		}
+	}
+
+	if hdr == nil {
+		return msg, nil
+	}
+
+	m := *msg
+	m.Header = hdr
+	return &m, nil
+}
+
 func canonicalizeMethod(out io.Writer, method string) error {
 	// Section 2.3.2 covers canonicalization of the method.
 	// Section 2.4 step 2 covers using it as input.
@@ -66,9 +447,94 @@ func canonicalizeAuthority(out io.Writer, authority string) error {
 	return err
 }
 
+func canonicalizeStatus(out io.Writer, status int) error {
+	// Section 2.3.9 covers canonicalization of the status code.
+	// Section 2.4 step 2 covers using it as input.
+	_, err := fmt.Fprintf(out, "\"@status\": %03d\n", status)
+	return err
+}
+
+// canonicalizeTargetURI writes the absolute target uri of msg. The target uri covers the
+// scheme, authority, path and query, so signing it makes those components redundant.
+func canonicalizeTargetURI(out io.Writer, msg *Message) error {
+	// Section 2.3.2 covers canonicalization of the target uri.
+	// Section 2.4 step 2 covers using it as input.
+	u := nurl.URL{
+		Scheme:   strings.ToLower(msg.URL.Scheme),
+		Host:     normalizeAuthority(msg.Authority, strings.ToLower(msg.URL.Scheme)),
+		Path:     msg.URL.Path,
+		RawPath:  msg.URL.RawPath,
+		RawQuery: msg.URL.RawQuery,
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	_, err := fmt.Fprintf(out, "\"@target-uri\": %s\n", u.String())
+	return err
+}
+
+// canonicalizeRequestTarget writes the request target of msg, as in its request line. HTTP/1.0
+// requests use the absolute form, as sent to proxies, and others the origin form. For example,
+// `GET http://example.com/path?q HTTP/1.0` has the target `http://example.com/path?q`, and
+// `GET /path?q HTTP/1.1` has the target `/path?q`.
+func canonicalizeRequestTarget(out io.Writer, msg *Message) error {
+	// Section 2.3.1 covers canonicalization of the request target.
+	// Section 2.4 step 2 covers using it as input.
+	u := nurl.URL{
+		Path:     msg.URL.Path,
+		RawPath:  msg.URL.RawPath,
+		RawQuery: msg.URL.RawQuery,
+	}
+
+	if msg.Proto == "HTTP/1.0" {
+		u.Scheme = strings.ToLower(msg.URL.Scheme)
+		u.Host = normalizeAuthority(msg.Authority, u.Scheme)
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	_, err := fmt.Fprintf(out, "\"@request-target\": %s\n", u.String())
+	return err
+}
+
+func canonicalizeScheme(out io.Writer, scheme string) error {
+	// Section 2.3.3 covers canonicalization of the scheme.
+	// Section 2.4 step 2 covers using it as input.
+	_, err := fmt.Fprintf(out, "\"@scheme\": %s\n", strings.ToLower(scheme))
+	return err
+}
+
+// normalizeAuthority lowercases the authority, and removes the port if it is the default for
+// the scheme.
+func normalizeAuthority(authority, scheme string) string {
+	authority = strings.ToLower(authority)
+
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil { // no port
+		return authority
+	}
+
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		if strings.Contains(host, ":") { // IPv6 addresses keep their brackets
+			return "[" + host + "]"
+		}
+		return host
+	}
+
+	return authority
+}
+
 func canonicalizePath(out io.Writer, path string) error {
 	// Section 2.3.7 covers canonicalization of the path.
 	// Section 2.4 step 2 covers using it as input.
+	if path == "" { // An empty path is the same as the root path.
+		path = "/"
+	}
+
 	_, err := fmt.Fprintf(out, "\"@path\": %s\n", path)
 	return err
 }
@@ -76,115 +542,327 @@ func canonicalizePath(out io.Writer, path string) error {
 func canonicalizeQuery(out io.Writer, rawQuery string) error {
 	// Section 2.3.8 covers canonicalization of the query.
 	// Section 2.4 step 2 covers using it as input.
-	_, err := fmt.Fprintf(out, "\"@query\": ?%s\n", rawQuery) // TODO: decode percent encodings
+	// The query is used as sent, so percent-encoded values are not decoded. An absent query
+	// is a lone "?".
+	_, err := fmt.Fprintf(out, "\"@query\": ?%s\n", rawQuery)
 	return err
 }
 
-func canonicalizeSignatureParams(out io.Writer, sp *signatureParams) error {
-	// Section 2.3.1 covers canonicalization of the signature parameters
+func canonicalizeQueryParam(out io.Writer, c component, rawQuery string) error {
+	// Section 2.3.9 covers canonicalization of query parameters.
+	// Section 2.4 step 2 covers using them as input.
+	name, ok := c.param("name")
+	if !ok {
+		return errMalformedComponent
+	}
 
-	// TODO: Deal with all the potential print errs. sigh.
+	// Names and values are compared and signed in a consistent encoding, regardless of how
+	// they were encoded in the query.
+	dname, err := nurl.QueryUnescape(name)
+	if err != nil {
+		return errMalformedComponent
+	}
 
-	_, err := fmt.Fprintf(out, "\"@signature-params\": %s", sp.canonicalize())
+	q, err := nurl.ParseQuery(rawQuery)
 	if err != nil {
 		return err
 	}
 
-	return err
+	v, ok := q[dname]
+	if !ok {
+		return fmt.Errorf("query param '%s' not found", dname)
+	}
+
+	c = component{name: c.name, params: []componentParam{{key: "name", value: encodeQueryParam(dname)}}}
+
+	// Repeated params are each included, in order.
+	for _, sv := range v {
+		if _, err := fmt.Fprintf(out, "%s: %s\n", c, encodeQueryParam(sv)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-type signatureParams struct {
-	items   []string
-	keyID   string
-	alg     string
-	created time.Time
-	expires *time.Time
-	nonce   string
+// isResponseComponent reports whether name is a derived component that only applies to
+// responses.
+func isResponseComponent(name string) bool {
+	return name == "@status" || name == "@request-response"
 }
 
-func (sp *signatureParams) canonicalize() string {
-	li := make([]string, 0, len(sp.items))
-	for _, i := range sp.items {
-		li = append(li, fmt.Sprintf("\"%s\"", strings.ToLower(i)))
+// canonicalizeRequestResponse writes the signature of req with the label given by the key
+// parameter of c. This binds a response signature to the request it answers.
+func canonicalizeRequestResponse(out io.Writer, c component, req *Message) error {
+	// Section 2.3.10 covers canonicalization of the request-response binding.
+	// Section 2.4 step 2 covers using it as input.
+	key, ok := c.param("key")
+	if !ok {
+		return errMalformedComponent
 	}
-	o := fmt.Sprintf("(%s)", strings.Join(li, " "))
 
-	// Items comes first. The params afterwards can be in any order. The order chosen here
-	// matches what's in the examples in the standard, aiding in testing.
+	if req == nil || len(req.Header.Values("Signature")) == 0 {
+		return &MissingHeaderError{Header: "signature"}
+	}
 
-	o += fmt.Sprintf(";created=%d", sp.created.Unix())
+	sigs, err := sfv.ParseDictionary(strings.Join(req.Header.Values("Signature"), ", "))
+	if err != nil {
+		return errMalformedSignature
+	}
 
-	if sp.keyID != "" {
-		o += fmt.Sprintf(";keyid=\"%s\"", sp.keyID)
+	m, ok := sigs.Get(key)
+	if !ok {
+		return fmt.Errorf("request signature '%s' not found", key)
 	}
 
-	if sp.alg != "" {
-		o += fmt.Sprintf(";alg=\"%s\"", sp.alg)
+	it, ok := m.(sfv.Item)
+	if !ok {
+		return errMalformedSignature
 	}
 
-	if sp.expires != nil {
-		o += fmt.Sprintf(";expires=%d", sp.expires.Unix())
+	if _, ok := it.Value.([]byte); !ok {
+		return errMalformedSignature
 	}
 
-	return o
+	sig, err := sfv.SerializeItem(sfv.Item{Value: it.Value})
+	if err != nil {
+		return err
+	}
+
+	c = component{name: c.name, params: []componentParam{{key: "key", value: key}}}
+
+	_, err = fmt.Fprintf(out, "%s: %s\n", c, sig)
+	return err
 }
 
-var errMalformedSignatureInput = errors.New("malformed signature-input header")
+func encodeQueryParam(in string) string {
+	return strings.ReplaceAll(nurl.QueryEscape(in), "+", "%20")
+}
 
-func parseSignatureInput(in string) (*signatureParams, error) {
-	sp := &signatureParams{}
+// SigningBase returns the signature base of msg for params: the exact bytes that are signed,
+// or verified. Compare signature bases to debug signatures that fail to verify between
+// implementations.
+func SigningBase(params *SignatureParams, msg *Message) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeSigningBase(&b, params, msg); err != nil {
+		return nil, err
+	}
 
-	parts := strings.Split(in, ";")
-	if len(parts) < 1 {
-		return nil, errMalformedSignatureInput
+	return b.Bytes(), nil
+}
+
+// writeSigningBase writes the signature base of msg for params to b.
+func writeSigningBase(b *bytes.Buffer, params *SignatureParams, msg *Message) error {
+	// Section 2.3 covers creating the signature base.
+	for _, item := range params.Items {
+		c, err := parseComponent(item)
+		if err != nil {
+			return err
+		}
+
+		if err := canonicalizeComponent(b, c, msg); err != nil {
+			return err
+		}
 	}
 
-	if parts[0][0] != '(' || parts[0][len(parts[0])-1] != ')' {
-		return nil, errMalformedSignatureInput
+	return canonicalizeSignatureParams(b, params)
+}
+
+// basePool holds buffers for signature bases. Most signature bases are well under 1KB.
+var basePool = &sync.Pool{
+	New: func() interface{} { return bytes.NewBuffer(make([]byte, 0, 1024)) },
+}
+
+// getBuffer returns an empty buffer from basePool.
+func getBuffer() *bytes.Buffer {
+	b := basePool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+// putBuffer returns b to basePool. b must not be used afterwards.
+func putBuffer(b *bytes.Buffer) {
+	basePool.Put(b)
+}
+
+// hashPool holds hashes of one kind, so signing and verifying don't allocate one per message.
+type hashPool struct {
+	p sync.Pool
+}
+
+func newHashPool(newHash func() hash.Hash) *hashPool {
+	return &hashPool{p: sync.Pool{New: func() interface{} { return newHash() }}}
+}
+
+// get returns a reset hash from hp.
+func (hp *hashPool) get() hash.Hash {
+	h := hp.p.Get().(hash.Hash)
+	h.Reset()
+	return h
+}
+
+// put returns h to hp. h must not be used afterwards.
+func (hp *hashPool) put(h hash.Hash) {
+	hp.p.Put(h)
+}
+
+var (
+	sha256Pool = newHashPool(sha256.New)
+	sha384Pool = newHashPool(sha512.New384)
+	sha512Pool = newHashPool(sha512.New)
+)
+
+func canonicalizeSignatureParams(out io.Writer, sp *SignatureParams) error {
+	// Section 2.3.1 covers canonicalization of the signature parameters
+	il, err := sp.serialize()
+	if err != nil {
+		return err
 	}
 
-	if len(parts[0]) > 2 { // not empty
-		// TODO: headers can't have spaces, but it should still be handled
-		items := strings.Split(parts[0][1:len(parts[0])-1], " ")
+	_, err = fmt.Fprintf(out, "\"@signature-params\": %s", il)
+	return err
+}
 
-		// TODO: error when not quoted
-		for i := range items {
-			items[i] = strings.Trim(items[i], `"`)
+// SignatureParams are the parameters of a single signature, as found in the
+// `Signature-Input` header: the covered components, and the signature's metadata.
+type SignatureParams struct {
+	// Items are the component identifiers covered by the signature, in order, as given to
+	// WithHeaders. Headers are lower case names, eg `content-type`, and derived components
+	// start with `@`, eg `@method` or `@query-param;name="foo"`.
+	Items []string
+
+	KeyID   string
+	Alg     string
+	Created *time.Time
+	Expires *time.Time
+	Nonce   string
+
+	// Parameters not otherwise known, kept so they are covered by the signature base.
+	extra sfv.Params
+}
+
+// String returns sp serialized as a `Signature-Input` value, without a label. It is empty if sp
+// can't be serialized, eg if a parameter contains non-ASCII characters.
+func (sp *SignatureParams) String() string {
+	s, _ := sp.serialize()
+	return s
+}
+
+func (sp *SignatureParams) serialize() (string, error) {
+	il, err := sp.innerList()
+	if err != nil {
+		return "", err
+	}
+
+	return sfv.SerializeInnerList(il)
+}
+
+// innerList returns sp as a structured field inner list, with the signature metadata as its
+// parameters.
+func (sp *SignatureParams) innerList() (sfv.InnerList, error) {
+	var il sfv.InnerList
+	for _, i := range sp.Items {
+		c, err := parseComponent(i)
+		if err != nil {
+			return sfv.InnerList{}, err
 		}
+		il.Items = append(il.Items, c.item())
+	}
+
+	// Items comes first. The params afterwards can be in any order. The order chosen here
+	// matches what's in the examples in the standard, aiding in testing.
 
-		sp.items = items
+	if sp.Created != nil {
+		il.Params = append(il.Params, sfv.Param{Key: "created", Value: sp.Created.Unix()})
 	}
 
-	for _, param := range parts[1:] {
-		paramParts := strings.Split(param, "=")
-		if len(paramParts) != 2 {
+	if sp.KeyID != "" {
+		il.Params = append(il.Params, sfv.Param{Key: "keyid", Value: sp.KeyID})
+	}
+
+	if sp.Alg != "" {
+		il.Params = append(il.Params, sfv.Param{Key: "alg", Value: sp.Alg})
+	}
+
+	if sp.Expires != nil {
+		il.Params = append(il.Params, sfv.Param{Key: "expires", Value: sp.Expires.Unix()})
+	}
+
+	if sp.Nonce != "" {
+		il.Params = append(il.Params, sfv.Param{Key: "nonce", Value: sp.Nonce})
+	}
+
+	il.Params = append(il.Params, sp.extra...)
+
+	return il, nil
+}
+
+var errMalformedSignatureInput = errors.New("malformed signature-input header")
+
+// ParseSignatureInput parses a single, unlabelled, `Signature-Input` value, such as
+// `("@method" "date");keyid="my-key";created=1618884475`. Unknown parameters are ignored, for
+// compatibility with future extensions, though they are still covered by the signature.
+func ParseSignatureInput(in string) (*SignatureParams, error) {
+	return parseSignatureInput(in, false)
+}
+
+// parseSignatureInput is ParseSignatureInput, but rejects unknown parameters if strict.
+func parseSignatureInput(in string, strict bool) (*SignatureParams, error) {
+	il, err := sfv.ParseInnerList(in)
+	if err != nil {
+		return nil, errMalformedSignatureInput
+	}
+
+	return signatureParamsFromInnerList(il, strict)
+}
+
+// signatureParamsFromInnerList returns the signature params of a parsed `Signature-Input` value.
+// Unknown parameters are rejected if strict.
+func signatureParamsFromInnerList(il sfv.InnerList, strict bool) (*SignatureParams, error) {
+	sp := &SignatureParams{}
+	for _, it := range il.Items {
+		c, err := componentFromItem(it)
+		if err != nil {
 			return nil, errMalformedSignatureInput
 		}
 
-		// TODO: error when not wrapped in quotes
-		switch paramParts[0] {
-		case "alg":
-			sp.alg = strings.Trim(paramParts[1], `"`)
-		case "keyid":
-			sp.keyID = strings.Trim(paramParts[1], `"`)
-		case "nonce":
-			sp.nonce = strings.Trim(paramParts[1], `"`)
-		case "created":
-			i, err := strconv.ParseInt(paramParts[1], 10, 64)
-			if err != nil {
+		sp.Items = append(sp.Items, c.id())
+	}
+
+	for _, p := range il.Params {
+		switch p.Key {
+		case "alg", "keyid", "nonce":
+			v, ok := p.Value.(string)
+			if !ok {
 				return nil, errMalformedSignatureInput
 			}
-			sp.created = time.Unix(i, 0)
-		case "expires":
-			i, err := strconv.ParseInt(paramParts[1], 10, 64)
-			if err != nil {
+
+			switch p.Key {
+			case "alg":
+				sp.Alg = v
+			case "keyid":
+				sp.KeyID = v
+			default:
+				sp.Nonce = v
+			}
+		case "created", "expires":
+			i, ok := p.Value.(int64)
+			if !ok {
 				return nil, errMalformedSignatureInput
 			}
+
 			t := time.Unix(i, 0)
-			sp.expires = &t
+			if p.Key == "created" {
+				sp.Created = &t
+			} else {
+				sp.Expires = &t
+			}
 		default:
-			// TODO: unknown params could be kept? hard to say.
-			return nil, errMalformedSignatureInput
+			if strict {
+				return nil, errMalformedSignatureInput
+			}
+
+			sp.extra = append(sp.extra, p)
 		}
 	}
 
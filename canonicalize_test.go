@@ -0,0 +1,490 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalizeComponent(t *testing.T) {
+	tcs := []struct {
+		name string
+		msg  func() *Message
+		out  string
+	}{
+		{"@method", testReq, "\"@method\": POST\n"},
+		{"@path", testReq, "\"@path\": /foo\n"},
+		{"@path", testMsg("https://example.com"), "\"@path\": /\n"},
+		{"@path", testMsg("https://example.com/a/b/c?x=y"), "\"@path\": /a/b/c\n"},
+		{"@target-uri", testReq, "\"@target-uri\": https://example.com/foo?param=value&pet=dog\n"},
+		{"@target-uri", testTargetURI("https://user@Example.com:443/a%2Fb?x=%20y"), "\"@target-uri\": https://example.com/a%2Fb?x=%20y\n"},
+		{"@target-uri", testTargetURI("/just/a/path"), "\"@target-uri\": http://example.com/just/a/path\n"},
+		{"@request-target", testReq, "\"@request-target\": /foo?param=value&pet=dog\n"},
+		{`@query-param;name="pet"`, testReq, "\"@query-param\";name=\"pet\": dog\n"},
+		{`"@query-param";name="pet"`, testReq, "\"@query-param\";name=\"pet\": dog\n"},
+		{`@query-param;name="a"`, testMsg("https://example.com/?a=1&b=2&a=3"), "\"@query-param\";name=\"a\": 1\n\"@query-param\";name=\"a\": 3\n"},
+		{`@query-param;name="qux"`, testMsg("https://example.com/?qux="), "\"@query-param\";name=\"qux\": \n"},
+		{`@query-param;name="var"`, testMsg("https://example.com/?var=this%20is%20a%20big%0Avalue"), "\"@query-param\";name=\"var\": this%20is%20a%20big%0Avalue\n"},
+		{`@query-param;name="bar"`, testMsg("https://example.com/?bar=with+plus+whitespace"), "\"@query-param\";name=\"bar\": with%20plus%20whitespace\n"},
+		{`@query-param;name="fa%C3%A7ade%22%3A%20"`, testMsg("https://example.com/?fa%C3%A7ade%22%3A%20=something"), "\"@query-param\";name=\"fa%C3%A7ade%22%3A%20\": something\n"},
+		{"@scheme", testReq, "\"@scheme\": https\n"},
+		{"@scheme", testMsg("HTTP://example.com/foo"), "\"@scheme\": http\n"},
+		{"@authority", testAuthority("example.com", "https"), "\"@authority\": example.com\n"},
+		{"@authority", testAuthority("Example.COM", "https"), "\"@authority\": example.com\n"},
+		{"@authority", testAuthority("example.com:8080", "https"), "\"@authority\": example.com:8080\n"},
+		{"@authority", testAuthority("example.com:443", "https"), "\"@authority\": example.com\n"},
+		{"@authority", testAuthority("example.com:80", "http"), "\"@authority\": example.com\n"},
+		{"@authority", testAuthority("example.com:80", "https"), "\"@authority\": example.com:80\n"},
+		{"@authority", testAuthority("[2001:db8::1]", "https"), "\"@authority\": [2001:db8::1]\n"},
+		{"@authority", testAuthority("[2001:DB8::1]:8443", "https"), "\"@authority\": [2001:db8::1]:8443\n"},
+		{"@authority", testAuthority("[2001:db8::1]:443", "https"), "\"@authority\": [2001:db8::1]\n"},
+		{"@query", testMsg("https://example.com/foo"), "\"@query\": ?\n"},
+		{"@query", testMsg("https://example.com/foo?foo=bar"), "\"@query\": ?foo=bar\n"},
+		{"@query", testMsg("https://example.com/foo?foo=bar&baz=1"), "\"@query\": ?foo=bar&baz=1\n"},
+		{"@query", testMsg("https://example.com/foo?q=a%20b%26c&x=%2F"), "\"@query\": ?q=a%20b%26c&x=%2F\n"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := parseComponent(tc.name)
+			if err != nil {
+				t.Fatal("could not parse component:", err)
+			}
+
+			var b bytes.Buffer
+			if err := canonicalizeComponent(&b, c, tc.msg()); err != nil {
+				t.Fatal("canonicalization failed:", err)
+			}
+
+			if b.String() != tc.out {
+				t.Errorf("expected %q. Got: %q", tc.out, b.String())
+			}
+		})
+	}
+}
+
+func testMsg(url string) func() *Message {
+	return func() *Message {
+		msg := testReq()
+		msg.URL = parse(url)
+		return msg
+	}
+}
+
+func testAuthority(authority, scheme string) func() *Message {
+	return func() *Message {
+		msg := testReq()
+		msg.Authority = authority
+		msg.URL.Scheme = scheme
+		return msg
+	}
+}
+
+func testTargetURI(target string) func() *Message {
+	return func() *Message {
+		r := httptest.NewRequest("GET", target, nil)
+		if r.URL.Host == "" {
+			r.Host = "example.com"
+		}
+		return NewRequestMessage(r)
+	}
+}
+
+// testTamper signs req covering the given components, tampers with it, and returns the
+// verification error.
+func testTamper(t *testing.T, req *Message, components []string, tamper func(*Message)) error {
+	t.Helper()
+
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.headers = components
+
+	signMessage(t, s, req)
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	if _, err := v.Verify(req); err != nil {
+		t.Fatal("verification of untampered request failed:", err)
+	}
+
+	tamper(req)
+
+	_, err := v.Verify(req)
+	return err
+}
+
+func TestCanonicalizeMethod_Replay(t *testing.T) {
+	req := testReq()
+	req.Method = "GET"
+
+	err := testTamper(t, req, []string{"@method", "date", "content-type"}, func(m *Message) { m.Method = "POST" })
+	if !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestCanonicalizePath_Tampered(t *testing.T) {
+	err := testTamper(t, testReq(), []string{"@path", "date"}, func(m *Message) { m.URL = parse("https://example.com/bar") })
+	if !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestCanonicalizeQuery_Tampered(t *testing.T) {
+	err := testTamper(t, testReq(), []string{"@query", "date"}, func(m *Message) {
+		m.URL = parse("https://example.com/foo?param=value&pet=cat")
+	})
+	if !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestCanonicalizeScheme_Downgrade(t *testing.T) {
+	err := testTamper(t, testReq(), []string{"@scheme", "@path", "date"}, func(m *Message) { m.URL.Scheme = "http" })
+	if !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestMessageFromRequest_Scheme(t *testing.T) {
+	req := httptest.NewRequest("GET", "/foo", nil)
+	if msg := NewRequestMessage(req); msg.URL.Scheme != "http" {
+		t.Error("expected http scheme. Got:", msg.URL.Scheme)
+	}
+
+	req = httptest.NewRequest("GET", "https://example.com/foo", nil)
+	req.URL.Scheme = "" // as received by a server
+	if msg := NewRequestMessage(req); msg.URL.Scheme != "https" {
+		t.Error("expected https scheme. Got:", msg.URL.Scheme)
+	}
+
+	if req.URL.Scheme != "" {
+		t.Error("request url was modified")
+	}
+}
+
+func TestCanonicalizeRequestTarget(t *testing.T) {
+	tcs := []struct {
+		raw string
+		out string
+	}{
+		{"GET http://example.com/path?q=1 HTTP/1.0\r\n\r\n", "\"@request-target\": http://example.com/path?q=1\n"},
+		{"GET http://Example.com:80 HTTP/1.0\r\n\r\n", "\"@request-target\": http://example.com/\n"},
+		{"GET /path?q=1 HTTP/1.1\r\nHost: example.com\r\n\r\n", "\"@request-target\": /path?q=1\n"},
+		{"GET http://example.com/path?q=1 HTTP/1.1\r\n\r\n", "\"@request-target\": /path?q=1\n"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(strings.SplitN(tc.raw, "\r", 2)[0], func(t *testing.T) {
+			r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tc.raw)))
+			if err != nil {
+				t.Fatal("could not read request:", err)
+			}
+
+			var b bytes.Buffer
+			if err := canonicalizeComponent(&b, component{name: "@request-target"}, NewRequestMessage(r)); err != nil {
+				t.Fatal("canonicalization failed:", err)
+			}
+
+			if b.String() != tc.out {
+				t.Errorf("expected %q. Got: %q", tc.out, b.String())
+			}
+		})
+	}
+}
+
+func TestCanonicalizeStatus(t *testing.T) {
+	var b bytes.Buffer
+	if err := canonicalizeComponent(&b, component{name: "@status"}, &Message{StatusCode: 200}); err != nil {
+		t.Fatal("canonicalization failed:", err)
+	}
+
+	if b.String() != "\"@status\": 200\n" {
+		t.Error("unexpected canonicalization. Got:", b.String())
+	}
+
+	if err := canonicalizeComponent(&b, component{name: "@status"}, testReq()); err != errNotResponse {
+		t.Error("expected @status on a request to fail. Got:", err)
+	}
+
+	if err := canonicalizeComponent(&b, component{name: "@path"}, &Message{StatusCode: 200}); err != errNotRequest {
+		t.Error("expected @path on a response to fail. Got:", err)
+	}
+}
+
+func TestCanonicalizeQueryParam_Missing(t *testing.T) {
+	var b bytes.Buffer
+
+	c, _ := parseComponent(`@query-param;name="missing"`)
+	if err := canonicalizeComponent(&b, c, testReq()); err == nil {
+		t.Error("expected missing query param to fail")
+	}
+
+	if err := canonicalizeComponent(&b, component{name: "@query-param"}, testReq()); err != errMalformedComponent {
+		t.Error("expected query param without a name to fail. Got:", err)
+	}
+}
+
+func TestCanonicalizeQueryParam_Tampered(t *testing.T) {
+	err := testTamper(t, testReq(), []string{`@query-param;name="pet"`, "date"}, func(m *Message) {
+		m.URL = parse("https://example.com/foo?param=value&pet=cat")
+	})
+	if !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+
+	// Params not covered by the signature can change.
+	err = testTamper(t, testReq(), []string{`@query-param;name="pet"`, "date"}, func(m *Message) {
+		m.URL = parse("https://example.com/foo?param=other&pet=dog")
+	})
+	if err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestParseSignatureInput_Components(t *testing.T) {
+	sp, err := ParseSignatureInput(`("@query-param";name="pet" "date");created=1618884475;keyid="test-key"`)
+	if err != nil {
+		t.Fatal("parse failed:", err)
+	}
+
+	if len(sp.Items) != 2 || sp.Items[0] != `@query-param;name="pet"` || sp.Items[1] != "date" {
+		t.Fatalf("unexpected items: %v", sp.Items)
+	}
+
+	if sp.KeyID != "test-key" {
+		t.Error("unexpected key id. Got:", sp.KeyID)
+	}
+
+	if got := sp.String(); got != `("@query-param";name="pet" "date");created=1618884475;keyid="test-key"` {
+		t.Error("params did not round trip. Got:", got)
+	}
+
+	for _, in := range []string{"", "(", `"date"`, `("date")junk`, `("date";)`} {
+		if _, err := ParseSignatureInput(in); err == nil {
+			t.Errorf("expected %q to fail", in)
+		}
+	}
+}
+
+func TestSignatureParams_QuotedStrings(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	tcs := []struct {
+		keyID string
+		out   string
+	}{
+		{"key with spaces", `keyid="key with spaces"`},
+		{`back\slash`, `keyid="back\\slash"`},
+		{`"quoted"`, `keyid="\"quoted\""`},
+		{`a \"mix\" of, both;`, `keyid="a \\\"mix\\\" of, both;"`},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.keyID, func(t *testing.T) {
+			sp := &SignatureParams{Items: []string{"date"}, KeyID: tc.keyID}
+			if got := sp.String(); got != `("date");`+tc.out {
+				t.Errorf("unexpected serialization. Got: %s", got)
+			}
+
+			parsed, err := ParseSignatureInput(sp.String())
+			if err != nil || parsed.KeyID != tc.keyID {
+				t.Errorf("key id did not round trip. Got: %q %v", parsed, err)
+			}
+
+			req := testReq()
+			signMessage(t, testSigner(tc.keyID, signHmacSha256(secret)), req)
+
+			if _, err := testVerifier(tc.keyID, verifyHmacSha256(secret)).Verify(req); err != nil {
+				t.Error("verification failed:", err)
+			}
+		})
+	}
+
+	// Strings are limited to printable ASCII.
+	if _, err := testSigner("caf\u00e9", signHmacSha256(secret)).Sign(testReq()); err == nil {
+		t.Error("expected non-ASCII key id to fail")
+	}
+}
+
+func TestSignatureParams_RoundTrip(t *testing.T) {
+	created := time.Unix(1618884475, 0)
+	expires := created.Add(time.Minute)
+
+	for _, sp := range []*SignatureParams{
+		{},
+		{Items: []string{"@method", "@path", "content-type"}, KeyID: "test-key"},
+		{
+			Items:   []string{`@query-param;name="pet"`, "date"},
+			KeyID:   "test-key",
+			Alg:     "hmac-sha256",
+			Created: &created,
+			Expires: &expires,
+			Nonce:   "b3k2pp5k7z-50gnwp.yemd",
+		},
+	} {
+		parsed, err := ParseSignatureInput(sp.String())
+		if err != nil {
+			t.Fatalf("could not parse %q: %s", sp, err)
+		}
+
+		reparsed, err := ParseSignatureInput(parsed.String())
+		if err != nil {
+			t.Fatalf("could not parse %q: %s", parsed, err)
+		}
+
+		for _, got := range []*SignatureParams{parsed, reparsed} {
+			if strings.Join(got.Items, " ") != strings.Join(sp.Items, " ") {
+				t.Errorf("items did not round trip. Expected %v, got %v", sp.Items, got.Items)
+			}
+
+			if got.KeyID != sp.KeyID || got.Alg != sp.Alg || got.Nonce != sp.Nonce {
+				t.Errorf("params did not round trip. Expected %q, got %q", sp, got)
+			}
+
+			if !timeEqual(got.Created, sp.Created) || !timeEqual(got.Expires, sp.Expires) {
+				t.Errorf("times did not round trip. Expected %q, got %q", sp, got)
+			}
+		}
+	}
+}
+
+func timeEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+func TestSigningBase(t *testing.T) {
+	// Capture the signature base as written by the signer.
+	var signed bytes.Buffer
+	sh := sigHolder{
+		alg: "test-alg",
+		signer: func() sigImpl {
+			return sigImpl{w: &signed, sign: func() ([]byte, error) { return []byte("sig"), nil }}
+		},
+	}
+
+	s := testSigner("test-key", sh)
+	s.headers = []string{"@method", "@query-param;name=pet", "date"}
+
+	req := testReq()
+	signMessage(t, s, req)
+
+	sp, err := ParseSignatureInput(strings.TrimPrefix(req.Header.Get("Signature-Input"), "sig1="))
+	if err != nil {
+		t.Fatal("could not parse signature input:", err)
+	}
+
+	base, err := SigningBase(sp, req)
+	if err != nil {
+		t.Fatal("could not create signing base:", err)
+	}
+
+	if !bytes.Equal(base, signed.Bytes()) {
+		t.Errorf("signing bases differ.\nSigned:\n%s\nGot:\n%s", signed.Bytes(), base)
+	}
+
+	expected := `"@method": POST
+"@query-param";name="pet": dog
+"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@signature-params": ("@method" "@query-param";name="pet" "date");created=1618884475;keyid="test-key";alg="test-alg"`
+	if string(base) != expected {
+		t.Errorf("unexpected signing base. Got:\n%s", base)
+	}
+}
+
+func TestCanonicalizeHeader_MultipleValues(t *testing.T) {
+	hdr := http.Header{}
+	hdr.Add("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	hdr.Add("Date", "Tue, 20 Apr 2021 02:07:56 GMT")
+	hdr.Add("X-Custom", "one")
+	hdr.Add("X-Custom", "  two ")
+	hdr.Add("X-Custom", "three")
+
+	tcs := []struct {
+		name string
+		out  string
+	}{
+		{"date", "\"date\": Tue, 20 Apr 2021 02:07:55 GMT, Tue, 20 Apr 2021 02:07:56 GMT\n"},
+		{"X-Custom", "\"x-custom\": one, two, three\n"},
+	}
+
+	for _, tc := range tcs {
+		var b bytes.Buffer
+		if err := canonicalizeHeader(&b, tc.name, hdr); err != nil {
+			t.Fatal("canonicalization failed:", err)
+		}
+
+		if b.String() != tc.out {
+			t.Errorf("unexpected canonicalization of %s. Got: %q", tc.name, b.String())
+		}
+	}
+
+	// Values are signed in order, so reordering them invalidates the signature.
+	req := testReq()
+	req.Header.Add("X-Custom", "one")
+	req.Header.Add("X-Custom", "two")
+
+	err := testTamper(t, req, []string{"x-custom"}, func(m *Message) {
+		m.Header.Set("X-Custom", "two")
+		m.Header.Add("X-Custom", "one")
+	})
+	if !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestSignatureParams_Serialization(t *testing.T) {
+	sp := &SignatureParams{Items: []string{"@method"}, KeyID: `a "quoted" \key`}
+	if got, want := sp.String(), `("@method");keyid="a \"quoted\" \\key"`; got != want {
+		t.Errorf("unexpected serialization.\nExpected: %s\nGot:      %s", want, got)
+	}
+
+	sp = &SignatureParams{Items: []string{"@method"}, KeyID: "clé"}
+	if got := sp.String(); got != "" {
+		t.Error("expected non-ASCII key id to not serialize. Got:", got)
+	}
+
+	msg := &Message{Method: "GET", URL: &url.URL{Path: "/"}, Header: http.Header{}}
+	if _, err := SigningBase(sp, msg); err == nil {
+		t.Error("expected signing base to fail for a non-ASCII key id")
+	}
+}
+
+func TestParseSignatureInput_StructuredValues(t *testing.T) {
+	sp, err := ParseSignatureInput(`("@query-param";name="a;b" "date");keyid="a, \"quoted\" key";nonce="x;y=z"`)
+	if err != nil {
+		t.Fatal("parse failed:", err)
+	}
+
+	if sp.KeyID != `a, "quoted" key` || sp.Nonce != "x;y=z" {
+		t.Errorf("unexpected params: %q, %q", sp.KeyID, sp.Nonce)
+	}
+
+	c, err := parseComponent(sp.Items[0])
+	if err != nil {
+		t.Fatal("could not parse item:", err)
+	}
+
+	if v, _ := c.param("name"); v != "a;b" {
+		t.Error("unexpected query param name. Got:", v)
+	}
+
+	for _, in := range []string{`(date)`, `("date");created="1618884475"`, `("date");keyid=1`, `("date" 1)`} {
+		if _, err := ParseSignatureInput(in); err == nil {
+			t.Errorf("expected %q to fail", in)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+)
+
+// NewChainVerifier returns an option that verifies messages with each of verifiers in turn
+// when they can't be verified otherwise, eg for gateways relaying requests from clients with
+// HMAC keys and clients with ECDSA keys. Each of verifiers is an independent configuration, as
+// built with VerifyOptions, and does not inherit the other options used with it.
+//
+// Verification succeeds if any configuration verifies the message. If all fail, the first
+// error other than an UnknownKeyError is returned, or an UnknownKeyError if there is none.
+func NewChainVerifier(verifiers ...VerifyOption) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) {
+			for _, o := range verifiers {
+				v.chain = append(v.chain, newVerifier([]VerifyOption{o}))
+			}
+		},
+	}
+}
+
+// verifyChain verifies msg with v, then with each verifier of its chain, until one succeeds.
+func (v *verifier) verifyChain(ctx context.Context, msg *Message) (VerifyResult, error) {
+	res, first := v.verifyMessage(ctx, msg)
+	if first == nil {
+		return res, nil
+	}
+
+	for _, cv := range v.chain {
+		if err := ctx.Err(); err != nil {
+			return VerifyResult{}, err
+		}
+
+		res, err := cv.VerifyWithContext(ctx, msg)
+		if err == nil {
+			return res, nil
+		}
+
+		if IsUnknownKeyError(first) && !IsUnknownKeyError(err) {
+			first = err
+		}
+	}
+
+	return VerifyResult{}, first
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChainVerifier(t *testing.T) {
+	secret := []byte(testSecret)
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	ecdsaClients, err := NewVerifyOptions().AddEcdsaKey("client", &pk.PublicKey).Build()
+	if err != nil {
+		t.Fatal("could not build options:", err)
+	}
+
+	chain := NewChainVerifier(ecdsaClients, WithHmacSha256("client", secret))
+
+	signed := func(opts ...SigningOption) *Message {
+		req := httptest.NewRequest("GET", "https://example.com/foo", nil)
+		if err := SignRequest(req, opts...); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+		return NewRequestMessage(req)
+	}
+
+	res, err := VerifyMessage(signed(WithHmacSha256("client", secret)), chain)
+	if err != nil {
+		t.Fatal("verification failed:", err)
+	}
+
+	if res.KeyID != "client" || res.Alg != "hmac-sha256" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	if _, err := VerifyMessage(signed(WithSignEcdsaP256Sha256("client", pk)), chain); err != nil {
+		t.Error("ecdsa verification failed:", err)
+	}
+
+	if _, err := VerifyMessage(signed(WithHmacSha256("other", secret)), chain); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key. Got:", err)
+	}
+
+	if _, err := VerifyMessage(signed(WithHmacSha256("client", []byte("wrong"))), chain); !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build chi
+
+// Package chi verifies http message signatures in services routed with
+// github.com/go-chi/chi, adding the `@chi-route-pattern` derived component. The component is
+// the pattern of the route matching a request, eg `/users/{id}`, so signatures can cover the
+// route as well as a path holding user controlled values.
+//
+// The package is only available when building with the chi tag, eg `go build -tags chi`, and
+// github.com/go-chi/chi/v5 in your go.mod.
+package chi
+
+import (
+	"errors"
+
+	"github.com/ghoti143/httpsig"
+	"github.com/go-chi/chi/v5"
+)
+
+// RoutePatternComponent is the derived component holding the route pattern of a request.
+const RoutePatternComponent = "@chi-route-pattern"
+
+var errNoRoute = errors.New("no chi route matches the request")
+
+// NewVerifyMiddleware returns a middleware that verifies requests as with
+// httpsig.NewVerifyMiddleware, with the pattern of the chi route matching each request as the
+// `@chi-route-pattern` component. Use it with the router's Use method. To reject signatures
+// that don't cover the route, add httpsig.WithRequiredComponents(RoutePatternComponent).
+func NewVerifyMiddleware(opts ...httpsig.VerifyOption) httpsig.Middleware {
+	opts = append(append([]httpsig.VerifyOption(nil), opts...),
+		httpsig.WithCustomDerivedComponent(RoutePatternComponent, routePattern))
+
+	return httpsig.NewVerifyMiddleware(opts...)
+}
+
+// WithRoutePattern signs pattern as the `@chi-route-pattern` component, for clients of a chi
+// service. Include the component with httpsig.WithSigningComponents.
+func WithRoutePattern(pattern string) httpsig.SigningOption {
+	return httpsig.WithCustomDerivedComponent(RoutePatternComponent, func(*httpsig.Message) (string, error) {
+		return pattern, nil
+	})
+}
+
+// routePattern returns the pattern of the route matching msg. Middleware runs before the
+// router has matched the request, so the route is looked up from the start.
+func routePattern(msg *httpsig.Message) (string, error) {
+	if msg.Context == nil || msg.URL == nil {
+		return "", errNoRoute
+	}
+
+	rctx := chi.RouteContext(msg.Context)
+	if rctx == nil || rctx.Routes == nil {
+		return "", errNoRoute
+	}
+
+	path := msg.URL.RawPath
+	if path == "" {
+		path = msg.URL.Path
+	}
+
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, msg.Method, path) {
+		return "", errNoRoute
+	}
+
+	return tctx.RoutePattern(), nil
+}
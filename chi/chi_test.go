@@ -0,0 +1,64 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build chi
+
+package chi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghoti143/httpsig"
+	"github.com/go-chi/chi/v5"
+)
+
+const testSecret = "support-your-local-cat-bonnet-store"
+
+func TestVerifyMiddleware(t *testing.T) {
+	key := httpsig.WithHmacSha256("key1", []byte(testSecret))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	router := func(pattern string) http.Handler {
+		r := chi.NewRouter()
+		r.Use(NewVerifyMiddleware(key))
+		r.Get(pattern, ok)
+		r.Route("/api", func(r chi.Router) {
+			r.Get(pattern, ok)
+		})
+		return r
+	}
+
+	tcs := []struct {
+		name    string
+		router  string
+		path    string
+		pattern string
+		want    int
+	}{
+		{"same route", "/users/{id}", "/users/123", "/users/{id}", http.StatusOK},
+		{"subrouter", "/users/{id}", "/api/users/123", "/api/users/{id}", http.StatusOK},
+		{"changed route", "/users/{name}", "/users/123", "/users/{id}", http.StatusUnauthorized},
+		{"no route", "/accounts/{id}", "/users/123", "/users/{id}", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			err := httpsig.SignRequest(req, key, WithRoutePattern(tc.pattern),
+				httpsig.WithSigningComponents("@method", "@path", RoutePatternComponent))
+			if err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			rec := httptest.NewRecorder()
+			router(tc.router).ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("unexpected status %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
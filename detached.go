@@ -0,0 +1,85 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errDetachedKeys = errors.New("detached signatures need exactly one key")
+
+// SignDetached signs base as is, for signature bases built outside of an http message, eg by
+// a webhook sender. Exactly one key must be configured. The returned parameters hold the key
+// id, algorithm and any created, expires and nonce values; their components are as set with
+// WithSigningComponents. base is not canonicalized, so include the `@signature-params` line
+// in it if the parameters are to be covered by the signature.
+func SignDetached(base string, opts ...SigningOption) ([]byte, *SignatureParams, error) {
+	s := &signer{
+		keys:    map[string]sigHolder{},
+		nowFunc: time.Now,
+	}
+
+	for _, o := range opts {
+		o.configureSign(s)
+	}
+
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+
+	if len(s.keys) != 1 {
+		return nil, nil, errDetachedKeys
+	}
+
+	var keyID string
+	for k := range s.keys {
+		keyID = k
+	}
+
+	sig, err := s.signBase(keyID, []byte(base))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sig, s.params(keyID, s.headers), nil
+}
+
+// VerifyDetached verifies sig is the signature of base, as made by SignDetached, using the key
+// for the key id of params. The algorithm, times and nonce of params are checked as with
+// VerifyRequest, but base is not canonicalized.
+func VerifyDetached(base string, sig []byte, params *SignatureParams, opts ...VerifyOption) error {
+	if params == nil || len(sig) == 0 {
+		return errMalformedSignature
+	}
+
+	v := newVerifier(opts)
+	ctx := context.Background()
+
+	ver, ok := v.lookupKey(params.KeyID)
+	if !ok && v.resolver != nil {
+		key, err := v.resolver.ResolveKey(ctx, params.KeyID)
+		if err != nil {
+			return &UnknownKeyError{KeyID: params.KeyID, Err: err}
+		}
+		ver, ok = key.vh, !key.IsZero()
+	}
+
+	if !ok {
+		return &UnknownKeyError{KeyID: params.KeyID}
+	}
+
+	if err := v.checkKeyExpiry(params.KeyID); err != nil {
+		return err
+	}
+
+	if err := v.checkParams(params, ver); err != nil {
+		return err
+	}
+
+	_, err := v.verifyBase(ctx, params, ver, []byte(base), sig)
+	return err
+}
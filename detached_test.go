@@ -0,0 +1,61 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestSignDetached(t *testing.T) {
+	secret := []byte(testSecret)
+	base := "\"@method\": POST\n\"@target-uri\": https://example.com/hook\n"
+
+	sig, params, err := SignDetached(base, WithHmacSha256("key1", secret), WithCreated())
+	if err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if params.KeyID != "key1" || params.Created == nil {
+		t.Errorf("unexpected params: %+v", params)
+	}
+
+	if err := VerifyDetached(base, sig, params, WithHmacSha256("key1", secret)); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if err := VerifyDetached(base+"x", sig, params, WithHmacSha256("key1", secret)); !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature for altered base. Got:", err)
+	}
+
+	if err := VerifyDetached(base, sig, params, WithHmacSha256("key2", secret)); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key. Got:", err)
+	}
+
+	if err := VerifyDetached(base, sig, params, WithHmacSha256("key1", secret), WithMaxSignatureAge(time.Nanosecond)); !IsSignatureTooOldError(err) {
+		t.Error("expected signature too old. Got:", err)
+	}
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	sig, params, err = SignDetached(base, WithSignEcdsaP256Sha256("key2", pk))
+	if err != nil {
+		t.Fatal("ecdsa signing failed:", err)
+	}
+
+	if err := VerifyDetached(base, sig, params, WithVerifyEcdsaP256Sha256("key2", &pk.PublicKey)); err != nil {
+		t.Error("ecdsa verification failed:", err)
+	}
+
+	if _, _, err := SignDetached(base, WithHmacSha256("key1", secret), WithHmacSha256("key2", secret)); err != errDetachedKeys {
+		t.Error("expected too many keys to fail. Got:", err)
+	}
+}
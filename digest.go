@@ -22,9 +22,27 @@ func calcDigest(in []byte) string {
 	return fmt.Sprintf("id-sha256=%s", base64.StdEncoding.EncodeToString(dig[:]))
 }
 
+// calcContentDigest calculates the value of a Content-Digest header for in, according to
+// https://datatracker.ietf.org/doc/draft-ietf-httpbis-digest-headers/ version 07 and later.
+func calcContentDigest(in []byte) string {
+	dig := sha256.Sum256(in)
+
+	return fmt.Sprintf("sha-256=:%s:", base64.StdEncoding.EncodeToString(dig[:]))
+}
+
 func verifyDigest(in []byte, dig string) bool {
 	// TODO: case insensitity for incoming digest?
 	calc := calcDigest(in)
 
 	return subtle.ConstantTimeCompare([]byte(dig), []byte(calc)) == 1
 }
+
+func verifyContentDigest(in []byte, dig string) error {
+	calc := calcContentDigest(in)
+
+	if dig == "" || subtle.ConstantTimeCompare([]byte(dig), []byte(calc)) != 1 {
+		return errBodyDigestMismatch
+	}
+
+	return nil
+}
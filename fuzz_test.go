@@ -0,0 +1,72 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"testing"
+)
+
+var fuzzSignatureInputs = []string{
+	`("@method" "@path" "@query" "@authority");created=1618884475;keyid="test-key"`,
+	`("@query-param";name="pet" "date");created=1618884475;keyid="test-key";alg="hmac-sha256";expires=1618884535;nonce="abc"`,
+	`();created=1618884475;keyid="test-key-rsa-pss";alg="rsa-pss-sha512"`,
+	`("@request-response";key="sig1" "@status")`,
+	`("date"`,
+	`("date";)`,
+	`("date")junk`,
+	`("date");keyid=`,
+	`("date");created=999999999999999999`,
+	`("a\"b")`,
+	``,
+}
+
+func FuzzParseSignatureInput(f *testing.F) {
+	for _, in := range fuzzSignatureInputs {
+		f.Add(in)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		sp, err := ParseSignatureInput(in)
+		if (sp == nil) == (err == nil) {
+			t.Fatalf("expected exactly one of params or error. Got: %v, %v", sp, err)
+		}
+
+		if err != nil {
+			return
+		}
+
+		// Anything parsed must survive a round trip.
+		if _, err := ParseSignatureInput(sp.String()); sp.String() != "" && err != nil {
+			t.Errorf("could not reparse %q as %q: %s", in, sp.String(), err)
+		}
+	})
+}
+
+func FuzzVerify(f *testing.F) {
+	secret := []byte(testSecret)
+
+	req := testReq()
+	signMessage(f, testSigner("test-key", signHmacSha256(secret)), req)
+	f.Add(req.Header.Get("Signature-Input"), req.Header.Get("Signature"))
+
+	for _, in := range fuzzSignatureInputs {
+		f.Add("sig1="+in, "sig1=:c2ln:")
+	}
+	f.Add("sig1=junk, sig2=(", "sig1=:c2ln:,sig2")
+
+	f.Fuzz(func(t *testing.T, input, sig string) {
+		msg := testReq()
+		msg.Header.Set("Signature-Input", input)
+		msg.Header.Set("Signature", sig)
+
+		for _, lenient := range []bool{false, true} {
+			v := testVerifier("test-key", verifyHmacSha256(secret))
+			v.lenient = lenient
+
+			// Only panics fail; any input may be rejected.
+			_, _ = v.Verify(msg)
+		}
+	})
+}
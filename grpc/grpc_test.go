@@ -0,0 +1,95 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build grpc
+
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ghoti143/httpsig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testSecret = "support-your-local-cat-bonnet-store"
+
+func TestInterceptors(t *testing.T) {
+	secret := []byte(testSecret)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(NewUnaryServerInterceptor(httpsig.WithHmacSha256("key1", secret))))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	dial := func(opts ...grpc.DialOption) healthpb.HealthClient {
+		t.Helper()
+
+		opts = append(opts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+
+		conn, err := grpc.DialContext(context.Background(), "bufnet", opts...)
+		if err != nil {
+			t.Fatal("could not dial:", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		return healthpb.NewHealthClient(conn)
+	}
+
+	tcs := []struct {
+		name   string
+		client healthpb.HealthClient
+		want   codes.Code
+	}{
+		{"signed", dial(grpc.WithUnaryInterceptor(NewUnaryClientInterceptor(httpsig.WithHmacSha256("key1", secret)))), codes.OK},
+		{"unsigned", dial(), codes.Unauthenticated},
+		{"wrong key", dial(grpc.WithUnaryInterceptor(NewUnaryClientInterceptor(httpsig.WithHmacSha256("key1", []byte("wrong"))))), codes.Unauthenticated},
+		{"signed metadata", dial(grpc.WithUnaryInterceptor(NewUnaryClientInterceptor(
+			httpsig.WithHmacSha256("key1", secret),
+			httpsig.WithSigningComponents("@method", "@path", "x-tenant"),
+		))), codes.OK},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme")
+
+			_, err := tc.client.Check(ctx, &healthpb.HealthCheckRequest{})
+			if got := status.Code(err); got != tc.want {
+				t.Errorf("unexpected code %s, want %s: %v", got, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	md := metadata.Pairs(":authority", "example.com", "x-tenant", "acme", "x-tenant", "other")
+
+	msg := message("/pkg.Service/Method", md)
+	if msg.Method != "/pkg.Service/Method" || msg.URL.Path != "/pkg.Service/Method" {
+		t.Errorf("unexpected method or path: %s, %s", msg.Method, msg.URL.Path)
+	}
+
+	if got := msg.Header.Values("X-Tenant"); len(got) != 2 || got[0] != "acme" || got[1] != "other" {
+		t.Error("unexpected metadata values:", got)
+	}
+
+	if len(msg.Header) != 1 {
+		t.Error("expected pseudo-headers to be left out. Got:", msg.Header)
+	}
+}
@@ -6,15 +6,28 @@ package httpsig
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ghoti143/httpsig/internal/hooks"
 )
 
+var errBodyNotReplayable = errors.New("request body cannot be replayed")
+
 var defaultHeaders = []string{"content-type", "content-length"} // also method, path, query, and digest
 
+// defaultSigningComponents are signed when WithSigningComponents is given no components. They
+// are present on every request.
+var defaultSigningComponents = []string{"@method", "@authority", "@path", "@query"}
+
 func sliceHas(haystack []string, needle string) bool {
 	for _, n := range haystack {
 		if n == needle {
@@ -32,16 +45,240 @@ func sliceHas(haystack []string, needle string) bool {
 // key ids. You must provide at least one signing option. A signature for every provided key id is
 // included on each request. Multiple included signatures allow you to gracefully introduce stronger
 // algorithms, rotate keys, etc.
-func NewSignTransport(transport http.RoundTripper, opts ...signOption) http.RoundTripper {
-	s := signer{
+//
+// Options set on a request's context with WithContextSigningOptions apply to that request.
+func NewSignTransport(transport http.RoundTripper, opts ...SigningOption) http.RoundTripper {
+	ts := newRequestSigner(opts)
+
+	return rt(func(r *http.Request) (*http.Response, error) {
+		s := contextSigner(r.Context(), ts, opts)
+		nr := r.Clone(r.Context())
+
+		if err := s.signRequest(nr); err != nil {
+			return nil, err
+		}
+
+		resp, err := transport.RoundTrip(nr)
+		if err != nil || !s.acceptSignature {
+			return resp, err
+		}
+
+		as := acceptSignatureHeader(resp)
+		if as == "" {
+			return resp, nil
+		}
+
+		// Without a signer for what was asked, the 401 stands.
+		ns, err := s.accepting(as)
+		if err != nil {
+			return resp, nil
+		}
+
+		return resend(transport, ns, r, nr, resp)
+	})
+}
+
+// NewAutoRetrySignTransport is NewSignTransport, but sends a request again, once, when it is
+// rejected with a `401` response, eg because its signature expired in transit or the server
+// asks for another signature with `Accept-Signature`. The retry is signed afresh, with a new
+// creation time and nonce, and as asked by any `Accept-Signature` header.
+//
+// Only GET, HEAD, DELETE and PUT requests are retried, unless WithRetryOnPost is used. The
+// `401` response is returned if the request body cannot be sent again.
+func NewAutoRetrySignTransport(inner http.RoundTripper, opts ...SigningOption) http.RoundTripper {
+	ts := newRequestSigner(opts)
+
+	return rt(func(r *http.Request) (*http.Response, error) {
+		s := contextSigner(r.Context(), ts, opts)
+		nr := r.Clone(r.Context())
+
+		if err := s.signRequest(nr); err != nil {
+			return nil, err
+		}
+
+		resp, err := inner.RoundTrip(nr)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || !s.retries(r.Method) {
+			return resp, err
+		}
+
+		rs := s
+		if as := acceptSignatureHeader(resp); as != "" {
+			if ns, err := s.accepting(as); err == nil {
+				rs = ns
+			}
+		}
+
+		return resend(inner, rs, r, nr, resp)
+	})
+}
+
+// retries reports whether requests with method are retried by NewAutoRetrySignTransport.
+func (s *signer) retries(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodPut:
+		return true
+	case http.MethodPost:
+		return s.retryPost
+	default:
+		return false
+	}
+}
+
+// resend signs a copy of r with s and sends it in place of sent, which got resp. If the body
+// of sent cannot be replayed, resp is returned instead.
+func resend(transport http.RoundTripper, s *signer, r, sent *http.Request, resp *http.Response) (*http.Response, error) {
+	retry, err := retryRequest(r, sent)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if err := s.signRequest(retry); err != nil {
+		return nil, err
+	}
+
+	return transport.RoundTrip(retry)
+}
+
+// retryRequest returns a copy of r to send again, with the body sent in sent. It fails if the
+// body cannot be replayed.
+func retryRequest(r, sent *http.Request) (*http.Request, error) {
+	nr := r.Clone(r.Context())
+	if sent.Body == nil || sent.Body == http.NoBody {
+		nr.Body = sent.Body
+		return nr, nil
+	}
+
+	if sent.GetBody == nil {
+		return nil, errBodyNotReplayable
+	}
+
+	body, err := sent.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	nr.Body = body
+	nr.GetBody = sent.GetBody
+
+	return nr, nil
+}
+
+// SignRequest signs req in place, setting its signature and body digest headers, as
+// NewSignTransport does for each request it sends. Use this to sign requests sent without
+// replacing the client's transport.
+func SignRequest(req *http.Request, opts ...SigningOption) error {
+	return newRequestSigner(opts).signRequest(req)
+}
+
+// SignMessage returns the signature headers for msg, for messages that aren't an *http.Request
+// or *http.Response. Unlike SignRequest, no body digest is set; sign a digest header set by
+// the caller to cover the body.
+func SignMessage(msg *Message, opts ...SigningOption) (http.Header, error) {
+	s := newRequestSigner
+	if msg.StatusCode != 0 {
+		s = newResponseSigner
+	}
+
+	return s(opts).Sign(msg)
+}
+
+// VerifyMessage verifies the signature of msg, for messages that aren't an *http.Request or
+// *http.Response. Unlike VerifyRequest, body digests are not checked.
+func VerifyMessage(msg *Message, opts ...VerifyOption) (VerifyResult, error) {
+	return newVerifier(opts).Verify(msg)
+}
+
+// VerifyRequest verifies the signature and body digests of req, as NewVerifyMiddleware does for
+// each request it handles. The body of req is read, and replaced with an unread copy.
+func VerifyRequest(req *http.Request, opts ...VerifyOption) error {
+	_, err := newVerifier(opts).verifyRequest(req)
+	return err
+}
+
+// newRequestSigner returns a signer configured with opts, and the defaults for requests.
+func newRequestSigner(opts []SigningOption) *signer {
+	s := &signer{
 		keys:    map[string]sigHolder{},
 		nowFunc: time.Now,
 	}
 
 	for _, o := range opts {
-		o.configureSign(&s)
+		o.configureSign(s)
 	}
 
+	setRequestHeaders(s)
+	for _, a := range s.additional {
+		inheritNow(s, a.s)
+		setRequestHeaders(a.s)
+	}
+
+	return s
+}
+
+// signRequest sets the body digest and signature headers on r, leaving r with an unread body.
+func (s *signer) signRequest(r *http.Request) error {
+	_, sp := startSpan(r.Context(), s.tracers, "httpsig.sign")
+	defer sp.end()
+
+	if len(s.tracers) > 0 {
+		sp.keys(s.keyAttributes())
+	}
+
+	if err := s.setRequestSignature(r); err != nil {
+		sp.fail(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *signer) setRequestSignature(r *http.Request) error {
+	read := readBody
+	if s.bufferBody {
+		read = readFullBody
+	}
+
+	b, err := read(r)
+	if err != nil {
+		return err
+	}
+
+	// Always set a digest (for now)
+	// TODO: we could skip setting digest on an empty body if content-length is included in the sig
+	r.Header.Set("Digest", calcDigest(b))
+
+	contentDigest := s.contentDigest
+	for _, a := range s.additional {
+		contentDigest = contentDigest || a.s.contentDigest
+	}
+
+	if contentDigest {
+		r.Header.Set("Content-Digest", calcContentDigest(b))
+	}
+
+	hdr, err := s.Sign(NewRequestMessage(r))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range hdr {
+		r.Header[k] = v
+	}
+
+	return nil
+}
+
+// inheritNow sets the clock of the additional signer as to that of s, unless it has its own.
+// as must belong to s alone, as built for it by WithAdditionalSignature, so the clock of one
+// signer never leaks to another using the same option.
+func inheritNow(s, as *signer) {
+	if as.nowFunc == nil {
+		as.nowFunc = s.nowFunc
+	}
+}
+
+// setRequestHeaders sets the default headers signed on requests by s.
+func setRequestHeaders(s *signer) {
 	if len(s.headers) == 0 {
 		s.headers = defaultHeaders[:]
 	}
@@ -50,187 +287,1213 @@ func NewSignTransport(transport http.RoundTripper, opts ...signOption) http.Roun
 
 	// specialty components and digest first, for aesthetics
 	for _, comp := range []string{"digest", "@query", "@path", "@method"} {
-		if !sliceHas(s.headers, comp) {
+		if !s.exact && !sliceHas(s.headers, comp) {
 			s.headers = append([]string{comp}, s.headers...)
 		}
 	}
 
-	return rt(func(r *http.Request) (*http.Response, error) {
-		nr := r.Clone(r.Context())
+	if s.contentDigest && !sliceHas(s.headers, "content-digest") {
+		s.headers = append(s.headers, "content-digest")
+	}
+}
 
-		b := &bytes.Buffer{}
-		if r.Body != nil {		
-			
-			// this broke the request 
-			// was getting net/http: HTTP/1.x transport connection broken: http: ContentLength=44 with Body length 0
-			// switched to read bytes from a copy of body.
-			bodyCopy, _ := r.GetBody()
-			defer bodyCopy.Close()
+// readBody returns the body of r, leaving r with an unread body.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
 
-			_, err := b.ReadFrom(bodyCopy)
-			if err != nil {
-				return nil, err
-			}						
+	// Reading the body directly broke the request; prefer a copy of the body if we can get it.
+	// (net/http: HTTP/1.x transport connection broken: http: ContentLength=44 with Body length 0)
+	if r.GetBody != nil {
+		bodyCopy, err := r.GetBody()
+		if err != nil {
+			return nil, err
 		}
+		defer bodyCopy.Close()
+
+		return ioutil.ReadAll(bodyCopy)
+	}
 
-		// Always set a digest (for now)
-		// TODO: we could skip setting digest on an empty body if content-length is included in the sig
-		nr.Header.Set("Digest", calcDigest(b.Bytes()))
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(b)), nil }
+
+	return b, nil
+}
 
-		msg := messageFromRequest(nr)
-		hdr, err := s.Sign(msg)
+// readFullBody is readBody, but recovers any of the body already read, by getting a new copy
+// with GetBody, or by seeking back to the start. The body of r is replaced with the full body,
+// so what is sent matches the digest.
+func readFullBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body := r.Body
+	if r.GetBody != nil {
+		bodyCopy, err := r.GetBody()
 		if err != nil {
 			return nil, err
 		}
+		defer bodyCopy.Close()
 
-		for k, v := range hdr {
-			nr.Header[k] = v
+		body = bodyCopy
+	} else if sk, ok := r.Body.(io.Seeker); ok {
+		if _, err := sk.Seek(0, io.SeekStart); err != nil {
+			return nil, err
 		}
+	}
 
-		return transport.RoundTrip(nr)
-	})
-}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
 
-type rt func(*http.Request) (*http.Response, error)
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(b)), nil }
 
-func (r rt) RoundTrip(req *http.Request) (*http.Response, error) { return r(req) }
+	return b, nil
+}
 
-// NewVerifyMiddleware returns a configured http server middleware that can be used to wrap
-// multiple handlers for http message signature and digest verification.
+// NewSignResponseTransport returns a new client transport that wraps the provided transport,
+// signing the responses it returns. This is useful for gateways that relay responses from
+// services to their own clients.
 //
-// Use the `WithVerify*` option funcs to configure signature verification algorithms that map
-// to their provided key ids.
+// Signing is configured as with NewSignTransport. The `@status` component is always signed,
+// but body digests are not calculated for responses.
+func NewSignResponseTransport(transport http.RoundTripper, opts ...SigningOption) http.RoundTripper {
+	s := newResponseSigner(opts)
+
+	return rt(func(r *http.Request) (*http.Response, error) {
+		resp, err := transport.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.signResponse(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		return resp, nil
+	})
+}
+
+// SignResponse signs resp in place, setting its signature headers, as NewSignResponseTransport
+// does for each response it returns. The default components are `@status` and the
+// content-type and content-length headers.
 //
-// Requests with missing signatures, malformed signature headers, expired signatures, or
-// invalid signatures are rejected with a `400` response. Only one valid signature is required
-// from the known key ids. However, only the first known key id is checked.
-func NewVerifyMiddleware(opts ...verifyOption) func(http.Handler) http.Handler {
+// Headers written to an http.ResponseWriter can't be read back once the response is written,
+// so sign a response built by hand, or recorded with httptest.ResponseRecorder, before sending
+// it on.
+func SignResponse(resp *http.Response, opts ...SigningOption) error {
+	return newResponseSigner(opts).signResponse(resp)
+}
 
-	// TODO: form and multipart support
-	v := verifier{
-		keys:    make(map[string]verHolder),
+// VerifyResponse verifies the signature of resp, as NewVerifyResponseMiddleware does for each
+// response it returns.
+func VerifyResponse(resp *http.Response, opts ...VerifyOption) error {
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+
+	_, err := newVerifier(opts).VerifyWithContext(ctx, NewResponseMessage(resp))
+	return err
+}
+
+// newResponseSigner returns a signer configured with opts, and the defaults for responses.
+func newResponseSigner(opts []SigningOption) *signer {
+	s := &signer{
+		keys:    map[string]sigHolder{},
 		nowFunc: time.Now,
 	}
 
 	for _, o := range opts {
-		o.configureVerify(&v)
+		o.configureSign(s)
 	}
 
-	serveErr := func(rw http.ResponseWriter) {
-		// TODO: better error and custom error handler
-		rw.Header().Set("Content-Type", "text/plain")
-		rw.WriteHeader(http.StatusBadRequest)
+	setResponseHeaders(s)
+	for _, a := range s.additional {
+		inheritNow(s, a.s)
+		setResponseHeaders(a.s)
+	}
+
+	return s
+}
+
+// signResponse sets the signature headers on resp.
+func (s *signer) signResponse(resp *http.Response) error {
+	hdr, err := s.Sign(NewResponseMessage(resp))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range hdr {
+		resp.Header[k] = v
+	}
+
+	return nil
+}
+
+// NewBidirectionalSignTransport returns a new client transport that wraps the provided
+// transport, signing both the requests it sends, as with NewSignTransport, and the responses
+// it returns, as with NewSignResponseTransport. This lets gateways authenticate themselves to
+// both the services they call and their own clients.
+//
+// Responses are signed with `@status` and the configured header components. Derived request
+// components, like `@method`, are only signed on requests.
+func NewBidirectionalSignTransport(transport http.RoundTripper, opts ...SigningOption) http.RoundTripper {
+	respOpts := append(opts[:len(opts):len(opts)], &optImpl{s: responseComponentsOnly})
+
+	return NewSignResponseTransport(NewSignTransport(transport, opts...), respOpts...)
+}
+
+// responseComponentsOnly removes the derived components that don't apply to responses from
+// the components signed by s. Additional signers are copied first, so signers for requests
+// configured with the same options are left as they are.
+func responseComponentsOnly(s *signer) {
+	if len(s.headers) != 0 {
+		hdrs := []string{"@status"}
+		for _, h := range s.headers {
+			if !strings.HasPrefix(h, "@") {
+				hdrs = append(hdrs, h)
+			}
+		}
+		s.headers = hdrs
+	}
+
+	additional := make([]additionalSignature, len(s.additional))
+	for i, a := range s.additional {
+		as := *a.s
+		responseComponentsOnly(&as)
+		additional[i] = additionalSignature{label: a.label, s: &as}
+	}
+	s.additional = additional
+}
+
+// setResponseHeaders sets the default headers signed on responses by s.
+func setResponseHeaders(s *signer) {
+	if len(s.headers) == 0 {
+		s.headers = defaultHeaders[:]
+	}
 
-		_, _ = rw.Write([]byte("invalid required signature"))
+	if !s.exact && !sliceHas(s.headers, "@status") {
+		s.headers = append([]string{"@status"}, s.headers...)
+	}
+}
+
+// NewSignHandler returns a configured http server middleware that signs the responses of the
+// handlers it wraps.
+//
+// Signing is configured as with NewSignResponseTransport. Responses are buffered until the
+// handler returns, so their signature headers can be set before they are written. Missing
+// content-type and content-length headers are set as net/http would, and with WithBodyDigest
+// the `Content-Digest` header is set and signed. Responses that can't be signed are replaced
+// with a `500` response.
+func NewSignHandler(opts ...SigningOption) Middleware {
+	s := newResponseSigner(opts)
+	if s.contentDigest && !sliceHas(s.headers, "content-digest") {
+		s.headers = append(s.headers, "content-digest")
 	}
 
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			bw := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+			h.ServeHTTP(bw, r)
 
-			msg := messageFromRequest(r)
-			err := v.Verify(msg)
-			if err != nil {
-				serveErr(rw)
-				return
+			b := bw.body.Bytes()
+			if bw.header.Get("Content-Type") == "" && len(b) != 0 {
+				bw.header.Set("Content-Type", http.DetectContentType(b))
 			}
 
-			b := &bytes.Buffer{}
-			if r.Body != nil {
-				n, err := b.ReadFrom(r.Body)
-				if err != nil {
-					serveErr(rw)
-					return
-				}
+			if bw.header.Get("Content-Length") == "" {
+				bw.header.Set("Content-Length", strconv.Itoa(len(b)))
+			}
 
-				defer r.Body.Close()
+			if s.contentDigest {
+				bw.header.Set("Content-Digest", calcContentDigest(b))
+			}
 
-				if n != 0 {
-					r.Body = ioutil.NopCloser(bytes.NewReader(b.Bytes()))
-				}
+			resp := &http.Response{StatusCode: bw.status, Header: bw.header, Request: r}
+			if err := s.signResponse(resp); err != nil {
+				http.Error(rw, "could not sign response", http.StatusInternalServerError)
+				return
 			}
 
-			// Check the digest if set. We only support id-sha-256 for now.
-			// TODO: option to require this?
-			if dig := r.Header.Get("Digest"); dig != "" {
-				if !verifyDigest(b.Bytes(), dig) {
-					serveErr(rw)
-				}
+			for k, v := range bw.header {
+				rw.Header()[k] = v
 			}
+			rw.WriteHeader(bw.status)
 
-			h.ServeHTTP(rw, r)
+			_, _ = rw.Write(b)
 		})
 	}
 }
 
-type signOption interface {
-	configureSign(s *signer)
+// bufferedResponseWriter holds a response written by a handler, for NewSignHandler.
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
 }
 
-type verifyOption interface {
-	configureVerify(v *verifier)
-}
+func (w *bufferedResponseWriter) Header() http.Header { return w.header }
 
-type signOrVerifyOption interface {
-	signOption
-	verifyOption
-}
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
 
-type optImpl struct {
-	s func(s *signer)
-	v func(v *verifier)
+	w.status, w.wroteHeader = status, true
 }
 
-func (o *optImpl) configureSign(s *signer)     { o.s(s) }
-func (o *optImpl) configureVerify(v *verifier) { o.v(v) }
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
 
-// WithHeaders sets the list of headers that will be included in the signature.
-// The Digest header is always included (and the digest calculated).
+// NewVerifyResponseMiddleware returns a configured client transport middleware that can be
+// used to wrap transports for http message signature verification of the responses they
+// return.
 //
-// If not provided, the default headers `content-type, content-length, host` are used.
-func WithHeaders(hdr ...string) signOption {
-	// TODO: use this to implement required headers in verify?
-	return &optImpl{
-		s: func(s *signer) { s.headers = hdr },
+// Verification is configured as with NewVerifyMiddleware. Responses that fail verification are
+// closed, and their error is returned from the transport instead.
+func NewVerifyResponseMiddleware(opts ...VerifyOption) func(http.RoundTripper) http.RoundTripper {
+	v := newVerifier(opts)
+
+	return func(transport http.RoundTripper) http.RoundTripper {
+		return rt(func(r *http.Request) (*http.Response, error) {
+			resp, err := transport.RoundTrip(r)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := v.VerifyWithContext(r.Context(), NewResponseMessage(resp)); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+
+			return resp, nil
+		})
 	}
 }
 
-// WithSignRsaPssSha512 adds signing using `rsa-pss-sha512` with the given private key
-// using the given key id.
-func WithSignRsaPssSha512(keyID string, pk *rsa.PrivateKey) signOption {
-	return &optImpl{
-		s: func(s *signer) { s.keys[keyID] = signRsaPssSha512(pk) },
-	}
+// NewVerifyResponseTransport returns a new client transport that wraps the provided transport,
+// verifying the signatures of the responses it returns. It is NewVerifyResponseMiddleware
+// applied to transport.
+func NewVerifyResponseTransport(transport http.RoundTripper, opts ...VerifyOption) http.RoundTripper {
+	return NewVerifyResponseMiddleware(opts...)(transport)
 }
 
-// WithVerifyRsaPssSha512 adds signature verification using `rsa-pss-sha512` with the
-// given public key using the given key id.
-func WithVerifyRsaPssSha512(keyID string, pk *rsa.PublicKey) verifyOption {
-	return &optImpl{
-		v: func(v *verifier) { v.keys[keyID] = verifyRsaPssSha512(pk) },
-	}
+type rt func(*http.Request) (*http.Response, error)
+
+func (r rt) RoundTrip(req *http.Request) (*http.Response, error) { return r(req) }
+
+// Middleware wraps an http.Handler, as returned by NewVerifyMiddleware. It can be called
+// directly, or composed with libraries that expect a `func(http.Handler) http.Handler`.
+type Middleware func(http.Handler) http.Handler
+
+// Then returns next wrapped by the middleware.
+func (m Middleware) Then(next http.Handler) http.Handler { return m(next) }
+
+// ServeHTTP serves the middleware as a standalone handler, with http.NotFoundHandler after it.
+// Requests that pass the middleware get a `404` response.
+func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Then(http.NotFoundHandler()).ServeHTTP(w, r)
 }
 
-// WithSignEcdsaP256Sha256 adds signing using `ecdsa-p256-sha256` with the given private key
-// using the given key id.
-func WithSignEcdsaP256Sha256(keyID string, pk *ecdsa.PrivateKey) signOption {
-	return &optImpl{
-		s: func(s *signer) { s.keys[keyID] = signEccP256(pk) },
-	}
+// Handler returns next, wrapped to verify requests as with NewVerifyMiddleware. Use it to
+// protect single routes, eg with http.ServeMux.Handle.
+func Handler(next http.Handler, opts ...VerifyOption) http.Handler {
+	return NewVerifyMiddleware(opts...)(next)
 }
 
-// WithVerifyEcdsaP256Sha256 adds signature verification using `ecdsa-p256-sha256` with the
-// given public key using the given key id.
-func WithVerifyEcdsaP256Sha256(keyID string, pk *ecdsa.PublicKey) verifyOption {
-	return &optImpl{
-		v: func(v *verifier) { v.keys[keyID] = verifyEccP256(pk) },
+// WrapHandler returns h, wrapped to verify requests as with NewVerifyMiddleware before calling
+// h, and to sign its responses as with NewSignHandler. Rejected requests are signed too. Pass
+// nil for either options to skip that direction.
+func WrapHandler(h http.Handler, signOpts []SigningOption, verifyOpts []VerifyOption) http.Handler {
+	if verifyOpts != nil {
+		h = NewVerifyMiddleware(verifyOpts...)(h)
 	}
+
+	if signOpts != nil {
+		h = NewSignHandler(signOpts...)(h)
+	}
+
+	return h
 }
 
-// WithHmacSha256 adds signing or signature verification using `hmac-sha256` with the
-// given shared secret using the given key id.
-func WithHmacSha256(keyID string, secret []byte) signOrVerifyOption {
-	return &optImpl{
-		s: func(s *signer) { s.keys[keyID] = signHmacSha256(secret) },
-		v: func(v *verifier) { v.keys[keyID] = verifyHmacSha256(secret) },
+// NewVerifyMiddleware returns a configured http server middleware that can be used to wrap
+// multiple handlers for http message signature and digest verification.
+//
+// Use the `WithVerify*` option funcs to configure signature verification algorithms that map
+// to their provided key ids.
+//
+// Requests with missing signatures, malformed signature headers, expired signatures, or
+// invalid signatures are rejected with a `401` response, or as set with WithErrorHandler. Only
+// one valid signature is required from the known key ids. However, only the first known key id
+// is checked.
+func NewVerifyMiddleware(opts ...VerifyOption) Middleware {
+	// TODO: form and multipart support
+	v := newVerifier(opts)
+
+	serveErr := v.errorHandler
+	if serveErr == nil {
+		serveErr = defaultErrorHandler
+	}
+
+	// A malformed component rejects every request, as nothing could be signed to match.
+	accept, acceptErr := v.acceptSignature()
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if acceptErr != nil {
+				serveErr(rw, r, acceptErr)
+				return
+			}
+
+			// Each request is verified at a single point in time.
+			rv := v.Clone()
+			now := rv.now()
+			rv.nowFunc = func() time.Time { return now }
+
+			res, err := rv.verifyRequest(r)
+			if rv.resultHook != nil {
+				rv.resultHook(res, err)
+			}
+
+			if err != nil && v.passthrough && IsNotSignedError(err) {
+				h.ServeHTTP(rw, r)
+				return
+			}
+
+			rv.logResult(r, res, err)
+			if err != nil && rv.observing(now) {
+				h.ServeHTTP(rw, r)
+				return
+			}
+
+			if err != nil {
+				if accept != "" && (IsNotSignedError(err) || IsMissingComponentError(err)) {
+					rw.Header().Set("Accept-Signature", accept)
+				}
+				serveErr(rw, r, err)
+				return
+			}
+
+			h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), verifyResultKey{}, res)))
+		})
+	}
+}
+
+// newVerifier returns a verifier configured with opts.
+func newVerifier(opts []VerifyOption) *verifier {
+	v := &verifier{
+		keys:    make(map[string]verHolder),
+		nowFunc: time.Now,
+	}
+
+	for _, o := range opts {
+		o.configureVerify(v)
+	}
+
+	// Rotations start now, once the clock is configured.
+	for id, m := range v.keyMeta {
+		if m.registeredAt.IsZero() {
+			m.registeredAt = v.now()
+			v.keyMeta[id] = m
+		}
+	}
+
+	return v
+}
+
+// verifyRequest verifies the signature and body digests of r, leaving r with an unread body.
+func (v *verifier) verifyRequest(r *http.Request) (VerifyResult, error) {
+	ctx, sp := startSpan(r.Context(), v.tracers, "httpsig.verify")
+	defer sp.end()
+
+	res, err := v.checkRequest(ctx, r)
+	if err != nil {
+		sp.fail(err)
+		return VerifyResult{}, err
+	}
+
+	sp.keys([]string{res.KeyID}, []string{res.Alg})
+	return res, nil
+}
+
+func (v *verifier) checkRequest(ctx context.Context, r *http.Request) (VerifyResult, error) {
+	res, err := v.VerifyWithContext(ctx, NewRequestMessage(r))
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	b := &bytes.Buffer{}
+	if r.Body != nil {
+		_, err := b.ReadFrom(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return VerifyResult{}, err
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(b.Bytes()))
+	}
+
+	// Check the digest if set. We only support id-sha-256 for now.
+	// TODO: option to require this?
+	if dig := r.Header.Get("Digest"); dig != "" {
+		if !verifyDigest(b.Bytes(), dig) {
+			return VerifyResult{}, errBodyDigestMismatch
+		}
+	}
+
+	if v.contentDigest {
+		if err := verifyContentDigest(b.Bytes(), r.Header.Get("Content-Digest")); err != nil {
+			return VerifyResult{}, err
+		}
+	}
+
+	return res, nil
+}
+
+// defaultErrorHandler rejects requests with a `401` response, or a `400` response for a body
+// that doesn't match its digest.
+func defaultErrorHandler(rw http.ResponseWriter, _ *http.Request, err error) {
+	rw.Header().Set("Content-Type", "text/plain")
+
+	if IsBodyDigestMismatchError(err) {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("body digest mismatch"))
+		return
+	}
+
+	rw.WriteHeader(http.StatusUnauthorized)
+	_, _ = rw.Write([]byte("invalid required signature"))
+}
+
+type verifyResultKey struct{}
+
+// SigningKeyFromContext returns the result of verifying the request's signature, as set by
+// the NewVerifyMiddleware handler, for use in handlers (eg to log the key id of the client).
+func SigningKeyFromContext(ctx context.Context) (VerifyResult, bool) {
+	res, ok := ctx.Value(verifyResultKey{}).(VerifyResult)
+	return res, ok
+}
+
+type signingOptionsKey struct{}
+
+// WithContextSigningOptions returns a copy of ctx carrying opts, for signing a single request
+// differently, eg with the key of a tenant. NewSignTransport and NewAutoRetrySignTransport
+// apply the options of the request context after their own. If opts configure any keys, the
+// request is signed with only those keys.
+func WithContextSigningOptions(ctx context.Context, opts ...SigningOption) context.Context {
+	prev, _ := ctx.Value(signingOptionsKey{}).([]SigningOption)
+	return context.WithValue(ctx, signingOptionsKey{}, append(prev[:len(prev):len(prev)], opts...))
+}
+
+// contextSigner returns the signer for requests with ctx: s, configured with opts, unless ctx
+// carries options from WithContextSigningOptions.
+func contextSigner(ctx context.Context, s *signer, opts []SigningOption) *signer {
+	ctxOpts, ok := ctx.Value(signingOptionsKey{}).([]SigningOption)
+	if !ok {
+		return s
+	}
+
+	cs := newRequestSigner(append(opts[:len(opts):len(opts)], ctxOpts...))
+
+	keys := &signer{keys: map[string]sigHolder{}}
+	for _, o := range ctxOpts {
+		o.configureSign(keys)
+	}
+
+	if len(keys.keys) > 0 {
+		cs.keys = keys.keys
+	}
+
+	return cs
+}
+
+// SigningOption configures signing, for NewSignTransport and NewSignResponseTransport.
+type SigningOption interface {
+	configureSign(s *signer)
+}
+
+// VerifyOption configures verification, for NewVerifyMiddleware and NewVerifyResponseMiddleware.
+type VerifyOption interface {
+	configureVerify(v *verifier)
+}
+
+// SignOrVerifyOption is an option that can be used for either signing or verification.
+type SignOrVerifyOption interface {
+	SigningOption
+	VerifyOption
+}
+
+type optImpl struct {
+	s func(s *signer)
+	v func(v *verifier)
+}
+
+func (o *optImpl) configureSign(s *signer)     { o.s(s) }
+func (o *optImpl) configureVerify(v *verifier) { o.v(v) }
+
+// WithHeaders sets the list of headers that will be included in the signature.
+// The Digest header is always included (and the digest calculated).
+//
+// If not provided, the default headers `content-type, content-length, host` are used.
+func WithHeaders(hdr ...string) SigningOption {
+	// TODO: use this to implement required headers in verify?
+	return &optImpl{
+		s: func(s *signer) { s.headers = hdr },
+	}
+}
+
+// WithSigningComponents sets exactly the components included in the signature, such as
+// `@method`, `@path`, `@query-param;name="id"`, or `date`. Unlike WithHeaders, no other
+// components are added (other than `content-digest` with WithBodyDigest), and messages missing
+// a listed header fail to sign rather than having it left out.
+//
+// Without any components, `@method`, `@authority`, `@path` and `@query` are signed. Unknown
+// derived components (starting with `@`) are reported as an error when signing.
+func WithSigningComponents(components ...string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) {
+			s.headers = append([]string(nil), components...)
+			if len(components) == 0 {
+				s.headers = defaultSigningComponents
+			}
+			s.exact = true
+
+			// Derived components are checked when signing, once any custom ones are known.
+			for _, c := range components {
+				if _, err := parseComponent(c); err != nil {
+					s.err = err
+					return
+				}
+			}
+		},
+	}
+}
+
+// WithSignAllHeaders signs every header of each message, as it is when signed, instead of a
+// fixed list of components, for when the verifier's requirements aren't known in advance.
+// Hop-by-hop headers, such as `Connection` and `Transfer-Encoding`, are left out. Requests also
+// sign `@method`, `@path` and `@authority`, and responses `@status`.
+func WithSignAllHeaders() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.allHeaders = true },
+	}
+}
+
+// WithComponentOrder signs components first, in the given order, followed by any other
+// components that would be signed, in their usual order. It is for verifiers that are sensitive
+// to the order of components. Messages missing a listed header fail to sign, unless with
+// WithSkipMissingComponents.
+func WithComponentOrder(components ...string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) {
+			s.order = append([]string(nil), components...)
+
+			for _, c := range components {
+				if _, err := parseComponent(c); err != nil {
+					s.err = err
+					return
+				}
+			}
+		},
+	}
+}
+
+// WithSkipMissingComponents leaves headers missing from a message out of its signature, rather
+// than failing to sign, including those listed with WithSigningComponents or
+// WithComponentOrder.
+func WithSkipMissingComponents() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.skipMissing = true },
+	}
+}
+
+// WithClockSkew allows signatures to be accepted for up to d past their `expires` time, to
+// tolerate clocks that disagree between signer and verifier.
+func WithClockSkew(d time.Duration) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.skew = d },
+	}
+}
+
+// WithCreatedWindow rejects signatures with a `created` time more than d in the past or in
+// the future, guarding against replayed signatures. Signatures without a `created` time are
+// not checked.
+func WithCreatedWindow(d time.Duration) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.createdWindow = d },
+	}
+}
+
+// WithMaxSignatureAge rejects signatures with a `created` time more than d in the past, even
+// if they have not reached their `expires` time. Signatures without a `created` time are not
+// checked, unless WithRequireCreated is used.
+func WithMaxSignatureAge(d time.Duration) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.maxAge = d },
+	}
+}
+
+// WithRequireCreated sets whether signatures without a `created` time are rejected when
+// WithMaxSignatureAge is used. By default, they are accepted.
+func WithRequireCreated(require bool) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.requireCreated = require },
+	}
+}
+
+// WithCreated includes the `created` parameter, set to the time of signing, in signatures.
+// Verifiers can use it to reject stale signatures; see WithCreatedWindow.
+func WithCreated() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.created = true },
+	}
+}
+
+// WithExpires includes the `expires` parameter in signatures, set to d after the time of
+// signing. Verifiers reject signatures after they expire.
+func WithExpires(d time.Duration) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.expires = d },
+	}
+}
+
+// WithAuthority signs the `@authority` component using the given value rather than the
+// request's host. Use this when the host the request is sent to differs from the one the
+// verifier sees, such as when sending through a reverse proxy.
+func WithAuthority(override string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.authority = override },
+	}
+}
+
+// WithNonce includes a `nonce` parameter in signatures, calling fn to generate a new value for
+// every signed request. Verifiers can use it to detect replayed requests.
+func WithNonce(fn func() string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.nonceFunc = fn },
+	}
+}
+
+// WithNonceValidator calls fn with the `nonce` parameter (which may be empty) of every
+// signature that is otherwise valid. If fn returns an error, verification fails with it.
+// Use this to keep a store of seen nonces, rejecting duplicates.
+func WithNonceValidator(fn func(nonce string) error) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.nonceValidator = fn },
+	}
+}
+
+// WithNonceStore rejects signatures with a `nonce` parameter that store has already seen, and
+// marks the nonces of accepted signatures as seen. Signatures without a nonce are not checked;
+// require signers to use WithNonce.
+func WithNonceStore(store NonceStore) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.nonceStore = store },
+	}
+}
+
+// WithBodyDigest sets a `Content-Digest` header with the sha-256 digest of the request body,
+// and includes it in the signature. Verifiers can then detect a modified body.
+func WithBodyDigest() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.contentDigest = true },
+	}
+}
+
+// WithAcceptSignatureRespect retries requests rejected with a `401` response that has an
+// `Accept-Signature` header, as sent by WithAcceptSignature. The retry is signed with exactly
+// the components asked for, by the keys matching its keyid and alg parameters. The `401`
+// response is returned if no key matches, or the request body cannot be sent again.
+func WithAcceptSignatureRespect() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.acceptSignature = true },
+	}
+}
+
+// WithRetryOnPost lets NewAutoRetrySignTransport retry POST requests, which aren't idempotent,
+// so may take effect twice if a server acts on a request before rejecting it.
+func WithRetryOnPost() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.retryPost = true },
+	}
+}
+
+// WithBase64URLEncoding encodes signatures with base64url (RFC 4648 section 5), with padding,
+// rather than standard base64, eg `sig1=:a-_b:`, for services that expect it. Verifiers must
+// use WithBase64URL.
+func WithBase64URLEncoding() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.base64URL = true },
+	}
+}
+
+// WithHeaderSanitization passes the values of signed headers through sanitizer before they are
+// signed or verified. The sanitizer returns the value to use, eg with problematic characters
+// normalized, or an error to reject the message with a HeaderValueError. Without this, header
+// values with newlines are rejected; StrictHeaderSanitizer also rejects other control characters.
+func WithHeaderSanitization(sanitizer func(name, value string) (string, error)) SignOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.sanitize = sanitizer },
+		v: func(v *verifier) { v.sanitize = sanitizer },
+	}
+}
+
+// WithBodyBuffering signs the whole request body, even if some of it was already read, eg by
+// an outer transport that logs the start of the body. The full body is recovered with the
+// request's GetBody, or by seeking back to the start if the body is an io.Seeker, and is sent
+// in place of what remains. Retries replay the buffered body.
+//
+// Without this, a partially read body is sent truncated, and may not match its digest.
+func WithBodyBuffering() SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.bufferBody = true },
+	}
+}
+
+// WithBodyDigestVerification checks the request body against its sha-256 `Content-Digest`
+// header, after verifying the signature. Requests without a `Content-Digest` header, or with a
+// body that doesn't match it, are rejected, with a `400` response unless WithErrorHandler is
+// set. Sign the header with WithBodyDigest to prevent both from being replaced.
+func WithBodyDigestVerification() VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.contentDigest = true },
+	}
+}
+
+// WithAlgorithmAllowlist rejects signatures using any algorithm other than algs, such as
+// `ecdsa-p256-sha256`, even when they are valid for their key. This guards against keys
+// accidentally configured with weaker algorithms. Without it, all configured algorithms are
+// accepted.
+func WithAlgorithmAllowlist(algs ...string) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.algs = algs },
+	}
+}
+
+// WithRequiredComponents rejects signatures that don't cover all of the given components, such
+// as `@method`, `@path` or `content-digest`. Without it, any signed components are accepted.
+func WithRequiredComponents(components ...string) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.required = components },
+	}
+}
+
+// WithAcceptSignature responds to unsigned requests in NewVerifyMiddleware, and those with
+// signatures missing a required component, with an `Accept-Signature` header asking for a
+// signature covering components, along with the algorithm of the verification keys if they all
+// share one. The components are also required, as with WithRequiredComponents. Clients using
+// WithAcceptSignatureRespect sign as asked, and retry.
+func WithAcceptSignature(components ...string) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) {
+			v.accept = components
+			v.required = append(v.required, components...)
+		},
+	}
+}
+
+// WithErrorHandler sets the handler that responds to requests rejected by NewVerifyMiddleware,
+// in place of the default plain text `401` response. Use the `Is*` error funcs to inspect err,
+// eg to respond with a `400` for malformed signatures. The handler must not call the wrapped
+// handler.
+func WithErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.errorHandler = fn },
+	}
+}
+
+// WithPassthroughOnNoSignature lets requests without signature headers through to the wrapped
+// handler, so signatures are optional. Handlers can tell signed requests apart with
+// SigningKeyFromContext. Requests with signatures that fail verification are still rejected.
+func WithPassthroughOnNoSignature() VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.passthrough = true },
+	}
+}
+
+// WithBase64URL decodes signatures as base64url (RFC 4648 section 5), with padding, rather than
+// standard base64, for signers that use it, such as those using WithBase64URLEncoding.
+// Signatures in standard base64 may then fail to decode.
+func WithBase64URL() VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.base64URL = true },
+	}
+}
+
+// WithLenientParsing accepts `Signature` and `Signature-Input` headers with whitespace around
+// their labels and values, eg `sig1 = :c2ln:`, and signatures without their surrounding colons,
+// as produced by some implementations. By default, these are rejected as malformed.
+//
+// Lenient parsing only relaxes the header syntax; the signature base and signature are still
+// checked as usual. However, it accepts input outside of the standard that other parsers, such
+// as a proxy checking the headers, may read differently. Only use it when required by a peer.
+func WithLenientParsing() VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.lenient = true },
+	}
+}
+
+// WithStrictMode rejects signatures with parameters other than those in the standard, eg
+// `foo="bar"`, as malformed. By default, unknown parameters are ignored, so that signatures
+// using future extensions still verify, though they remain covered by the signature.
+func WithStrictMode() VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.strict = true },
+	}
+}
+
+// WithMaxHeaderValueLength rejects signatures covering any header value longer than n bytes, as
+// malformed, to bound the work spent on hostile requests. The default is 8192 bytes; a negative
+// n removes the limit.
+func WithMaxHeaderValueLength(n int) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.maxHeaderValueLength = n },
+	}
+}
+
+// WithMaxSignatureInputLength rejects `Signature-Input` headers longer than n bytes, as
+// malformed. The default is 4096 bytes; a negative n removes the limit.
+func WithMaxSignatureInputLength(n int) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.maxSignatureInputLength = n },
+	}
+}
+
+// WithMaxComponents rejects signatures covering more than n components, as malformed. The
+// default is 20; a negative n removes the limit.
+func WithMaxComponents(n int) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.maxComponents = n },
+	}
+}
+
+// WithObfuscatedKeyID verifies signatures whose key id matches no key by trying each key
+// configured with the `WithVerify*` and `WithHmac*` options in turn, for signers that don't
+// reveal their key ids. The first key that verifies the signature is used, and its id is the
+// KeyID of the result. Keys from a KeyStore or KeyResolver aren't tried. If every key is
+// rejected before its signature is checked, eg for a missing required component, that error is
+// returned rather than an UnknownKeyError.
+func WithObfuscatedKeyID() VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.obfuscatedKeyID = true },
+	}
+}
+
+// WithKeyExpiry stops keyID from being used to verify signatures from expiry onwards, for
+// retiring keys on a schedule. Signatures using the key are then rejected, even if otherwise
+// valid, with an error for which IsKeyExpiredError is true.
+func WithKeyExpiry(keyID string, expiry time.Time) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) {
+			if v.keyExpiry == nil {
+				v.keyExpiry = make(map[string]time.Time)
+			}
+			v.keyExpiry[keyID] = expiry
+		},
+	}
+}
+
+// WithKeyRotation replaces oldKeyID with newKeyID, keeping both valid for the overlap after
+// the verifier is created, so signers can switch keys without downtime. Afterwards, signatures
+// using oldKeyID are rejected with a KeyExpiredError naming newKeyID. Both keys must also be
+// configured, eg with the `WithVerify*` options.
+func WithKeyRotation(oldKeyID, newKeyID string, overlap time.Duration) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) {
+			if v.keyMeta == nil {
+				v.keyMeta = make(map[string]keyMeta)
+			}
+			v.keyMeta[oldKeyID] = keyMeta{overlap: overlap, replacedBy: newKeyID}
+		},
+	}
+}
+
+// WithKeyStore checks store for keys not otherwise configured. Keys can be added to and removed
+// from the store while requests are being verified, eg to rotate keys.
+func WithKeyStore(store KeyStore) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.store = store },
+	}
+}
+
+// WithKeyResolver looks up keys using r when a signature's key id doesn't match any of the
+// configured keys. The resolver is called at most once per request, for the first signature.
+func WithKeyResolver(r KeyResolver) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.resolver = r },
+	}
+}
+
+// WithAdditionalSignature adds another signature to each message, under the given label,
+// alongside the signatures for the other configured keys (labelled `sig1`, `sig2`, etc).
+// The additional signature is configured only by opts, which must include exactly one signing
+// key; its components and parameters default as for NewSignTransport.
+//
+// Use this to sign the same message with different components or parameters for different
+// verifiers.
+func WithAdditionalSignature(label string, opts ...SigningOption) SigningOption {
+	return &optImpl{
+		s: func(s *signer) {
+			// Each signer gets its own additional signer, as it sets its defaults in place.
+			as := &signer{
+				keys: map[string]sigHolder{},
+			}
+
+			for _, o := range opts {
+				o.configureSign(as)
+			}
+
+			if err := validateLabel(label); err != nil {
+				as.err = err
+			}
+
+			s.additional = append(s.additional, additionalSignature{label: label, s: as})
+		},
+	}
+}
+
+// WithCustomDerivedComponent adds the derived component name, with the value returned by fn,
+// for components outside the standard, eg `@x-tenant-id` taken from a proprietary header. Sign
+// it with WithSigningComponents, and register the same component for verification. Errors from
+// fn fail signing or verification.
+//
+// name must start with `@`, and must not be a standard derived component. Other names are
+// reported as an error when signing, and are ignored when verifying.
+func WithCustomDerivedComponent(name string, fn func(msg *Message) (string, error)) SignOrVerifyOption {
+	valid := strings.HasPrefix(name, "@") && !derivedComponents[name] && fn != nil
+	if valid {
+		if c, err := parseComponent(name); err != nil || c.name != name {
+			valid = false
+		}
+	}
+
+	return &optImpl{
+		s: func(s *signer) {
+			if !valid {
+				s.err = errMalformedComponent
+				return
+			}
+
+			if s.custom == nil {
+				s.custom = make(customComponents)
+			}
+			s.custom[name] = fn
+		},
+		v: func(v *verifier) {
+			if !valid {
+				return
+			}
+
+			if v.custom == nil {
+				v.custom = make(customComponents)
+			}
+			v.custom[name] = fn
+		},
+	}
+}
+
+// WithSigningLabel labels signatures with label, rather than `sig1`, `sig2`, etc. With more than
+// one key, the signatures are numbered after the label, eg `label1`, `label2`. Use this to tell
+// apart signatures added by different services.
+//
+// Labels must be lowercase letters, digits, `_`, `-`, `.`, or `*`, starting with a letter or
+// `*`. Invalid labels are reported as an error when signing.
+func WithSigningLabel(label string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) {
+			s.label = label
+			if err := validateLabel(label); err != nil {
+				s.err = err
+			}
+		},
+	}
+}
+
+// WithForwardedSignature keeps the signatures already on requests, for proxies that sign the
+// requests they forward. Downstream services can then verify both the original client and the
+// proxy. An existing signature with the same label as the proxy's, eg `sig1`, is relabelled
+// to label.
+//
+// The forwarded signatures must still be valid for the request as sent, so the proxy must not
+// change signed components, eg by rewriting the path.
+func WithForwardedSignature(label string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) {
+			s.forwardLabel = label
+			if err := validateLabel(label); err != nil {
+				s.err = err
+			}
+		},
+	}
+}
+
+// withNowFunc replaces the clock used for signature times, for testing.
+func withNowFunc(fn func() time.Time) SignOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.nowFunc = fn },
+		v: func(v *verifier) { v.nowFunc = fn },
+	}
+}
+
+func init() {
+	// For the fixed clock of httpsigtest.TestSigner.
+	hooks.WithNowFunc = func(fn func() time.Time) interface{} { return withNowFunc(fn) }
+}
+
+// WithSignRsaPssSha512 adds signing using `rsa-pss-sha512` with the given private key
+// using the given key id.
+func WithSignRsaPssSha512(keyID string, pk *rsa.PrivateKey) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signRsaPssSha512(pk) },
+	}
+}
+
+// WithVerifyRsaPssSha512 adds signature verification using `rsa-pss-sha512` with the
+// given public key using the given key id.
+func WithVerifyRsaPssSha512(keyID string, pk *rsa.PublicKey) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.keys[keyID] = verifyRsaPssSha512(pk) },
+	}
+}
+
+// WithSignRsaPkcs1Sha256 adds signing using `rsa-pkcs1-sha256` (RSASSA-PKCS1-v1_5 with SHA-256)
+// with the given private key using the given key id.
+func WithSignRsaPkcs1Sha256(keyID string, pk *rsa.PrivateKey) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signRsaPkcs1Sha256(pk) },
+	}
+}
+
+// WithVerifyRsaPkcs1Sha256 adds signature verification using `rsa-pkcs1-sha256`
+// (RSASSA-PKCS1-v1_5 with SHA-256) with the given public key using the given key id.
+func WithVerifyRsaPkcs1Sha256(keyID string, pk *rsa.PublicKey) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.keys[keyID] = verifyRsaPkcs1Sha256(pk) },
+	}
+}
+
+// WithSignRsaPkcs1Sha512 adds signing using `rsa-pkcs1-sha512` (RSASSA-PKCS1-v1_5 with SHA-512)
+// with the given private key using the given key id.
+func WithSignRsaPkcs1Sha512(keyID string, pk *rsa.PrivateKey) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signRsaPkcs1Sha512(pk) },
+	}
+}
+
+// WithVerifyRsaPkcs1Sha512 adds signature verification using `rsa-pkcs1-sha512`
+// (RSASSA-PKCS1-v1_5 with SHA-512) with the given public key using the given key id.
+func WithVerifyRsaPkcs1Sha512(keyID string, pk *rsa.PublicKey) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.keys[keyID] = verifyRsaPkcs1Sha512(pk) },
+	}
+}
+
+// WithSignEcdsaP256Sha256 adds signing using `ecdsa-p256-sha256` with the given private key
+// using the given key id.
+func WithSignEcdsaP256Sha256(keyID string, pk *ecdsa.PrivateKey) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signEccP256(pk) },
+	}
+}
+
+// WithVerifyEcdsaP256Sha256 adds signature verification using `ecdsa-p256-sha256` with the
+// given public key using the given key id.
+func WithVerifyEcdsaP256Sha256(keyID string, pk *ecdsa.PublicKey) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.keys[keyID] = verifyEccP256(pk) },
+	}
+}
+
+// WithSignEcdsaP384Sha384 adds signing using `ecdsa-p384-sha384` with the given private key
+// using the given key id.
+func WithSignEcdsaP384Sha384(keyID string, pk *ecdsa.PrivateKey) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signEccP384(pk) },
+	}
+}
+
+// WithVerifyEcdsaP384Sha384 adds signature verification using `ecdsa-p384-sha384` with the
+// given public key using the given key id.
+func WithVerifyEcdsaP384Sha384(keyID string, pk *ecdsa.PublicKey) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.keys[keyID] = verifyEccP384(pk) },
+	}
+}
+
+// WithSignEcdsaP521Sha512 adds signing using `ecdsa-p521-sha512` with the given private key
+// using the given key id.
+func WithSignEcdsaP521Sha512(keyID string, pk *ecdsa.PrivateKey) SigningOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signEccP521(pk) },
+	}
+}
+
+// WithVerifyEcdsaP521Sha512 adds signature verification using `ecdsa-p521-sha512` with the
+// given public key using the given key id.
+func WithVerifyEcdsaP521Sha512(keyID string, pk *ecdsa.PublicKey) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.keys[keyID] = verifyEccP521(pk) },
+	}
+}
+
+// WithHmacSha256 adds signing or signature verification using `hmac-sha256` with the
+// given shared secret using the given key id.
+func WithHmacSha256(keyID string, secret []byte) SignOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signHmacSha256(secret) },
+		v: func(v *verifier) { v.keys[keyID] = verifyHmacSha256(secret) },
+	}
+}
+
+// WithHmacSha256Derived adds signature verification using `hmac-sha256` with a key derived
+// from masterKey for the key id of each signature, so every key id has its own secret and no
+// keys need to be configured in advance. deriveFunc returns the key for a key id; if nil,
+// DeriveHmacKey is used. Signers sign with the derived key for their key id, eg
+// `WithHmacSha256(keyID, key)` with the key from DeriveHmacKey.
+//
+// Key ids with a configured key, or one in a KeyStore, use that key instead.
+func WithHmacSha256Derived(masterKey []byte, deriveFunc func(masterKey []byte, keyID string) ([]byte, error)) VerifyOption {
+	if deriveFunc == nil {
+		deriveFunc = DeriveHmacKey
+	}
+
+	return &optImpl{
+		v: func(v *verifier) {
+			v.derive = func(keyID string) ([]byte, error) { return deriveFunc(masterKey, keyID) }
+		},
+	}
+}
+
+// WithHmacSha384 adds signing or signature verification using `hmac-sha384` with the
+// given shared secret using the given key id.
+func WithHmacSha384(keyID string, secret []byte) SignOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signHmacSha384(secret) },
+		v: func(v *verifier) { v.keys[keyID] = verifyHmacSha384(secret) },
+	}
+}
+
+// WithHmacSha512 adds signing or signature verification using `hmac-sha512` with the
+// given shared secret using the given key id.
+func WithHmacSha512(keyID string, secret []byte) SignOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.keys[keyID] = signHmacSha512(secret) },
+		v: func(v *verifier) { v.keys[keyID] = verifyHmacSha512(secret) },
 	}
 }
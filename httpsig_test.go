@@ -0,0 +1,1787 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "support-your-local-cat-bonnet-store"
+
+func TestResponseSigning(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			signed := NewSignResponseTransport(http.DefaultTransport, WithHmacSha256("key1", []byte(testSecret)))
+			verify := NewVerifyResponseMiddleware(WithHmacSha256("key1", []byte(testSecret)))
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal("could not create request:", err)
+			}
+
+			resp, err := verify(signed).RoundTrip(req)
+			if err != nil {
+				t.Fatal("round trip failed:", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != status {
+				t.Errorf("expected status %d. Got: %d", status, resp.StatusCode)
+			}
+
+			// Altering the status in between signing and verification must be caught.
+			tamper := rt(func(r *http.Request) (*http.Response, error) {
+				resp, err := signed.RoundTrip(r)
+				if err == nil {
+					resp.StatusCode = http.StatusTeapot
+				}
+				return resp, err
+			})
+
+			_, err = verify(tamper).RoundTrip(req)
+			if !IsInvalidSignatureError(err) {
+				t.Error("expected invalid signature. Got:", err)
+			}
+		})
+	}
+}
+
+// captureTransport records the last request it was sent, replying with an empty 200.
+type captureTransport struct {
+	req  *http.Request
+	body []byte
+}
+
+func (c *captureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.req = r
+
+	var err error
+	if r.Body != nil {
+		c.body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}, Request: r}, nil
+}
+
+func TestSignTransport_BodyDigest(t *testing.T) {
+	for _, body := range []io.Reader{nil, strings.NewReader(`{"hello": "world"}`), ioutil.NopCloser(strings.NewReader("no GetBody"))} {
+		ct := &captureTransport{}
+		client := http.Client{
+			Transport: NewSignTransport(ct, WithHmacSha256("key1", []byte(testSecret)), WithBodyDigest()),
+		}
+
+		resp, err := client.Post("https://example.com/", "application/json", body)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		if got := ct.req.Header.Get("Content-Digest"); got != calcContentDigest(ct.body) {
+			t.Errorf("unexpected content digest for %q. Got: %s", ct.body, got)
+		}
+
+		if !strings.Contains(ct.req.Header.Get("Signature-Input"), `"content-digest"`) {
+			t.Error("content-digest not signed. Got:", ct.req.Header.Get("Signature-Input"))
+		}
+
+		v := testVerifier("key1", verifyHmacSha256([]byte(testSecret)))
+		if _, err := v.Verify(NewRequestMessage(ct.req)); err != nil {
+			t.Error("verification failed:", err)
+		}
+
+		// A new body, with a matching digest, must still fail as the digest is signed.
+		ct.req.Header.Set("Content-Digest", calcContentDigest([]byte(`{"hello": "mallory"}`)))
+		if _, err := v.Verify(NewRequestMessage(ct.req)); !IsInvalidSignatureError(err) {
+			t.Error("expected invalid signature. Got:", err)
+		}
+	}
+}
+
+func TestVerifyMiddleware_BodyDigest(t *testing.T) {
+	secret := []byte(testSecret)
+
+	// sign returns a signed request for body, as sent through a signing transport.
+	sign := func(t *testing.T, body string) *http.Request {
+		ct := &captureTransport{}
+		client := http.Client{Transport: NewSignTransport(ct, WithHmacSha256("key1", secret), WithBodyDigest())}
+
+		var rb io.Reader
+		if body != "" {
+			rb = strings.NewReader(body)
+		}
+
+		resp, err := client.Post("http://example.com/", "application/json", rb)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(ct.body))
+		req.Header = ct.req.Header
+		req.Host = "example.com"
+		return req
+	}
+
+	tcs := []struct {
+		name   string
+		req    func(t *testing.T) *http.Request
+		status int
+	}{
+		{"missing body", func(t *testing.T) *http.Request { return sign(t, "") }, http.StatusOK},
+		{"correct digest", func(t *testing.T) *http.Request { return sign(t, `{"hello": "world"}`) }, http.StatusOK},
+		{"corrupted body", func(t *testing.T) *http.Request {
+			r := sign(t, `{"hello": "world"}`)
+			r.Body = ioutil.NopCloser(strings.NewReader(`{"hello": "mallory"}`))
+			return r
+		}, http.StatusBadRequest},
+		{"absent content digest", func(t *testing.T) *http.Request {
+			ct := &captureTransport{}
+			client := http.Client{Transport: NewSignTransport(ct, WithHmacSha256("key1", secret))}
+
+			resp, err := client.Post("http://example.com/", "application/json", strings.NewReader("hi"))
+			if err != nil {
+				t.Fatal("request failed:", err)
+			}
+			resp.Body.Close()
+
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(ct.body))
+			req.Header = ct.req.Header
+			req.Host = "example.com"
+			return req
+		}, http.StatusBadRequest},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []byte
+			h := NewVerifyMiddleware(WithHmacSha256("key1", secret), WithBodyDigestVerification())(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					got, _ = ioutil.ReadAll(r.Body)
+				}))
+
+			req := tc.req(t)
+			body, _ := ioutil.ReadAll(req.Body)
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d. Got: %d", tc.status, rec.Code)
+			}
+
+			// The body must still be readable by the wrapped handler.
+			if tc.status == http.StatusOK && !bytes.Equal(got, body) {
+				t.Errorf("handler got body %q, expected %q", got, body)
+			}
+		})
+	}
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	body := []byte(`{"hello": "world"}`)
+
+	if err := verifyContentDigest(body, calcContentDigest(body)); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if err := verifyContentDigest(body, ""); !IsBodyDigestMismatchError(err) {
+		t.Error("expected missing digest to fail. Got:", err)
+	}
+
+	if err := verifyContentDigest([]byte("other"), calcContentDigest(body)); !IsBodyDigestMismatchError(err) {
+		t.Error("expected mismatched digest to fail. Got:", err)
+	}
+}
+
+func TestSignTransport_AdditionalSignature(t *testing.T) {
+	secret := []byte(testSecret)
+	partnerSecret := []byte("partner-secret")
+
+	ct := &captureTransport{}
+	client := http.Client{
+		Transport: NewSignTransport(ct,
+			WithHmacSha256("key1", secret),
+			WithAdditionalSignature("partner", WithHmacSha512("partner-key", partnerSecret), WithCreated()),
+		),
+	}
+
+	resp, err := client.Post("http://example.com/", "application/json", strings.NewReader("hi"))
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	for _, hdr := range []string{"Signature", "Signature-Input"} {
+		got := ct.req.Header.Get(hdr)
+		if !strings.HasPrefix(got, "sig1=") || !strings.Contains(got, ", partner=") {
+			t.Errorf("expected both signatures in %s. Got: %s", hdr, got)
+		}
+	}
+
+	tcs := []struct {
+		name   string
+		opts   []VerifyOption
+		status int
+	}{
+		{"first key", []VerifyOption{WithHmacSha256("key1", secret)}, http.StatusOK},
+		{"additional key", []VerifyOption{WithHmacSha512("partner-key", partnerSecret)}, http.StatusOK},
+		{"no known key", []VerifyOption{WithHmacSha256("other-key", secret)}, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewVerifyMiddleware(tc.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(ct.body))
+			req.Header = ct.req.Header
+			req.Host = "example.com"
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d. Got: %d", tc.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestWithAdditionalSignature_Shared(t *testing.T) {
+	secret := []byte(testSecret)
+	proxy := WithAdditionalSignature("proxy", WithHmacSha256("key2", secret))
+
+	// Signing a response must not leave response components on the shared option.
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	if err := SignResponse(resp, WithHmacSha256("key1", secret), proxy); err != nil {
+		t.Fatal("signing response failed:", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			if err := SignRequest(req, WithHmacSha256("key1", secret), proxy); err != nil {
+				t.Error("signing request failed:", err)
+				return
+			}
+
+			if got := req.Header.Get("Signature-Input"); !strings.Contains(got, `proxy=("@method"`) {
+				t.Error("unexpected request components. Got:", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := resp.Header.Get("Signature-Input"); !strings.Contains(got, `proxy=("@status"`) {
+		t.Error("unexpected response components. Got:", got)
+	}
+}
+
+func TestSignTransport_ContextSigningOptions(t *testing.T) {
+	secret := []byte(testSecret)
+	tenantSecret := []byte("tenant-secret")
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewSignTransport(ct, WithHmacSha256("default", secret), WithCreated())}
+
+	get := func(ctx context.Context) *Message {
+		req, err := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatal("could not create request:", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		return NewRequestMessage(ct.req)
+	}
+
+	msg := get(context.Background())
+	if _, err := VerifyMessage(msg, WithHmacSha256("default", secret)); err != nil {
+		t.Error("verification with the default key failed:", err)
+	}
+
+	ctx := WithContextSigningOptions(context.Background(), WithHmacSha256("tenant-a", tenantSecret))
+	msg = get(WithContextSigningOptions(ctx, WithNonce(func() string { return "n1" })))
+
+	res, err := VerifyMessage(msg, WithHmacSha256("default", secret), WithHmacSha256("tenant-a", tenantSecret))
+	if err != nil || res.KeyID != "tenant-a" {
+		t.Errorf("expected tenant-a key. Got: %+v %v", res, err)
+	}
+
+	if got := msg.Header.Get("Signature-Input"); strings.Contains(got, "default") || !strings.Contains(got, `created=`) || !strings.Contains(got, `nonce="n1"`) {
+		t.Error("unexpected signature input. Got:", got)
+	}
+
+	// Options that configure no keys keep the transport's keys.
+	msg = get(WithContextSigningOptions(context.Background(), WithNonce(func() string { return "n2" })))
+	if res, err := VerifyMessage(msg, WithHmacSha256("default", secret)); err != nil || res.KeyID != "default" {
+		t.Errorf("expected default key. Got: %+v %v", res, err)
+	}
+}
+
+func TestWithAdditionalSignature_InheritsEachClock(t *testing.T) {
+	secret := []byte(testSecret)
+	proxy := WithAdditionalSignature("proxy", WithHmacSha256("key2", secret), WithCreated())
+
+	for _, created := range []int64{1000, 2000} {
+		clock := withNowFunc(func() time.Time { return time.Unix(created, 0) })
+
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if err := SignRequest(req, WithHmacSha256("key1", secret), clock, proxy); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		want := fmt.Sprintf(`;created=%d;keyid="key2"`, created)
+		if got := req.Header.Get("Signature-Input"); !strings.HasSuffix(got, want) {
+			t.Errorf("expected proxy signature created at %d. Got: %s", created, got)
+		}
+	}
+}
+
+func TestSignTransport_AdditionalSignatureErrors(t *testing.T) {
+	secret := []byte(testSecret)
+
+	tcs := map[string][]SigningOption{
+		"duplicate label": {WithHmacSha256("key1", secret), WithAdditionalSignature("sig1", WithHmacSha256("key2", secret))},
+		"no key":          {WithHmacSha256("key1", secret), WithAdditionalSignature("partner")},
+		"two keys": {WithAdditionalSignature("partner",
+			WithHmacSha256("key1", secret), WithHmacSha256("key2", secret))},
+	}
+
+	for name, opts := range tcs {
+		t.Run(name, func(t *testing.T) {
+			client := http.Client{Transport: NewSignTransport(&captureTransport{}, opts...)}
+
+			if _, err := client.Get("http://example.com/"); err == nil {
+				t.Error("expected signing error")
+			}
+		})
+	}
+}
+
+func TestVerifyMiddleware_SigningKeyFromContext(t *testing.T) {
+	secret := []byte(testSecret)
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewSignTransport(ct, WithHmacSha512("key1", secret))}
+
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	var got VerifyResult
+	var ok bool
+	h := NewVerifyMiddleware(WithHmacSha512("key1", secret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = SigningKeyFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header = ct.req.Header
+	req.Host = "example.com"
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !ok || got.KeyID != "key1" || got.Alg != "hmac-sha512" {
+		t.Errorf("unexpected result in context: %+v, %t", got, ok)
+	}
+
+	if _, ok := SigningKeyFromContext(req.Context()); ok {
+		t.Error("expected no result on an unverified context")
+	}
+}
+
+func TestVerifyMiddleware_Logger(t *testing.T) {
+	secret := []byte(testSecret)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	h := NewVerifyMiddleware(WithHmacSha256("key1", secret), WithLogger(logger)).Then(http.NotFoundHandler())
+
+	serve := func(s []byte) {
+		buf.Reset()
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if err := SignRequest(req, WithHmacSha256("key1", s)); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(secret)
+	for _, want := range []string{"level=DEBUG", "key_id=key1", "alg=hmac-sha256"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in log. Got: %s", want, buf.String())
+		}
+	}
+
+	serve([]byte("wrong"))
+	for _, want := range []string{"level=WARN", "key_id=key1", "error=", "remote_addr=192.0.2.1:1234"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in log. Got: %s", want, buf.String())
+		}
+	}
+}
+
+// Run with -race to check concurrent requests don't share verification state.
+func TestVerifyMiddleware_Concurrent(t *testing.T) {
+	secret := []byte(testSecret)
+
+	srv := httptest.NewServer(NewVerifyMiddleware(WithHmacSha256("key1", secret), WithCreatedWindow(time.Minute)).Then(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	))
+	defer srv.Close()
+
+	client := http.Client{Transport: NewSignTransport(http.DefaultTransport, WithHmacSha256("key1", secret), WithCreated())}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error("request failed:", err)
+	}
+}
+
+func TestVerifierClone(t *testing.T) {
+	v := newVerifier([]VerifyOption{WithHmacSha256("key1", []byte(testSecret))})
+
+	cv := v.Clone()
+	cv.keys["key2"] = verifyHmacSha256([]byte("other"))
+	cv.nowFunc = func() time.Time { return time.Unix(0, 0) }
+
+	if _, ok := v.keys["key2"]; ok {
+		t.Error("key added to clone was added to the original")
+	}
+
+	if v.nowFunc().Unix() == 0 {
+		t.Error("clock set on clone was set on the original")
+	}
+
+	if _, ok := cv.keys["key1"]; !ok {
+		t.Error("clone is missing the original's keys")
+	}
+}
+
+func TestVerifyMiddleware_ErrorHandler(t *testing.T) {
+	secret := []byte(testSecret)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler called for a rejected request")
+	})
+
+	malformed := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Signature-Input", "sig1=junk")
+		req.Header.Set("Signature", "sig1=:junk:")
+		return req
+	}
+
+	t.Run("default", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		NewVerifyMiddleware(WithHmacSha256("key1", secret))(next).ServeHTTP(rec, malformed())
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d. Got: %d", http.StatusUnauthorized, rec.Code)
+		}
+
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Error("expected plain text response. Got:", ct)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		eh := func(w http.ResponseWriter, r *http.Request, err error) {
+			w.Header().Set("X-Error", err.Error())
+
+			switch {
+			case IsMalformedSignatureError(err):
+				w.WriteHeader(http.StatusBadRequest)
+			case IsNotSignedError(err):
+				w.WriteHeader(http.StatusForbidden)
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+
+		mw := NewVerifyMiddleware(WithHmacSha256("key1", secret), WithErrorHandler(eh))
+
+		rec := httptest.NewRecorder()
+		mw(next).ServeHTTP(rec, malformed())
+		if rec.Code != http.StatusBadRequest || rec.Header().Get("X-Error") != errMalformedSignature.Error() {
+			t.Errorf("unexpected response for malformed signature: %d %q", rec.Code, rec.Header().Get("X-Error"))
+		}
+
+		rec = httptest.NewRecorder()
+		mw(next).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("unexpected response for unsigned request: %d", rec.Code)
+		}
+	})
+}
+
+func TestVerifyMiddleware_PassthroughOnNoSignature(t *testing.T) {
+	secret := []byte(testSecret)
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewSignTransport(ct, WithHmacSha256("key1", secret))}
+
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	tcs := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+		signed bool
+	}{
+		{"signed", func() *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header = ct.req.Header.Clone()
+			req.Host = "example.com"
+			return req
+		}, http.StatusOK, true},
+		{"unsigned", func() *http.Request { return httptest.NewRequest("GET", "/", nil) }, http.StatusOK, false},
+		{"malformed", func() *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Signature-Input", "sig1=junk")
+			req.Header.Set("Signature", "sig1=:junk:")
+			return req
+		}, http.StatusUnauthorized, false},
+		{"invalid", func() *http.Request {
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header = ct.req.Header.Clone()
+			req.Host = "example.com"
+			return req
+		}, http.StatusUnauthorized, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var signed bool
+			h := NewVerifyMiddleware(WithHmacSha256("key1", secret), WithPassthroughOnNoSignature())(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, signed = SigningKeyFromContext(r.Context())
+				}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tc.req())
+
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d. Got: %d", tc.status, rec.Code)
+			}
+
+			if signed != tc.signed {
+				t.Errorf("expected signed to be %t", tc.signed)
+			}
+		})
+	}
+}
+
+func TestBidirectionalSignTransport(t *testing.T) {
+	secret := []byte(testSecret)
+
+	for name, opts := range map[string][]SigningOption{
+		"default components": nil,
+		"exact components":   {WithSigningComponents("@method", "@path")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var reqSigned bool
+			srv := httptest.NewServer(NewVerifyMiddleware(WithHmacSha256("key1", secret))(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, reqSigned = SigningKeyFromContext(r.Context())
+					w.Header().Set("Content-Type", "text/plain")
+				})))
+			defer srv.Close()
+
+			client := http.Client{
+				Transport: NewBidirectionalSignTransport(http.DefaultTransport, append(opts, WithHmacSha256("key1", secret))...),
+			}
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal("request failed:", err)
+			}
+			resp.Body.Close()
+
+			if !reqSigned {
+				t.Error("request was not signed")
+			}
+
+			if resp.Header.Get("Signature") == "" {
+				t.Fatal("response was not signed")
+			}
+
+			v := testVerifier("key1", verifyHmacSha256(secret))
+			if _, err := v.Verify(NewResponseMessage(resp)); err != nil {
+				t.Error("response verification failed:", err)
+			}
+
+			if got := resp.Header.Get("Signature-Input"); strings.Contains(got, "@method") || !strings.Contains(got, "@status") {
+				t.Error("unexpected response components. Got:", got)
+			}
+		})
+	}
+}
+
+func TestBidirectionalSignTransport_AdditionalSignature(t *testing.T) {
+	secret, proxySecret := []byte(testSecret), []byte("the-proxy-secret")
+
+	srv := httptest.NewServer(NewVerifyMiddleware(WithHmacSha256("key2", proxySecret))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+		})))
+	defer srv.Close()
+
+	client := http.Client{
+		Transport: NewBidirectionalSignTransport(http.DefaultTransport,
+			WithHmacSha256("key1", secret),
+			WithAdditionalSignature("proxy", WithHmacSha256("key2", proxySecret), WithSigningComponents("@method", "@path")),
+		),
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("request with additional signature rejected:", resp.StatusCode)
+		}
+
+		if err := VerifyResponse(resp, WithHmacSha256("key2", proxySecret)); err != nil {
+			t.Error("response verification failed:", err)
+		}
+
+		if got := resp.Header.Get("Signature-Input"); !strings.Contains(got, `proxy=("@status");keyid="key2"`) {
+			t.Error("unexpected response components. Got:", got)
+		}
+	}
+}
+
+func TestVerifyResponseTransport(t *testing.T) {
+	secret := []byte(testSecret)
+
+	// The server signs its own responses, setting the headers by hand.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		if r.URL.Path == "/unsigned" {
+			return
+		}
+
+		s := testSigner("server-key", signHmacSha256(secret))
+		s.headers = []string{"@status", "content-type"}
+
+		hdr, err := s.Sign(&Message{StatusCode: http.StatusAccepted, Header: w.Header()})
+		if err != nil {
+			t.Error("signing failed:", err)
+		}
+
+		w.Header().Set("Signature", hdr.Get("Signature"))
+		w.Header().Set("Signature-Input", hdr.Get("Signature-Input"))
+
+		if r.URL.Path == "/tampered" {
+			w.Header().Set("Content-Type", "text/html")
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	client := http.Client{
+		Transport: NewVerifyResponseTransport(http.DefaultTransport, WithHmacSha256("server-key", secret)),
+	}
+
+	resp, err := client.Get(srv.URL + "/signed")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Error("unexpected status. Got:", resp.StatusCode)
+	}
+
+	tcs := map[string]func(error) bool{
+		"/unsigned": IsNotSignedError,
+		"/tampered": IsInvalidSignatureError,
+	}
+
+	for path, isErr := range tcs {
+		if _, err := client.Get(srv.URL + path); err == nil || !isErr(err) {
+			t.Errorf("unexpected error for %s. Got: %v", path, err)
+		}
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	now := func() time.Time { return time.Unix(1618884475, 0) }
+	opts := []SigningOption{
+		WithHmacSha256("key1", []byte(testSecret)),
+		WithCreated(),
+		WithBodyDigest(),
+		withNowFunc(now),
+	}
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewSignTransport(ct, opts...)}
+
+	resp, err := client.Post("https://example.com/foo?bar=baz", "application/json", strings.NewReader(`{"hello": "world"}`))
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	req, err := http.NewRequest("POST", "https://example.com/foo?bar=baz", strings.NewReader(`{"hello": "world"}`))
+	if err != nil {
+		t.Fatal("could not create request:", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := SignRequest(req, opts...); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	for _, h := range []string{"Signature", "Signature-Input", "Digest", "Content-Digest"} {
+		if got, want := req.Header.Get(h), ct.req.Header.Get(h); got == "" || got != want {
+			t.Errorf("unexpected %s.\nExpected: %s\nGot:      %s", h, want, got)
+		}
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil || string(b) != `{"hello": "world"}` {
+		t.Errorf("body not restored. Got: %q, %v", b, err)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err := VerifyRequest(req, WithHmacSha256("key1", []byte(testSecret)), WithBodyDigestVerification()); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	b, err = ioutil.ReadAll(req.Body)
+	if err != nil || string(b) != `{"hello": "world"}` {
+		t.Errorf("body not restored after verification. Got: %q, %v", b, err)
+	}
+
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"hello": "mallory"}`))
+	if err := VerifyRequest(req, WithHmacSha256("key1", []byte(testSecret))); !IsBodyDigestMismatchError(err) {
+		t.Error("expected body digest mismatch. Got:", err)
+	}
+}
+
+func TestSignResponse(t *testing.T) {
+	secret := []byte(testSecret)
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "text/plain")
+	rec.WriteHeader(http.StatusCreated)
+	io.WriteString(rec, "hello")
+
+	resp := rec.Result()
+	if err := SignResponse(resp, WithHmacSha256("key1", secret)); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if got := resp.Header.Get("Signature-Input"); !strings.HasPrefix(got, `sig1=("@status" "content-type")`) {
+		t.Error("unexpected signature input. Got:", got)
+	}
+
+	if err := VerifyResponse(resp, WithHmacSha256("key1", secret), WithRequiredComponents("@status")); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	resp.StatusCode = http.StatusOK
+	if err := VerifyResponse(resp, WithHmacSha256("key1", secret)); !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+
+	if err := VerifyResponse(httptest.NewRecorder().Result(), WithHmacSha256("key1", secret)); !IsNotSignedError(err) {
+		t.Error("expected not signed. Got:", err)
+	}
+}
+
+func TestSignHandler(t *testing.T) {
+	secret := []byte(testSecret)
+
+	sign := NewSignHandler(WithHmacSha256("key1", secret), WithBodyDigest())
+	srv := httptest.NewServer(sign(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello, world")
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("could not read body:", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated || string(body) != "hello, world" {
+		t.Errorf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+
+	want := `sig1=("@status" "content-type" "content-length" "content-digest")`
+	if got := resp.Header.Get("Signature-Input"); !strings.HasPrefix(got, want) {
+		t.Error("unexpected signature input. Got:", got)
+	}
+
+	if err := verifyContentDigest(body, resp.Header.Get("Content-Digest")); err != nil {
+		t.Error("content digest mismatch:", err)
+	}
+
+	if err := VerifyResponse(resp, WithHmacSha256("key1", secret), WithRequiredComponents("@status", "content-digest")); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	// A response that can't be signed is replaced.
+	rec := httptest.NewRecorder()
+	signExact := NewSignHandler(WithHmacSha256("key1", secret), WithSigningComponents("@status", "x-missing"))
+	signExact.Then(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Signature") != "" {
+		t.Errorf("expected unsigned 500 response. Got: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRequestResponseBinding(t *testing.T) {
+	secret := []byte(testSecret)
+
+	signedReq := func(path string) *http.Request {
+		req := httptest.NewRequest("GET", "https://example.com"+path, nil)
+		if err := SignRequest(req, WithHmacSha256("client-key", secret)); err != nil {
+			t.Fatal("signing request failed:", err)
+		}
+		return req
+	}
+
+	req := signedReq("/foo")
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "text/plain")
+	rec.WriteHeader(http.StatusOK)
+
+	resp := rec.Result()
+	resp.Request = req
+
+	opts := []SigningOption{
+		WithHmacSha256("server-key", secret),
+		WithSigningComponents("@status", "content-type", `@request-response;key="sig1"`),
+	}
+	if err := SignResponse(resp, opts...); err != nil {
+		t.Fatal("signing response failed:", err)
+	}
+
+	if got := resp.Header.Get("Signature-Input"); !strings.Contains(got, `"@request-response";key="sig1"`) {
+		t.Error("request-response not signed. Got:", got)
+	}
+
+	verify := func(resp *http.Response) error {
+		return VerifyResponse(resp, WithHmacSha256("server-key", secret))
+	}
+
+	if err := verify(resp); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	// A valid response swapped onto another request must not verify.
+	resp.Request = signedReq("/bar")
+	if err := verify(resp); !IsInvalidSignatureError(err) {
+		t.Error("expected invalid signature for another request. Got:", err)
+	}
+
+	resp.Request = nil
+	if err := verify(resp); !IsMissingHeaderError(err) {
+		t.Error("expected missing request signature. Got:", err)
+	}
+
+	if err := SignRequest(httptest.NewRequest("GET", "/", nil), opts...); err == nil {
+		t.Error("expected @request-response to fail on a request")
+	}
+}
+
+func TestSignTransport_ForwardedSignature(t *testing.T) {
+	clientSecret := []byte(testSecret)
+	proxySecret := []byte("another-secret-for-the-proxy")
+
+	req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+	if err != nil {
+		t.Fatal("could not create request:", err)
+	}
+
+	if err := SignRequest(req, WithHmacSha256("client-key", clientSecret)); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	ct := &captureTransport{}
+	client := http.Client{
+		Transport: NewSignTransport(ct, WithHmacSha256("proxy-key", proxySecret), WithForwardedSignature("client")),
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	sig := ct.req.Header.Get("Signature")
+	if !strings.HasPrefix(sig, "client=:") || !strings.Contains(sig, ", sig1=:") {
+		t.Error("unexpected signatures. Got:", sig)
+	}
+
+	if got := ct.req.Header.Get("Signature-Input"); !strings.Contains(got, `keyid="client-key"`) || !strings.Contains(got, `keyid="proxy-key"`) {
+		t.Error("unexpected signature input. Got:", got)
+	}
+
+	for keyID, vh := range map[string]verHolder{"client-key": verifyHmacSha256(clientSecret), "proxy-key": verifyHmacSha256(proxySecret)} {
+		v := testVerifier(keyID, vh)
+		if res, err := v.Verify(NewRequestMessage(ct.req)); err != nil || res.KeyID != keyID {
+			t.Errorf("verification with %s failed: %v", keyID, err)
+		}
+	}
+
+	// Without the option, the incoming signature is replaced.
+	client.Transport = NewSignTransport(ct, WithHmacSha256("proxy-key", proxySecret))
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	if got := ct.req.Header.Get("Signature-Input"); strings.Contains(got, "client-key") {
+		t.Error("unexpected forwarded signature. Got:", got)
+	}
+
+	client.Transport = NewSignTransport(ct, WithHmacSha256("proxy-key", proxySecret), WithForwardedSignature("sig1"))
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected duplicate label error")
+	}
+}
+
+// seekBody is a request body that can seek, but that http.NewRequest doesn't know how to copy.
+type seekBody struct{ *strings.Reader }
+
+func (seekBody) Close() error { return nil }
+
+func TestSignTransport_BodyBuffering(t *testing.T) {
+	const body = `{"hello": "world"}`
+
+	tcs := map[string]func() *http.Request{
+		"get body": func() *http.Request {
+			req, _ := http.NewRequest("POST", "https://example.com/", strings.NewReader(body))
+			return req
+		},
+		"seeker": func() *http.Request {
+			req, _ := http.NewRequest("POST", "https://example.com/", seekBody{strings.NewReader(body)})
+			return req
+		},
+	}
+
+	for name, newReq := range tcs {
+		t.Run(name, func(t *testing.T) {
+			ct := &captureTransport{}
+			st := NewSignTransport(ct, WithHmacSha256("key1", []byte(testSecret)), WithBodyDigest(), WithBodyBuffering())
+
+			// Read the start of the body before signing, as a logging transport might.
+			logging := rt(func(r *http.Request) (*http.Response, error) {
+				if _, err := io.ReadFull(r.Body, make([]byte, 5)); err != nil {
+					return nil, err
+				}
+				return st.RoundTrip(r)
+			})
+
+			resp, err := logging.RoundTrip(newReq())
+			if err != nil {
+				t.Fatal("request failed:", err)
+			}
+			resp.Body.Close()
+
+			if string(ct.body) != body {
+				t.Errorf("unexpected body sent. Got: %q", ct.body)
+			}
+
+			if got := ct.req.Header.Get("Content-Digest"); got != calcContentDigest([]byte(body)) {
+				t.Error("unexpected content digest. Got:", got)
+			}
+
+			retry, err := ct.req.GetBody()
+			if err != nil {
+				t.Fatal("could not get body:", err)
+			}
+
+			if b, _ := ioutil.ReadAll(retry); string(b) != body {
+				t.Errorf("unexpected retry body. Got: %q", b)
+			}
+		})
+	}
+}
+
+func TestVerifyMiddleware_KeyRotation(t *testing.T) {
+	oldSecret, newSecret := []byte(testSecret), []byte("the-new-shared-secret")
+
+	start := time.Unix(1618884475, 0)
+	now := start
+	clock := func() time.Time { return now }
+
+	tv, mw := newTestVerifyMiddleware(
+		WithHmacSha256("old-key", oldSecret),
+		WithHmacSha256("new-key", newSecret),
+		WithKeyRotation("old-key", "new-key", time.Hour),
+		withNowFunc(clock),
+	)
+	h := mw(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	serve := func(keyID string, secret []byte) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if err := SignRequest(req, WithHmacSha256(keyID, secret), withNowFunc(clock)); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	tcs := []struct {
+		at      time.Duration
+		keyID   string
+		secret  []byte
+		allowed bool
+	}{
+		{0, "old-key", oldSecret, true},
+		{0, "new-key", newSecret, true},
+		{time.Hour - time.Second, "old-key", oldSecret, true},
+		{time.Hour, "old-key", oldSecret, false},
+		{time.Hour, "new-key", newSecret, true},
+	}
+
+	for _, tc := range tcs {
+		now = start.Add(tc.at)
+		serve(tc.keyID, tc.secret)
+
+		if res, err := tv.LastResult(); tv.WasVerified() != tc.allowed || (tc.allowed && res.KeyID != tc.keyID) {
+			t.Errorf("%s at %s: unexpected result %+v: %v", tc.keyID, tc.at, res, err)
+		}
+	}
+
+	now = start.Add(time.Hour)
+	serve("old-key", oldSecret)
+
+	_, handled := tv.LastResult()
+	var kerr *KeyExpiredError
+	if !errors.As(handled, &kerr) || kerr.ReplacedBy != "new-key" || !kerr.ExpiredAt.Equal(start.Add(time.Hour)) {
+		t.Errorf("unexpected key expired error: %v", handled)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	secret := []byte(testSecret)
+	mw := NewVerifyMiddleware(WithHmacSha256("key1", secret))
+
+	// Middleware is still usable as a plain func.
+	var f func(http.Handler) http.Handler = mw
+	_ = f
+
+	signed := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if err := SignRequest(req, WithHmacSha256("key1", secret)); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	mw.Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})).ServeHTTP(rec, signed())
+
+	if rec.Code != http.StatusTeapot {
+		t.Error("unexpected status from Then. Got:", rec.Code)
+	}
+
+	for req, want := range map[*http.Request]int{
+		signed(): http.StatusNotFound,
+		httptest.NewRequest("GET", "http://example.com/", nil): http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		mw.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("unexpected status as a handler. Expected %d, got %d", want, rec.Code)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", mw)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Error("unexpected status from mux. Got:", rec.Code)
+	}
+}
+
+func TestAcceptSignature(t *testing.T) {
+	var bodies []string
+	h := NewVerifyMiddleware(
+		WithHmacSha256("test-key", []byte(testSecret)),
+		WithAcceptSignature("@method", "@path", "x-tenant", "content-digest"),
+	).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	want := `sig1=("@method" "@path" "x-tenant" "content-digest");alg="hmac-sha256"`
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("Accept-Signature") != want {
+		t.Fatalf("unexpected unsigned response: %d %q", resp.StatusCode, resp.Header.Get("Accept-Signature"))
+	}
+
+	send := func(opts ...SigningOption) int {
+		c := &http.Client{Transport: NewSignTransport(http.DefaultTransport, opts...)}
+
+		req, err := http.NewRequest("POST", s.URL+"/items", strings.NewReader(`{"hello": "world"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Tenant", "acme")
+
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal("request failed:", err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if code := send(WithHmacSha256("test-key", []byte(testSecret))); code != http.StatusUnauthorized {
+		t.Errorf("expected the default components to be rejected. Got: %d", code)
+	}
+
+	bodies = nil
+	opts := []SigningOption{
+		WithHmacSha256("test-key", []byte(testSecret)),
+		WithSigningComponents("@method"),
+		WithAcceptSignatureRespect(),
+	}
+	if code := send(opts...); code != http.StatusOK {
+		t.Errorf("expected the retry to be accepted. Got: %d", code)
+	}
+
+	if len(bodies) != 1 || bodies[0] != `{"hello": "world"}` {
+		t.Errorf("unexpected bodies handled: %q", bodies)
+	}
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es := newRequestSigner([]SigningOption{WithSignEcdsaP256Sha256("other-key", pk)})
+	if _, err := es.accepting(want); !errors.Is(err, errNoAcceptableKey) {
+		t.Errorf("expected no signer for another algorithm. Got: %v", err)
+	}
+}
+
+func TestAcceptSignature_Alg(t *testing.T) {
+	secret := []byte(testSecret)
+
+	noAlg := verifyHmacSha256(secret)
+	noAlg.alg = ""
+
+	tcs := []struct {
+		name string
+		opts []VerifyOption
+		want string
+	}{
+		{"shared", []VerifyOption{WithHmacSha256("key1", secret), WithHmacSha256("key2", secret)}, `;alg="hmac-sha256"`},
+		{"mixed", []VerifyOption{WithHmacSha256("key1", secret), WithHmacSha512("key2", secret)}, ""},
+		{"without alg", []VerifyOption{WithHmacSha256("key1", secret), &optImpl{
+			v: func(v *verifier) { v.keys["key2"] = noAlg },
+		}}, ""},
+		{"key store", []VerifyOption{WithHmacSha256("key1", secret), WithKeyStore(NewInMemoryKeyStore())}, ""},
+		{"resolver", []VerifyOption{WithHmacSha256("key1", secret), WithKeyResolver(&mockResolver{})}, ""},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newVerifier(append(tc.opts, WithAcceptSignature("@method")))
+
+			// Map iteration order is random, so check more than once.
+			for i := 0; i < 20; i++ {
+				got, err := v.acceptSignature()
+				if err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+
+				if want := `sig1=("@method")` + tc.want; got != want {
+					t.Fatalf("unexpected Accept-Signature %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRequest_DerivedHmacKeys(t *testing.T) {
+	master := []byte(testSecret)
+	derived := func(keyID string) []byte {
+		k, err := DeriveHmacKey(master, keyID)
+		if err != nil {
+			t.Fatal("derivation failed:", err)
+		}
+		return k
+	}
+
+	signed := func(keyID string, secret []byte) *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if err := SignRequest(req, WithHmacSha256(keyID, secret)); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+		return req
+	}
+
+	if err := VerifyRequest(signed("client-1", derived("client-1")), WithHmacSha256Derived(master, nil)); err != nil {
+		t.Error("expected derived key to verify. Got:", err)
+	}
+
+	// Claiming another key id needs that key id's key.
+	req := signed("client-1", derived("client-1"))
+	req.Header.Set("Signature-Input", strings.Replace(req.Header.Get("Signature-Input"), `"client-1"`, `"client-2"`, 1))
+	if err := VerifyRequest(req, WithHmacSha256Derived(master, nil)); !IsInvalidSignatureError(err) {
+		t.Error("expected tampered key id to fail. Got:", err)
+	}
+
+	if err := VerifyRequest(signed("client-1", master), WithHmacSha256Derived(master, nil)); !IsInvalidSignatureError(err) {
+		t.Error("expected the master key to fail. Got:", err)
+	}
+
+	failing := func([]byte, string) ([]byte, error) { return nil, errors.New("no such client") }
+	if err := VerifyRequest(signed("client-1", derived("client-1")), WithHmacSha256Derived(master, failing)); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key error. Got:", err)
+	}
+}
+
+func TestAutoRetrySignTransport(t *testing.T) {
+	var calls int
+	var inputs, bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		inputs = append(inputs, r.Header.Get("Signature-Input"))
+
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		if calls == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+
+	tcs := []struct {
+		method  string
+		opts    []SigningOption
+		retried bool
+	}{
+		{method: "GET", retried: true},
+		{method: "PUT", retried: true},
+		{method: "DELETE", retried: true},
+		{method: "POST", retried: false},
+		{method: "POST", opts: []SigningOption{WithRetryOnPost()}, retried: true},
+		{method: "PATCH", opts: []SigningOption{WithRetryOnPost()}, retried: false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.method, func(t *testing.T) {
+			calls, inputs, bodies = 0, nil, nil
+
+			now, nonce := time.Unix(1618884475, 0), 0
+			opts := append([]SigningOption{
+				WithHmacSha256("test-key", []byte(testSecret)),
+				WithCreated(),
+				WithNonce(func() string { nonce++; return fmt.Sprint("nonce-", nonce) }),
+				withNowFunc(func() time.Time { now = now.Add(time.Second); return now }),
+			}, tc.opts...)
+			c := &http.Client{Transport: NewAutoRetrySignTransport(http.DefaultTransport, opts...)}
+
+			req, err := http.NewRequest(tc.method, s.URL, strings.NewReader("some body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal("request failed:", err)
+			}
+			resp.Body.Close()
+
+			want, status := 1, http.StatusUnauthorized
+			if tc.retried {
+				want, status = 2, http.StatusOK
+			}
+
+			if calls != want || resp.StatusCode != status {
+				t.Fatalf("expected %d calls, ending with %d. Got: %d calls, ending with %d", want, status, calls, resp.StatusCode)
+			}
+
+			if !tc.retried {
+				return
+			}
+
+			if inputs[0] == inputs[1] || !strings.Contains(inputs[1], "nonce-2") {
+				t.Errorf("expected a fresh signature on retry. Got: %q", inputs)
+			}
+
+			if bodies[1] != "some body" {
+				t.Errorf("unexpected retried body: %q", bodies[1])
+			}
+		})
+	}
+}
+
+func TestHeaderSanitization(t *testing.T) {
+	key := WithHmacSha256("test-key", []byte(testSecret))
+	components := WithSigningComponents("@method", "x-note")
+
+	newReq := func(note string) *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("X-Note", note)
+		return req
+	}
+
+	err := SignRequest(newReq("one\r\n\"@method\": POST"), key, components)
+	var herr *HeaderValueError
+	if !errors.As(err, &herr) || herr.Header != "x-note" {
+		t.Error("expected a header value error for a newline. Got:", err)
+	}
+
+	// Unsigned headers aren't checked.
+	req := newReq("ok")
+	req.Header.Set("X-Other", "one\ntwo")
+	if err := SignRequest(req, key, components); err != nil {
+		t.Error("expected unsigned header to be ignored. Got:", err)
+	}
+
+	if err := SignRequest(newReq("one\ttwo"), key, components); err != nil {
+		t.Error("expected tab to be allowed by default. Got:", err)
+	}
+
+	if err := SignRequest(newReq("one\ttwo"), key, components, WithHeaderSanitization(StrictHeaderSanitizer)); !IsHeaderValueError(err) {
+		t.Error("expected strict sanitizer to reject tab. Got:", err)
+	}
+
+	// A sanitizer can normalize values, so both sides must use it.
+	collapse := WithHeaderSanitization(func(name, value string) (string, error) {
+		return strings.Join(strings.Fields(value), " "), nil
+	})
+
+	req = newReq("one   two")
+	if err := SignRequest(req, key, components, collapse); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if err := VerifyRequest(req, key, collapse); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if err := VerifyRequest(req, key); !IsInvalidSignatureError(err) {
+		t.Error("expected verification without the sanitizer to fail. Got:", err)
+	}
+
+	req.Header.Set("X-Note", "one\ntwo")
+	if err := VerifyRequest(req, key); !IsHeaderValueError(err) {
+		t.Error("expected verification to reject a newline. Got:", err)
+	}
+}
+
+func TestSignTransport_HTTP2(t *testing.T) {
+	var proto int
+	var verifyErr error
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.ProtoMajor
+		verifyErr = VerifyRequest(r, WithHmacSha256("test-key", []byte(testSecret)), WithRequiredComponents("@method", "@path", "@authority", "@scheme"))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Transport = NewSignTransport(c.Transport,
+		WithHmacSha256("test-key", []byte(testSecret)),
+		WithSigningComponents("@method", "@path", "@query", "@authority", "@scheme", "host"),
+	)
+
+	resp, err := c.Get(srv.URL + "/foo?bar=baz")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	if proto != 2 {
+		t.Fatalf("expected an HTTP/2 request. Got: HTTP/%d", proto)
+	}
+
+	if verifyErr != nil {
+		t.Error("verification failed:", verifyErr)
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/foo", nil)
+	req.ProtoMajor = 2
+	req.Header.Set("Signature-Input", `sig1=("@request-target");keyid="test-key"`)
+	req.Header.Set("Signature", `sig1=:c2ln:`)
+
+	if err := VerifyRequest(req, WithHmacSha256("test-key", []byte(testSecret))); !errors.Is(err, errRequestTargetHTTP2) {
+		t.Error("expected @request-target to be rejected. Got:", err)
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	u, _ := url.Parse("https://example.com/foo?bar=baz")
+	msg := &Message{
+		Method:    "POST",
+		Authority: "example.com",
+		URL:       u,
+		Header:    http.Header{"Content-Type": {"application/json"}},
+	}
+
+	hdr, err := SignMessage(msg, WithHmacSha256("test-key", []byte(testSecret)))
+	if err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if got := hdr.Get("Signature-Input"); got != `sig1=("@method" "@path" "@query" "content-type");keyid="test-key"` {
+		t.Error("unexpected signature input:", got)
+	}
+
+	for k, v := range hdr {
+		msg.Header[k] = v
+	}
+
+	if res, err := VerifyMessage(msg, WithHmacSha256("test-key", []byte(testSecret))); err != nil || res.KeyID != "test-key" {
+		t.Errorf("unexpected verification result %+v: %v", res, err)
+	}
+
+	// The constructors give the same messages as are signed and verified for requests.
+	req := httptest.NewRequest("POST", "https://example.com/foo?bar=baz", nil)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+
+	if _, err := VerifyMessage(NewRequestMessage(req), WithHmacSha256("test-key", []byte(testSecret))); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	if msg := NewResponseMessage(resp); msg.StatusCode != http.StatusOK || msg.Request == nil || msg.Request.Method != "POST" {
+		t.Errorf("unexpected response message: %+v", msg)
+	}
+}
+
+func TestCustomDerivedComponent(t *testing.T) {
+	tenant := WithCustomDerivedComponent("@x-tenant-id", func(msg *Message) (string, error) {
+		return strings.ToLower(msg.Header.Get("X-Tenant-ID")), nil
+	})
+	key := WithHmacSha256("key1", []byte(testSecret))
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.Header.Set("X-Tenant-ID", "Acme")
+
+	if err := SignRequest(req, key, tenant, WithSigningComponents("@method", "@x-tenant-id")); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if got := req.Header.Get("Signature-Input"); got != `sig1=("@method" "@x-tenant-id");keyid="key1"` {
+		t.Error("unexpected signature input:", got)
+	}
+
+	if err := VerifyRequest(req, key, tenant); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if err := VerifyRequest(req, key); err == nil {
+		t.Error("expected verification without the component to fail")
+	}
+
+	req.Header.Set("X-Tenant-ID", "Other")
+	if err := VerifyRequest(req, key, tenant); err == nil {
+		t.Error("expected verification of an altered tenant to fail")
+	}
+
+	// Components can depend on the request's context.
+	type routeKey struct{}
+	route := WithCustomDerivedComponent("@x-route", func(msg *Message) (string, error) {
+		if r, ok := msg.Context.Value(routeKey{}).(string); ok {
+			return r, nil
+		}
+		return "", errors.New("no route")
+	})
+
+	req = httptest.NewRequest("GET", "https://example.com/users/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), routeKey{}, "/users/{id}"))
+	if err := SignRequest(req, key, route, WithSigningComponents("@path", "@x-route")); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if err := VerifyRequest(req, key, route); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if err := VerifyRequest(req.WithContext(context.WithValue(req.Context(), routeKey{}, "/users/{name}")), key, route); err == nil {
+		t.Error("expected verification of another route to fail")
+	}
+
+	// Unregistered and invalid components can't be signed.
+	for _, opts := range [][]SigningOption{
+		{key, WithSigningComponents("@x-tenant-id")},
+		{key, WithCustomDerivedComponent("x-tenant-id", nil), WithSigningComponents("@method")},
+		{key, WithCustomDerivedComponent("@method", func(*Message) (string, error) { return "", nil })},
+	} {
+		if err := SignRequest(httptest.NewRequest("GET", "https://example.com/", nil), opts...); err == nil {
+			t.Error("expected signing to fail")
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	secret := []byte(testSecret)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := http.NewServeMux()
+	mux.Handle("/private", Handler(ok, WithHmacSha256("key1", secret)))
+	mux.Handle("/public", ok)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	signed := &http.Client{Transport: NewSignTransport(http.DefaultTransport, WithHmacSha256("key1", secret))}
+
+	tcs := []struct {
+		name   string
+		client *http.Client
+		path   string
+		want   int
+	}{
+		{"unsigned private", http.DefaultClient, "/private", http.StatusUnauthorized},
+		{"unsigned public", http.DefaultClient, "/public", http.StatusOK},
+		{"signed private", signed, "/private", http.StatusOK},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := tc.client.Get(srv.URL + tc.path)
+			if err != nil {
+				t.Fatal("request failed:", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.want {
+				t.Errorf("unexpected status %d, want %d", resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestSignTransport_SignAllHeaders(t *testing.T) {
+	secret := []byte(testSecret)
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewSignTransport(ct, WithHmacSha256("key1", secret), WithSignAllHeaders())}
+
+	// Headers are only known once the request is made.
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader("hello"))
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("Connection", "keep-alive, X-Hop")
+	req.Header.Set("X-Hop", "1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	want := `sig1=("@method" "@path" "@authority" "digest" "x-tenant");keyid="key1"`
+	if got := ct.req.Header.Get("Signature-Input"); got != want {
+		t.Errorf("unexpected signature input.\nExpected: %s\nGot:      %s", want, got)
+	}
+
+	vreq := httptest.NewRequest("POST", "http://example.com/foo", bytes.NewReader(ct.body))
+	vreq.Header = ct.req.Header
+	if err := VerifyRequest(vreq, WithHmacSha256("key1", secret)); err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestVerifyMiddlewareWithFallback(t *testing.T) {
+	secret := []byte(testSecret)
+	enforce := time.Unix(1618884475, 0)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	tcs := []struct {
+		name   string
+		now    time.Time
+		called bool
+		status int
+	}{
+		{"observing", enforce.Add(-time.Second), true, http.StatusOK},
+		{"enforcing", enforce, false, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+
+			now := tc.now
+			var called bool
+			h := NewVerifyMiddlewareWithFallback(logger, WithHmacSha256("key1", secret), WithEnforceAfter(enforce),
+				withNowFunc(func() time.Time { return now })).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if err := SignRequest(req, WithHmacSha256("key1", []byte("wrong"))); err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if called != tc.called || rec.Code != tc.status {
+				t.Errorf("unexpected result: called %t, status %d", called, rec.Code)
+			}
+
+			for _, want := range []string{"level=WARN", "key_id=key1", "error=\"invalid signature\""} {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected %q in log. Got: %s", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestWrapHandler(t *testing.T) {
+	reqSecret, respSecret := []byte(testSecret), []byte("response secret")
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello")
+	})
+
+	srv := httptest.NewServer(WrapHandler(h,
+		[]SigningOption{WithHmacSha256("server", respSecret)},
+		[]VerifyOption{WithHmacSha256("client", reqSecret)},
+	))
+	defer srv.Close()
+
+	verify := NewVerifyResponseTransport(http.DefaultTransport, WithHmacSha256("server", respSecret))
+
+	tcs := []struct {
+		name   string
+		client http.RoundTripper
+		want   int
+	}{
+		{"signed", NewSignTransport(verify, WithHmacSha256("client", reqSecret)), http.StatusOK},
+		{"unsigned", verify, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal("could not create request:", err)
+			}
+
+			resp, err := tc.client.RoundTrip(req)
+			if err != nil {
+				t.Fatal("round trip failed:", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.want {
+				t.Errorf("expected status %d. Got: %d", tc.want, resp.StatusCode)
+			}
+		})
+	}
+
+	// Without signing options, responses aren't signed.
+	unsigned := httptest.NewServer(WrapHandler(h, nil, []VerifyOption{WithHmacSha256("client", reqSecret)}))
+	defer unsigned.Close()
+
+	req, _ := http.NewRequest("GET", unsigned.URL, nil)
+	if _, err := NewSignTransport(verify, WithHmacSha256("client", reqSecret)).RoundTrip(req); !IsNotSignedError(err) {
+		t.Error("expected an unsigned response. Got:", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httpsigtest provides helpers for testing code that sends signed http requests. It is
+// kept apart from package httpsig so programs importing that don't also import testing.
+package httpsigtest
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghoti143/httpsig"
+	"github.com/ghoti143/httpsig/internal/hooks"
+)
+
+// TestSigner signs requests deterministically, for tests that assert exact header values. It
+// signs with `hmac-sha256` unless given another key with SetKey, and a fixed creation time.
+// Only deterministic algorithms can be compared this way: `rsa-pss-sha512` and the ECDSA
+// algorithms produce a different signature each time.
+type TestSigner struct {
+	key      httpsig.SigningOption
+	fixedNow time.Time
+	nonce    string
+	opts     []httpsig.SigningOption
+}
+
+// NewTestSigner returns a TestSigner using the shared secret for keyID, with every signature
+// created at fixedNow.
+func NewTestSigner(keyID string, secret []byte, fixedNow time.Time) *TestSigner {
+	return &TestSigner{
+		key:      httpsig.WithHmacSha256(keyID, secret),
+		fixedNow: fixedNow,
+	}
+}
+
+// SetKey replaces the signing key with the one configured by key, eg
+// `httpsig.WithSignRsaPkcs1Sha256("key1", pk)`.
+func (ts *TestSigner) SetKey(key httpsig.SigningOption) *TestSigner {
+	ts.key = key
+	return ts
+}
+
+// SetNonce sets a fixed nonce on every signature.
+func (ts *TestSigner) SetNonce(nonce string) *TestSigner {
+	ts.nonce = nonce
+	return ts
+}
+
+// SetOptions sets further options used when signing, eg WithSigningComponents.
+func (ts *TestSigner) SetOptions(opts ...httpsig.SigningOption) *TestSigner {
+	ts.opts = opts
+	return ts
+}
+
+func (ts *TestSigner) options() []httpsig.SigningOption {
+	opts := []httpsig.SigningOption{ts.key, httpsig.WithCreated()}
+
+	if ts.nonce != "" {
+		nonce := ts.nonce
+		opts = append(opts, httpsig.WithNonce(func() string { return nonce }))
+	}
+
+	now := ts.fixedNow
+	opts = append(opts, ts.opts...)
+	return append(opts, hooks.WithNowFunc(func() time.Time { return now }).(httpsig.SigningOption))
+}
+
+// Sign signs req in place, as with SignRequest.
+func (ts *TestSigner) Sign(req *http.Request) error {
+	return httpsig.SignRequest(req, ts.options()...)
+}
+
+// Transport returns a transport wrapping transport that signs requests, as with
+// NewSignTransport.
+func (ts *TestSigner) Transport(transport http.RoundTripper) http.RoundTripper {
+	return httpsig.NewSignTransport(transport, ts.options()...)
+}
+
+// goldenHeaders are the headers compared by Golden, in order.
+var goldenHeaders = []string{"Digest", "Content-Digest", "Signature-Input", "Signature"}
+
+// Golden signs req, then compares its signature headers to the golden file at path, failing t
+// if they differ. Set HTTPSIG_UPDATE_GOLDEN=1 to write the headers to the file instead. t may
+// be a benchmark.
+func (ts *TestSigner) Golden(t testing.TB, req *http.Request, path string) {
+	t.Helper()
+
+	if err := ts.Sign(req); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	var b strings.Builder
+	for _, h := range goldenHeaders {
+		for _, v := range req.Header.Values(h) {
+			fmt.Fprintf(&b, "%s: %s\n", h, v)
+		}
+	}
+	got := b.String()
+
+	if os.Getenv("HTTPSIG_UPDATE_GOLDEN") == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal("could not create golden file directory:", err)
+		}
+
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal("could not write golden file:", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("could not read golden file:", err)
+	}
+
+	if got != string(want) {
+		t.Errorf("signature headers differ from %s.\nExpected:\n%s\nGot:\n%s", path, want, got)
+	}
+}
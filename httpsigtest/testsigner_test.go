@@ -0,0 +1,122 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsigtest
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghoti143/httpsig"
+)
+
+const testSecret = "support-your-local-cat-bonnet-store"
+
+func goldenReq(t testing.TB) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", "https://example.com/foo?param=value&pet=dog", strings.NewReader(`{"hello": "world"}`))
+	if err != nil {
+		t.Fatal("could not create request:", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+
+	return req
+}
+
+// The golden files are regression anchors for the deterministic algorithms, HMAC and RSA
+// PKCS#1 v1.5. RSA-PSS and ECDSA signatures are randomized, so can't have golden files.
+// Regenerate them with HTTPSIG_UPDATE_GOLDEN=1 go test -run TestTestSigner_Golden
+func TestTestSigner_Golden(t *testing.T) {
+	now := time.Unix(1618884475, 0)
+	secret := []byte(testSecret)
+
+	pem, err := os.ReadFile(filepath.Join("..", "testdata", "rsa-pkcs8.pem"))
+	if err != nil {
+		t.Fatal("could not read fixture:", err)
+	}
+
+	rsa, err := httpsig.ParseRSAPrivateKeyPEM(pem)
+	if err != nil {
+		t.Fatal("could not parse key:", err)
+	}
+
+	tcs := map[string]httpsig.SigningOption{
+		"hmac-sha256":      httpsig.WithHmacSha256("test-key", secret),
+		"hmac-sha384":      httpsig.WithHmacSha384("test-key", secret),
+		"hmac-sha512":      httpsig.WithHmacSha512("test-key", secret),
+		"rsa-pkcs1-sha256": httpsig.WithSignRsaPkcs1Sha256("test-key", rsa),
+		"rsa-pkcs1-sha512": httpsig.WithSignRsaPkcs1Sha512("test-key", rsa),
+	}
+
+	for alg, key := range tcs {
+		t.Run(alg, func(t *testing.T) {
+			ts := NewTestSigner("test-key", nil, now).SetKey(key).SetNonce("a-fixed-nonce")
+
+			ts.SetOptions(httpsig.WithSigningComponents("@method", "@authority", "@path", "@query", "content-type", "date"), httpsig.WithBodyDigest())
+			ts.Golden(t, goldenReq(t), filepath.Join("testdata", "golden", alg+".golden"))
+		})
+	}
+}
+
+func BenchmarkTestSigner_Golden(b *testing.B) {
+	ts := NewTestSigner("test-key", []byte(testSecret), time.Unix(1618884475, 0)).SetNonce("a-fixed-nonce")
+	ts.SetOptions(httpsig.WithSigningComponents("@method", "@authority", "@path", "@query", "content-type", "date"), httpsig.WithBodyDigest())
+
+	for i := 0; i < b.N; i++ {
+		ts.Golden(b, goldenReq(b), filepath.Join("testdata", "golden", "hmac-sha256.golden"))
+	}
+}
+
+// captureTransport records the request it is sent.
+type captureTransport struct {
+	req *http.Request
+}
+
+func (c *captureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.req = r
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+func TestTestSigner(t *testing.T) {
+	now := time.Unix(1618884475, 0)
+	ts := NewTestSigner("test-key", []byte(testSecret), now)
+
+	first, second := goldenReq(t), goldenReq(t)
+	for _, req := range []*http.Request{first, second} {
+		if err := ts.Sign(req); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+	}
+
+	if first.Header.Get("Signature") != second.Header.Get("Signature") {
+		t.Error("signatures differ between runs")
+	}
+
+	if got := first.Header.Get("Signature-Input"); !strings.HasSuffix(got, `;created=1618884475;keyid="test-key"`) {
+		t.Error("unexpected signature input. Got:", got)
+	}
+
+	if err := httpsig.VerifyRequest(first, httpsig.WithHmacSha256("test-key", []byte(testSecret))); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	ct := &captureTransport{}
+	client := http.Client{Transport: ts.Transport(ct)}
+
+	resp, err := client.Do(goldenReq(t))
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	if ct.req.Header.Get("Signature") != first.Header.Get("Signature") {
+		t.Error("transport signature differs from Sign")
+	}
+}
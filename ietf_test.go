@@ -0,0 +1,169 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ietfVectors are the examples from appendix B of the draft standard, in
+// testdata/ietf-vectors.json. Vectors we don't pass yet have a skip reason linking the issue
+// that tracks the gap; fixing one is a compliance fix, so drop its skip along with it.
+type ietfVectors struct {
+	Message struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers http.Header `json:"headers"`
+	} `json:"message"`
+
+	Keys map[string]struct {
+		Alg    string `json:"alg"`
+		PEM    string `json:"pem"`
+		Secret string `json:"secret"`
+	} `json:"keys"`
+
+	Vectors []struct {
+		Name           string `json:"name"`
+		KeyID          string `json:"key_id"`
+		SignatureInput string `json:"signature_input"`
+		Signature      string `json:"signature"`
+		Sign           bool   `json:"sign"`
+		Valid          bool   `json:"valid"`
+		Skip           string `json:"skip"`
+	} `json:"vectors"`
+}
+
+func readIETFVectors(t *testing.T) *ietfVectors {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/ietf-vectors.json")
+	if err != nil {
+		t.Fatal("could not read vectors:", err)
+	}
+
+	var vs ietfVectors
+	if err := json.Unmarshal(b, &vs); err != nil {
+		t.Fatal("could not decode vectors:", err)
+	}
+
+	return &vs
+}
+
+// ietfKeys returns the signing and verification keys of vs, by key id. Only shared secrets can
+// sign, as the draft's asymmetric signatures aren't reproducible.
+func ietfKeys(t *testing.T, vs *ietfVectors) (map[string]sigHolder, map[string]verHolder) {
+	t.Helper()
+
+	sks, vks := make(map[string]sigHolder), make(map[string]verHolder)
+	for keyID, k := range vs.Keys {
+		switch k.Alg {
+		case "rsa-pss-sha512":
+			pk, err := ParseRSAPublicKeyPEM([]byte(k.PEM))
+			if err != nil {
+				t.Fatalf("could not parse key %q: %s", keyID, err)
+			}
+			vks[keyID] = verifyRsaPssSha512(pk)
+		case "ecdsa-p256-sha256":
+			pk, err := ParseECPublicKeyPEM([]byte(k.PEM))
+			if err != nil {
+				t.Fatalf("could not parse key %q: %s", keyID, err)
+			}
+			vks[keyID] = verifyEccP256(pk)
+		case "hmac-sha256":
+			secret, err := base64.StdEncoding.DecodeString(k.Secret)
+			if err != nil {
+				t.Fatalf("could not decode key %q: %s", keyID, err)
+			}
+			sks[keyID], vks[keyID] = signHmacSha256(secret), verifyHmacSha256(secret)
+		default:
+			t.Fatalf("unsupported algorithm %q for key %q", k.Alg, keyID)
+		}
+	}
+
+	return sks, vks
+}
+
+func TestIETFVectors(t *testing.T) {
+	vs := readIETFVectors(t)
+	sks, vks := ietfKeys(t, vs)
+
+	message := func() *Message {
+		u := parse(vs.Message.URL)
+		return &Message{
+			Method:    vs.Message.Method,
+			Authority: u.Host,
+			URL:       u,
+			Header:    vs.Message.Headers.Clone(),
+		}
+	}
+
+	for _, tc := range vs.Vectors {
+		t.Run(tc.Name, func(t *testing.T) {
+			if tc.Skip != "" {
+				if !strings.Contains(tc.Skip, "https://github.com/ghoti143/httpsig/issues/") {
+					t.Fatal("skip reason must link a tracking issue:", tc.Skip)
+				}
+
+				t.Skip(tc.Skip)
+			}
+
+			label, input, _ := strings.Cut(tc.SignatureInput, "=")
+			sp, err := ParseSignatureInput(input)
+			if err != nil {
+				t.Fatal("could not parse signature input:", err)
+			}
+
+			now := func() time.Time { return *sp.Created }
+
+			v := &verifier{
+				keys:    map[string]verHolder{tc.KeyID: vks[tc.KeyID]},
+				nowFunc: now,
+			}
+
+			msg := message()
+			msg.Header.Set("Signature-Input", tc.SignatureInput)
+			msg.Header.Set("Signature", tc.Signature)
+
+			_, err = v.Verify(msg)
+			switch {
+			case tc.Valid && err != nil:
+				t.Error("verification failed:", err)
+			case !tc.Valid && !IsInvalidSignatureError(err):
+				t.Error("expected invalid signature. Got:", err)
+			}
+
+			if !tc.Sign {
+				return
+			}
+
+			s := &signer{
+				headers: sp.Items,
+				keys:    map[string]sigHolder{tc.KeyID: sks[tc.KeyID]},
+				label:   label,
+				created: true,
+				nowFunc: now,
+			}
+
+			hdr, err := s.Sign(message())
+			if err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			if got := hdr.Get("Signature-Input"); got != tc.SignatureInput {
+				t.Error("signature input did not match. Got:", got)
+			}
+
+			if got := hdr.Get("Signature"); got != tc.Signature {
+				t.Error("signature did not match. Got:", got)
+			}
+		})
+	}
+}
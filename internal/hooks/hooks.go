@@ -0,0 +1,13 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hooks gives the other packages of this module access to internals of package
+// httpsig, without adding them to its API.
+package hooks
+
+import "time"
+
+// WithNowFunc returns an httpsig.SignOrVerifyOption replacing the clock used for signature
+// times. It is set by package httpsig.
+var WithNowFunc func(fn func() time.Time) interface{}
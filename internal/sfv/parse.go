@@ -0,0 +1,422 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sfv
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errParse = errors.New("malformed structured field value")
+
+// ParseList parses in as an sf-list, as in section 4.2.1.
+func ParseList(in string) (List, error) {
+	p := &parser{in: in}
+	p.skipSP()
+
+	var l List
+	for !p.done() {
+		m, err := p.member()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, m)
+
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// ParseDictionary parses in as an sf-dictionary, as in section 4.2.2. Members with a repeated
+// key keep the position of the first, and the value of the last.
+func ParseDictionary(in string) (Dictionary, error) {
+	p := &parser{in: in}
+	p.skipSP()
+
+	var d Dictionary
+	for !p.done() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		var m Member
+		if p.peek() == '=' {
+			p.i++
+			if m, err = p.member(); err != nil {
+				return nil, err
+			}
+		} else {
+			params, err := p.params()
+			if err != nil {
+				return nil, err
+			}
+			m = Item{Value: true, Params: params}
+		}
+
+		d = d.set(key, m)
+
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (d Dictionary) set(key string, m Member) Dictionary {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Value = m
+			return d
+		}
+	}
+
+	return append(d, DictMember{Key: key, Value: m})
+}
+
+// ParseInnerList parses in as an inner list, as in section 4.2.1.2.
+func ParseInnerList(in string) (InnerList, error) {
+	p := &parser{in: in}
+	p.skipSP()
+
+	il, err := p.innerList()
+	if err != nil {
+		return InnerList{}, err
+	}
+
+	p.skipSP()
+	if !p.done() {
+		return InnerList{}, p.errorf("unexpected trailing characters")
+	}
+
+	return il, nil
+}
+
+// ParseItem parses in as an sf-item, as in section 4.2.3.
+func ParseItem(in string) (Item, error) {
+	p := &parser{in: in}
+	p.skipSP()
+
+	it, err := p.item()
+	if err != nil {
+		return Item{}, err
+	}
+
+	p.skipSP()
+	if !p.done() {
+		return Item{}, p.errorf("unexpected trailing characters")
+	}
+
+	return it, nil
+}
+
+type parser struct {
+	in string
+	i  int
+}
+
+func (p *parser) done() bool { return p.i >= len(p.in) }
+
+// peek returns the next character, or 0 at the end of the input.
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+
+	return p.in[p.i]
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at offset %d", errParse, fmt.Sprintf(format, args...), p.i)
+}
+
+func (p *parser) skipSP() {
+	for p.peek() == ' ' {
+		p.i++
+	}
+}
+
+func (p *parser) skipOWS() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.i++
+	}
+}
+
+// next consumes the separator after a list or dictionary member. A trailing separator fails.
+func (p *parser) next() error {
+	p.skipOWS()
+	if p.done() {
+		return nil
+	}
+
+	if p.peek() != ',' {
+		return p.errorf("expected ','")
+	}
+	p.i++
+
+	p.skipOWS()
+	if p.done() {
+		return p.errorf("trailing ','")
+	}
+
+	return nil
+}
+
+func (p *parser) member() (Member, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+
+	return p.item()
+}
+
+func (p *parser) innerList() (InnerList, error) {
+	if p.peek() != '(' {
+		return InnerList{}, p.errorf("expected '('")
+	}
+	p.i++
+
+	var il InnerList
+	for !p.done() {
+		p.skipSP()
+
+		if p.peek() == ')' {
+			p.i++
+
+			params, err := p.params()
+			if err != nil {
+				return InnerList{}, err
+			}
+			il.Params = params
+
+			return il, nil
+		}
+
+		it, err := p.item()
+		if err != nil {
+			return InnerList{}, err
+		}
+		il.Items = append(il.Items, it)
+
+		if c := p.peek(); c != ' ' && c != ')' {
+			return InnerList{}, p.errorf("expected ' ' or ')'")
+		}
+	}
+
+	return InnerList{}, p.errorf("unterminated inner list")
+}
+
+func (p *parser) item() (Item, error) {
+	v, err := p.bareItem()
+	if err != nil {
+		return Item{}, err
+	}
+
+	params, err := p.params()
+	if err != nil {
+		return Item{}, err
+	}
+
+	return Item{Value: v, Params: params}, nil
+}
+
+func (p *parser) params() (Params, error) {
+	// Section 4.2.3.2 covers parameters.
+	var params Params
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		var v interface{} = true
+		if p.peek() == '=' {
+			p.i++
+			if v, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+
+		params = params.set(key, v)
+	}
+
+	return params, nil
+}
+
+func (params Params) set(key string, v interface{}) Params {
+	for i := range params {
+		if params[i].Key == key {
+			params[i].Value = v
+			return params
+		}
+	}
+
+	return append(params, Param{Key: key, Value: v})
+}
+
+func (p *parser) key() (string, error) {
+	// Section 4.2.3.3 covers keys.
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.errorf("expected key")
+	}
+
+	start := p.i
+	for c := p.peek(); isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'; c = p.peek() {
+		p.i++
+	}
+
+	return p.in[start:p.i], nil
+}
+
+func (p *parser) bareItem() (interface{}, error) {
+	// Section 4.2.3.1 covers bare items.
+	switch c := p.peek(); {
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || isAlpha(c):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	default:
+		return nil, p.errorf("unexpected character %q", c)
+	}
+}
+
+func (p *parser) number() (interface{}, error) {
+	// Section 4.2.4 covers integers and decimals.
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+
+	if !isDigit(p.peek()) {
+		return nil, p.errorf("expected digit")
+	}
+
+	// n counts the characters of the number, less the sign.
+	n, dot := 0, -1
+	for c := p.peek(); isDigit(c) || (c == '.' && dot < 0); c = p.peek() {
+		if c == '.' {
+			if n > 12 {
+				return nil, p.errorf("decimal integer part too long")
+			}
+			dot = n
+		}
+		p.i++
+		n++
+
+		if (dot < 0 && n > 15) || n > 16 {
+			return nil, p.errorf("number too long")
+		}
+	}
+
+	num := p.in[start:p.i]
+	if dot < 0 {
+		i, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, p.errorf("bad integer %q", num)
+		}
+		return i, nil
+	}
+
+	if frac := n - dot - 1; frac < 1 || frac > 3 {
+		return nil, p.errorf("bad decimal %q", num)
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil, p.errorf("bad decimal %q", num)
+	}
+
+	return f, nil
+}
+
+func (p *parser) string() (string, error) {
+	// Section 4.2.5 covers strings.
+	p.i++
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.in[p.i]
+		p.i++
+
+		switch {
+		case c == '\\':
+			if n := p.peek(); n != '"' && n != '\\' {
+				return "", p.errorf("bad escape")
+			}
+			b.WriteByte(p.in[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("bad string character %q", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) token() Token {
+	// Section 4.2.6 covers tokens.
+	start := p.i
+	p.i++
+	for c := p.peek(); c != 0 && (isTChar(c) || c == ':' || c == '/'); c = p.peek() {
+		p.i++
+	}
+
+	return Token(p.in[start:p.i])
+}
+
+func (p *parser) byteSequence() ([]byte, error) {
+	// Section 4.2.7 covers byte sequences.
+	p.i++
+
+	end := strings.IndexByte(p.in[p.i:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+
+	enc := p.in[p.i : p.i+end]
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, p.errorf("bad byte sequence")
+	}
+	p.i += end + 1
+
+	return b, nil
+}
+
+func (p *parser) boolean() (bool, error) {
+	// Section 4.2.8 covers booleans.
+	p.i++
+
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	default:
+		return false, p.errorf("bad boolean")
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sfv
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errInvalidValue = errors.New("invalid structured field value")
+
+// SerializeList returns l serialized as an sf-list, as in section 4.1.1.
+func SerializeList(l List) (string, error) {
+	var b strings.Builder
+	for i, m := range l {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		if err := serializeMember(&b, m); err != nil {
+			return "", err
+		}
+	}
+
+	return b.String(), nil
+}
+
+// SerializeDictionary returns d serialized as an sf-dictionary, as in section 4.1.2. Members
+// that are an Item with a true value are serialized as just their key and parameters.
+func SerializeDictionary(d Dictionary) (string, error) {
+	var b strings.Builder
+	for i, m := range d {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		if err := serializeKey(&b, m.Key); err != nil {
+			return "", err
+		}
+
+		if it, ok := m.Value.(Item); ok && it.Value == true {
+			if err := serializeParams(&b, it.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		b.WriteByte('=')
+		if err := serializeMember(&b, m.Value); err != nil {
+			return "", err
+		}
+	}
+
+	return b.String(), nil
+}
+
+// SerializeInnerList returns il serialized as an inner list, as in section 4.1.1.1.
+func SerializeInnerList(il InnerList) (string, error) {
+	var b strings.Builder
+	if err := serializeInnerList(&b, il); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// SerializeItem returns it serialized as an sf-item, as in section 4.1.3.
+func SerializeItem(it Item) (string, error) {
+	var b strings.Builder
+	if err := serializeItem(&b, it); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func serializeMember(b *strings.Builder, m Member) error {
+	switch v := m.(type) {
+	case Item:
+		return serializeItem(b, v)
+	case InnerList:
+		return serializeInnerList(b, v)
+	default:
+		return fmt.Errorf("%w: member of type %T", errInvalidValue, m)
+	}
+}
+
+func serializeInnerList(b *strings.Builder, il InnerList) error {
+	b.WriteByte('(')
+	for i, it := range il.Items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+
+		if err := serializeItem(b, it); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+
+	return serializeParams(b, il.Params)
+}
+
+func serializeItem(b *strings.Builder, it Item) error {
+	if err := serializeBareItem(b, it.Value); err != nil {
+		return err
+	}
+
+	return serializeParams(b, it.Params)
+}
+
+func serializeParams(b *strings.Builder, p Params) error {
+	// Section 4.1.1.2 covers parameters.
+	for _, pp := range p {
+		b.WriteByte(';')
+		if err := serializeKey(b, pp.Key); err != nil {
+			return err
+		}
+
+		if pp.Value == true {
+			continue
+		}
+
+		b.WriteByte('=')
+		if err := serializeBareItem(b, pp.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func serializeKey(b *strings.Builder, key string) error {
+	// Section 4.1.1.3 covers keys.
+	if !isKey(key) {
+		return fmt.Errorf("%w: key %q", errInvalidValue, key)
+	}
+
+	b.WriteString(key)
+	return nil
+}
+
+func isKey(key string) bool {
+	if key == "" || !(isLCAlpha(key[0]) || key[0] == '*') {
+		return false
+	}
+
+	for i := 1; i < len(key); i++ {
+		c := key[i]
+		if !isLCAlpha(c) && !isDigit(c) && c != '_' && c != '-' && c != '.' && c != '*' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func serializeBareItem(b *strings.Builder, v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		return serializeInteger(b, v)
+	case int:
+		return serializeInteger(b, int64(v))
+	case float64:
+		return serializeDecimal(b, v)
+	case string:
+		return serializeString(b, v)
+	case Token:
+		return serializeToken(b, v)
+	case []byte:
+		// Section 4.1.8 covers byte sequences.
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+		return nil
+	case bool:
+		// Section 4.1.9 covers booleans.
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: bare item of type %T", errInvalidValue, v)
+	}
+}
+
+const maxInteger = 999999999999999
+
+func serializeInteger(b *strings.Builder, v int64) error {
+	// Section 4.1.4 covers integers.
+	if v > maxInteger || v < -maxInteger {
+		return fmt.Errorf("%w: integer %d out of range", errInvalidValue, v)
+	}
+
+	b.WriteString(strconv.FormatInt(v, 10))
+	return nil
+}
+
+func serializeDecimal(b *strings.Builder, v float64) error {
+	// Section 4.1.5 covers decimals. They are rounded to three fractional digits, with ties
+	// to even, and can have at most twelve integer digits.
+	r := math.RoundToEven(v * 1000)
+	if math.IsNaN(r) || math.Abs(r) >= 1e15 {
+		return fmt.Errorf("%w: decimal %v out of range", errInvalidValue, v)
+	}
+
+	n := int64(r)
+	if n < 0 {
+		b.WriteByte('-')
+		n = -n
+	}
+
+	frac := strings.TrimRight(fmt.Sprintf("%03d", n%1000), "0")
+	if frac == "" {
+		frac = "0"
+	}
+
+	fmt.Fprintf(b, "%d.%s", n/1000, frac)
+	return nil
+}
+
+func serializeString(b *strings.Builder, v string) error {
+	// Section 4.1.6 covers strings. Only printable ASCII is allowed.
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("%w: string %q", errInvalidValue, v)
+		}
+
+		if c == '\\' || c == '"' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+
+	return nil
+}
+
+func serializeToken(b *strings.Builder, v Token) error {
+	// Section 4.1.7 covers tokens.
+	if v == "" || !(isAlpha(v[0]) || v[0] == '*') {
+		return fmt.Errorf("%w: token %q", errInvalidValue, v)
+	}
+
+	for i := 1; i < len(v); i++ {
+		if c := v[i]; !isTChar(c) && c != ':' && c != '/' {
+			return fmt.Errorf("%w: token %q", errInvalidValue, v)
+		}
+	}
+
+	b.WriteString(string(v))
+	return nil
+}
+
+func isLCAlpha(c byte) bool { return c >= 'a' && c <= 'z' }
+
+func isAlpha(c byte) bool { return isLCAlpha(c) || (c >= 'A' && c <= 'Z') }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// isTChar reports whether c is a tchar, as in RFC 7230 section 3.2.6.
+func isTChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
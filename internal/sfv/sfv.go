@@ -0,0 +1,77 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sfv implements Structured Field Values for HTTP, as defined in RFC 8941.
+//
+// Bare item values are represented by Go types:
+//
+//	Integer       int64
+//	Decimal       float64
+//	String        string
+//	Token         Token
+//	Byte Sequence []byte
+//	Boolean       bool
+package sfv
+
+// Token is an sf-token bare item, eg `foo` or `*/*`. Tokens are distinct from strings, which
+// are quoted when serialized.
+type Token string
+
+// Param is a single parameter of an item or inner list.
+type Param struct {
+	Key   string
+	Value interface{}
+}
+
+// Params are the ordered parameters of an item or inner list.
+type Params []Param
+
+// Get returns the value of the parameter named key, if set.
+func (p Params) Get(key string) (interface{}, bool) {
+	for _, pp := range p {
+		if pp.Key == key {
+			return pp.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Item is a bare item with parameters.
+type Item struct {
+	Value  interface{}
+	Params Params
+}
+
+// InnerList is a parenthesized list of items, with parameters.
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+// Member is a member of a List or Dictionary: an Item or an InnerList.
+type Member interface{}
+
+// List is an sf-list.
+type List []Member
+
+// DictMember is a single keyed member of a Dictionary.
+type DictMember struct {
+	Key   string
+	Value Member
+}
+
+// Dictionary is an sf-dictionary. Members are kept in order.
+type Dictionary []DictMember
+
+// Get returns the member with the given key, if present.
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+
+	return nil, false
+}
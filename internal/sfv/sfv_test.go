@@ -0,0 +1,279 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sfv
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vector is a test case, in the format of the httpwg structured-field-tests repository:
+// https://github.com/httpwg/structured-field-tests
+type vector struct {
+	Name       string          `json:"name"`
+	Raw        []string        `json:"raw"`
+	HeaderType string          `json:"header_type"`
+	Expected   json.RawMessage `json:"expected"`
+	MustFail   bool            `json:"must_fail"`
+	Canonical  []string        `json:"canonical"`
+}
+
+func readVectors(t *testing.T) []vector {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal("could not read vectors:", err)
+	}
+
+	var vs []vector
+	if err := json.Unmarshal(b, &vs); err != nil {
+		t.Fatal("could not decode vectors:", err)
+	}
+
+	return vs
+}
+
+func decodeJSON(t *testing.T, in json.RawMessage) interface{} {
+	t.Helper()
+
+	d := json.NewDecoder(bytes.NewReader(in))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal("could not decode expected value:", err)
+	}
+
+	return v
+}
+
+func toBareItem(t *testing.T, in interface{}) interface{} {
+	t.Helper()
+
+	switch v := in.(type) {
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".e") {
+			f, err := v.Float64()
+			if err != nil {
+				t.Fatal("bad decimal:", err)
+			}
+			return f
+		}
+
+		i, err := v.Int64()
+		if err != nil {
+			t.Fatal("bad integer:", err)
+		}
+		return i
+	case map[string]interface{}:
+		s := v["value"].(string)
+		switch v["__type"] {
+		case "token":
+			return Token(s)
+		case "binary":
+			b, err := base32.StdEncoding.DecodeString(s)
+			if err != nil {
+				t.Fatal("bad binary:", err)
+			}
+			return b
+		}
+		t.Fatal("unknown type:", v["__type"])
+	}
+
+	return in
+}
+
+func toParams(t *testing.T, in interface{}) Params {
+	t.Helper()
+
+	var p Params
+	for _, pp := range in.([]interface{}) {
+		kv := pp.([]interface{})
+		p = append(p, Param{Key: kv[0].(string), Value: toBareItem(t, kv[1])})
+	}
+
+	return p
+}
+
+func toItem(t *testing.T, in interface{}) Item {
+	t.Helper()
+
+	v := in.([]interface{})
+	return Item{Value: toBareItem(t, v[0]), Params: toParams(t, v[1])}
+}
+
+func toMember(t *testing.T, in interface{}) Member {
+	t.Helper()
+
+	v := in.([]interface{})
+	items, ok := v[0].([]interface{})
+	if !ok {
+		return toItem(t, in)
+	}
+
+	il := InnerList{Params: toParams(t, v[1])}
+	for _, it := range items {
+		il.Items = append(il.Items, toItem(t, it))
+	}
+
+	return il
+}
+
+func toValue(t *testing.T, typ string, in interface{}) interface{} {
+	t.Helper()
+
+	switch typ {
+	case "item":
+		return toItem(t, in)
+	case "list":
+		var l List
+		for _, m := range in.([]interface{}) {
+			l = append(l, toMember(t, m))
+		}
+		return l
+	default:
+		var d Dictionary
+		for _, m := range in.([]interface{}) {
+			kv := m.([]interface{})
+			d = append(d, DictMember{Key: kv[0].(string), Value: toMember(t, kv[1])})
+		}
+		return d
+	}
+}
+
+func serialize(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case Item:
+		return SerializeItem(v)
+	case List:
+		return SerializeList(v)
+	default:
+		return SerializeDictionary(v.(Dictionary))
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	for _, tc := range readVectors(t) {
+		if tc.Expected == nil {
+			continue
+		}
+
+		t.Run(tc.Name, func(t *testing.T) {
+			out, err := serialize(toValue(t, tc.HeaderType, decodeJSON(t, tc.Expected)))
+			if tc.MustFail {
+				if err == nil {
+					t.Errorf("expected serialization to fail. Got: %q", out)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("serialization failed:", err)
+			}
+
+			want := tc.Raw
+			if tc.Canonical != nil {
+				want = tc.Canonical
+			}
+
+			if out != strings.Join(want, ", ") {
+				t.Errorf("unexpected serialization.\nExpected: %s\nGot:      %s", strings.Join(want, ", "), out)
+			}
+		})
+	}
+}
+
+func TestSerializeInnerList(t *testing.T) {
+	il := InnerList{
+		Items: []Item{
+			{Value: "@method"},
+			{Value: "@query-param", Params: Params{{Key: "name", Value: "pet"}}},
+		},
+		Params: Params{{Key: "created", Value: int64(1618884475)}, {Key: "keyid", Value: "test-key"}},
+	}
+
+	out, err := SerializeInnerList(il)
+	if err != nil {
+		t.Fatal("serialization failed:", err)
+	}
+
+	if want := `("@method" "@query-param";name="pet");created=1618884475;keyid="test-key"`; out != want {
+		t.Errorf("unexpected serialization.\nExpected: %s\nGot:      %s", want, out)
+	}
+
+	if _, err := SerializeInnerList(InnerList{Items: []Item{{Value: struct{}{}}}}); err == nil {
+		t.Error("expected unsupported type to fail")
+	}
+}
+
+func parse(typ, in string) (interface{}, error) {
+	switch typ {
+	case "item":
+		return ParseItem(in)
+	case "list":
+		return ParseList(in)
+	default:
+		return ParseDictionary(in)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range readVectors(t) {
+		if tc.Raw == nil {
+			continue
+		}
+
+		t.Run(tc.Name, func(t *testing.T) {
+			got, err := parse(tc.HeaderType, strings.Join(tc.Raw, ", "))
+			if tc.MustFail {
+				if err == nil {
+					t.Errorf("expected parsing to fail. Got: %#v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("parsing failed:", err)
+			}
+
+			if want := toValue(t, tc.HeaderType, decodeJSON(t, tc.Expected)); !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected value.\nExpected: %#v\nGot:      %#v", want, got)
+			}
+		})
+	}
+}
+
+func TestParseInnerList(t *testing.T) {
+	in := `("@method" "@query-param";name="pet");created=1618884475;keyid="test key, \"quoted\""`
+
+	il, err := ParseInnerList(in)
+	if err != nil {
+		t.Fatal("parsing failed:", err)
+	}
+
+	if len(il.Items) != 2 || il.Items[1].Value != "@query-param" {
+		t.Fatalf("unexpected items: %#v", il.Items)
+	}
+
+	if v, ok := il.Params.Get("keyid"); !ok || v != `test key, "quoted"` {
+		t.Errorf("unexpected keyid: %#v", v)
+	}
+
+	if v, ok := il.Params.Get("created"); !ok || v != int64(1618884475) {
+		t.Errorf("unexpected created: %#v", v)
+	}
+
+	for _, in := range []string{"", "(", `"date"`, `("date")junk`, `("date";)`} {
+		if _, err := ParseInnerList(in); err == nil {
+			t.Errorf("expected %q to fail", in)
+		}
+	}
+}
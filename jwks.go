@@ -0,0 +1,569 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JSON Web Key support according to RFC 7517 and RFC 7518
+// https://datatracker.ietf.org/doc/html/rfc7517
+
+// JWKSOption configures a KeyResolver created by NewJWKSKeyResolver.
+type JWKSOption func(r *jwksResolver)
+
+// WithJWKSTTL sets how long a fetched key set is used before it is fetched again. The default
+// is 5 minutes.
+func WithJWKSTTL(d time.Duration) JWKSOption {
+	return func(r *jwksResolver) { r.ttl = d }
+}
+
+// WithJWKSRefetchInterval sets the minimum time between fetches of the key set, whether for an
+// unknown key id or after the TTL. The default is 30 seconds.
+func WithJWKSRefetchInterval(d time.Duration) JWKSOption {
+	return func(r *jwksResolver) { r.refetchInterval = d }
+}
+
+// WithJWKSClient sets the http client used to fetch the key set. The default client has a 10
+// second timeout.
+func WithJWKSClient(c *http.Client) JWKSOption {
+	return func(r *jwksResolver) { r.client = c }
+}
+
+// maxJWKSSize is the largest key set that is read, in bytes.
+const maxJWKSSize = 1 << 20
+
+// NewJWKSKeyResolver returns a KeyResolver that looks up keys by their `kid` in the JSON Web Key
+// Set served at url. The key set is fetched on first use, and cached. If a key id is not in the
+// cached key set, it is fetched again to pick up newly added keys, but no more often than the
+// refetch interval, so requests with made up key ids can't flood url with fetches. Concurrent
+// lookups share a single fetch.
+//
+// Each key's `alg` selects the verification algorithm, using either the JWA name (eg `ES256`),
+// or the http message signature name (eg `ecdsa-p256-sha256`). EC keys without an `alg` are
+// used with their curve's algorithm.
+func NewJWKSKeyResolver(url string, opts ...JWKSOption) KeyResolver {
+	r := &jwksResolver{
+		url:             url,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		ttl:             5 * time.Minute,
+		refetchInterval: 30 * time.Second,
+		nowFunc:         time.Now,
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+type jwksResolver struct {
+	url             string
+	client          *http.Client
+	ttl             time.Duration
+	refetchInterval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]verHolder
+	fetched   time.Time  // of the key set in keys
+	attempted time.Time  // of the last fetch, successful or not
+	inflight  *jwksFetch // the fetch in progress, if any
+
+	// For testing
+	nowFunc func() time.Time
+}
+
+// jwksFetch is a fetch of the key set shared by concurrent lookups.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func (r *jwksResolver) ResolveKey(ctx context.Context, keyID string) (VerificationKey, error) {
+	now := r.nowFunc()
+
+	r.mu.Lock()
+	vh, ok := r.keys[keyID]
+	fresh := r.keys != nil && now.Sub(r.fetched) < r.ttl
+	recent := now.Sub(r.attempted) < r.refetchInterval
+	r.mu.Unlock()
+
+	// Stale keys are still used when the key set can't be fetched again yet.
+	if (ok && fresh) || recent {
+		return VerificationKey{vh: vh}, nil
+	}
+
+	if err := r.refresh(ctx); err != nil {
+		return VerificationKey{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return VerificationKey{vh: r.keys[keyID]}, nil
+}
+
+// refresh fetches the key set, or waits for the fetch already in progress. r.mu is not held
+// during the fetch, so lookups of known keys aren't blocked by a slow key set url.
+func (r *jwksResolver) refresh(ctx context.Context) error {
+	r.mu.Lock()
+	if f := r.inflight; f != nil {
+		r.mu.Unlock()
+
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f := &jwksFetch{done: make(chan struct{})}
+	r.inflight = f
+	r.mu.Unlock()
+
+	var keys map[string]verHolder
+	keys, f.err = r.fetch(ctx)
+
+	r.mu.Lock()
+	now := r.nowFunc()
+	r.attempted = now
+	if f.err == nil {
+		r.keys, r.fetched = keys, now
+	}
+	r.inflight = nil
+	r.mu.Unlock()
+
+	close(f.done)
+	return f.err
+}
+
+func (r *jwksResolver) fetch(ctx context.Context) (map[string]verHolder, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+
+	keys := make(map[string]verHolder, len(set.Keys))
+	for _, k := range set.Keys {
+		vh, err := k.verHolder()
+		if err != nil {
+			// Skip keys we don't understand, rather than failing for all keys.
+			continue
+		}
+
+		keys[k.Kid] = vh
+	}
+
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+
+	// RSA
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+
+	// EC and OKP
+	X string `json:"x,omitempty"`
+	Y string `json:"y,omitempty"`
+
+	// Private key, for RSA, EC and OKP
+	D string `json:"d,omitempty"`
+
+	// Symmetric
+	K string `json:"k,omitempty"`
+}
+
+// jwaAlgs maps JWA algorithm names to their http message signature equivalent.
+var jwaAlgs = map[string]string{
+	"RS256": "rsa-pkcs1-sha256",
+	"RS512": "rsa-pkcs1-sha512",
+	"PS512": "rsa-pss-sha512",
+	"ES256": "ecdsa-p256-sha256",
+	"ES384": "ecdsa-p384-sha384",
+	"ES512": "ecdsa-p521-sha512",
+	"EdDSA": "ed25519",
+	"HS256": "hmac-sha256",
+	"HS384": "hmac-sha384",
+	"HS512": "hmac-sha512",
+}
+
+var curveAlgs = map[string]string{
+	"P-256":   "ecdsa-p256-sha256",
+	"P-384":   "ecdsa-p384-sha384",
+	"P-521":   "ecdsa-p521-sha512",
+	"Ed25519": "ed25519",
+}
+
+var (
+	errUnsupportedJWK = errors.New("unsupported jwk")
+	errInvalidJWK     = errors.New("invalid jwk")
+)
+
+// ParseKeyFromJWK parses a JSON Web Key, returning the key and its http message signature
+// algorithm, eg `ecdsa-p256-sha256`. The key is an *rsa.PublicKey, *ecdsa.PublicKey,
+// ed25519.PublicKey, or []byte for symmetric keys, or for keys with private key material, an
+// *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey.
+//
+// The algorithm is taken from the key's `alg`, using either the JWA name (eg `ES256`), or the
+// http message signature name. EC and Ed25519 keys without an `alg` have their curve's
+// algorithm; for other keys without one, the algorithm is empty.
+func ParseKeyFromJWK(jwkJSON []byte) (interface{}, string, error) {
+	var k jwk
+	if err := json.Unmarshal(jwkJSON, &k); err != nil {
+		return nil, "", err
+	}
+
+	return k.key()
+}
+
+// SerializeKeyToJWK returns key as a JSON Web Key, with keyID as its `kid`, and alg as its
+// `alg`, using the JWA name where there is one (eg `ES256` for `ecdsa-p256-sha256`). The key
+// types are those returned by ParseKeyFromJWK. Private keys include their private key material,
+// so take care where the JWK is sent; use the public key to share it with other services.
+func SerializeKeyToJWK(keyID, alg string, key interface{}) ([]byte, error) {
+	k := jwk{Kid: keyID, Alg: alg}
+	for jwa, a := range jwaAlgs {
+		if a == alg {
+			k.Alg = jwa
+			break
+		}
+	}
+
+	enc := base64.RawURLEncoding.EncodeToString
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key == nil || len(key.Primes) != 2 {
+			return nil, errInvalidJWK
+		}
+
+		// Computed here, rather than with Precompute, so key is left as it is.
+		p, q, one := key.Primes[0], key.Primes[1], big.NewInt(1)
+		dp := new(big.Int).Mod(key.D, new(big.Int).Sub(p, one))
+		dq := new(big.Int).Mod(key.D, new(big.Int).Sub(q, one))
+		qi := new(big.Int).ModInverse(q, p)
+		if qi == nil {
+			return nil, errInvalidJWK
+		}
+
+		k.D, k.P, k.Q = enc(key.D.Bytes()), enc(p.Bytes()), enc(q.Bytes())
+		k.DP, k.DQ, k.QI = enc(dp.Bytes()), enc(dq.Bytes()), enc(qi.Bytes())
+
+		return serializeRSAJWK(k, &key.PublicKey)
+	case *rsa.PublicKey:
+		if key == nil {
+			return nil, errInvalidJWK
+		}
+
+		return serializeRSAJWK(k, key)
+	case *ecdsa.PrivateKey:
+		if key == nil {
+			return nil, errInvalidJWK
+		}
+
+		k.D = enc(key.D.FillBytes(make([]byte, (key.Curve.Params().N.BitLen()+7)/8)))
+
+		return serializeECJWK(k, &key.PublicKey)
+	case *ecdsa.PublicKey:
+		if key == nil {
+			return nil, errInvalidJWK
+		}
+
+		return serializeECJWK(k, key)
+	case ed25519.PrivateKey:
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, errInvalidJWK
+		}
+
+		k.Kty, k.Crv = "OKP", "Ed25519"
+		k.X, k.D = enc(key.Public().(ed25519.PublicKey)), enc(key.Seed())
+	case ed25519.PublicKey:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errInvalidJWK
+		}
+
+		k.Kty, k.Crv = "OKP", "Ed25519"
+		k.X = enc(key)
+	case []byte:
+		if len(key) == 0 {
+			return nil, errInvalidJWK
+		}
+
+		k.Kty = "oct"
+		k.K = enc(key)
+	default:
+		return nil, errUnsupportedJWK
+	}
+
+	return json.Marshal(k)
+}
+
+func serializeRSAJWK(k jwk, pk *rsa.PublicKey) ([]byte, error) {
+	k.Kty = "RSA"
+	k.N = base64.RawURLEncoding.EncodeToString(pk.N.Bytes())
+	k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes())
+
+	return json.Marshal(k)
+}
+
+func serializeECJWK(k jwk, pk *ecdsa.PublicKey) ([]byte, error) {
+	params := pk.Curve.Params()
+	switch params.Name {
+	case "P-256", "P-384", "P-521":
+	default:
+		return nil, errUnsupportedJWK
+	}
+
+	// Coordinates are the full size of the field, with any leading zeros (RFC 7518 section 6.2.1.2).
+	size := (params.BitSize + 7) / 8
+	k.Kty, k.Crv = "EC", params.Name
+	k.X = base64.RawURLEncoding.EncodeToString(pk.X.FillBytes(make([]byte, size)))
+	k.Y = base64.RawURLEncoding.EncodeToString(pk.Y.FillBytes(make([]byte, size)))
+
+	return json.Marshal(k)
+}
+
+// jwkBytes decodes the base64url encoded field of a jwk. Missing fields are invalid.
+func jwkBytes(in string) ([]byte, error) {
+	if in == "" {
+		return nil, errInvalidJWK
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(in)
+	if err != nil {
+		return nil, errInvalidJWK
+	}
+
+	return b, nil
+}
+
+// jwkInt decodes the base64url encoded unsigned integer field of a jwk.
+func jwkInt(in string) (*big.Int, error) {
+	b, err := jwkBytes(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// key returns the key for k, and its algorithm, as for ParseKeyFromJWK.
+func (k *jwk) key() (interface{}, string, error) {
+	alg := k.Alg
+	if a, ok := jwaAlgs[alg]; ok {
+		alg = a
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := jwkInt(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+
+		e, err := jwkInt(k.E)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, "", errInvalidJWK
+		}
+
+		pk := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if k.D == "" {
+			return pk, alg, nil
+		}
+
+		// Go needs the primes of private keys, not just the private exponent.
+		var ints [3]*big.Int
+		for i, f := range []string{k.D, k.P, k.Q} {
+			if ints[i], err = jwkInt(f); err != nil {
+				return nil, "", err
+			}
+		}
+
+		priv := &rsa.PrivateKey{PublicKey: *pk, D: ints[0], Primes: []*big.Int{ints[1], ints[2]}}
+		if err := priv.Validate(); err != nil {
+			return nil, "", errInvalidJWK
+		}
+		priv.Precompute()
+
+		return priv, alg, nil
+	case "EC":
+		if alg == "" {
+			alg = curveAlgs[k.Crv]
+		}
+
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, "", errUnsupportedJWK
+		}
+
+		x, err := jwkInt(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+
+		y, err := jwkInt(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+
+		pk := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if k.D == "" {
+			return pk, alg, nil
+		}
+
+		d, err := jwkInt(k.D)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return &ecdsa.PrivateKey{PublicKey: *pk, D: d}, alg, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, "", errUnsupportedJWK
+		}
+
+		if alg == "" {
+			alg = curveAlgs[k.Crv]
+		}
+
+		x, err := jwkBytes(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", errInvalidJWK
+		}
+
+		if k.D == "" {
+			return ed25519.PublicKey(x), alg, nil
+		}
+
+		seed, err := jwkBytes(k.D)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, "", errInvalidJWK
+		}
+
+		priv := ed25519.NewKeyFromSeed(seed)
+		if !priv.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+			return nil, "", errInvalidJWK
+		}
+
+		return priv, alg, nil
+	case "oct":
+		secret, err := jwkBytes(k.K)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return secret, alg, nil
+	}
+
+	return nil, "", errUnsupportedJWK
+}
+
+func (k *jwk) verHolder() (verHolder, error) {
+	key, alg, err := k.key()
+	if err != nil {
+		return verHolder{}, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return rsaVerHolder(&key.PublicKey, alg)
+	case *rsa.PublicKey:
+		return rsaVerHolder(key, alg)
+	case *ecdsa.PrivateKey:
+		return ecdsaVerHolder(&key.PublicKey, alg)
+	case *ecdsa.PublicKey:
+		return ecdsaVerHolder(key, alg)
+	case []byte:
+		switch alg {
+		case "hmac-sha256":
+			return verifyHmacSha256(key), nil
+		case "hmac-sha384":
+			return verifyHmacSha384(key), nil
+		case "hmac-sha512":
+			return verifyHmacSha512(key), nil
+		}
+	}
+
+	return verHolder{}, errUnsupportedJWK
+}
+
+func rsaVerHolder(pk *rsa.PublicKey, alg string) (verHolder, error) {
+	switch alg {
+	case "rsa-pss-sha512":
+		return verifyRsaPssSha512(pk), nil
+	case "rsa-pkcs1-sha256":
+		return verifyRsaPkcs1Sha256(pk), nil
+	case "rsa-pkcs1-sha512":
+		return verifyRsaPkcs1Sha512(pk), nil
+	}
+
+	return verHolder{}, errUnsupportedJWK
+}
+
+func ecdsaVerHolder(pk *ecdsa.PublicKey, alg string) (verHolder, error) {
+	switch alg {
+	case "ecdsa-p256-sha256":
+		return verifyEccP256(pk), nil
+	case "ecdsa-p384-sha384":
+		return verifyEccP384(pk), nil
+	case "ecdsa-p521-sha512":
+		return verifyEccP521(pk), nil
+	}
+
+	return verHolder{}, errUnsupportedJWK
+}
@@ -0,0 +1,461 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a mutable JSON Web Key Set, counting fetches.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetches++
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func ecJWK(kid string, pk *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": pk.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(pk.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(pk.Y.Bytes()),
+	}
+}
+
+func TestJWKSKeyResolver(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	js := &jwksServer{keys: []map[string]string{
+		ecJWK("ec-key", &pk.PublicKey),
+		{"kty": "oct", "kid": "hmac-key", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(secret)},
+		{"kty": "OKP", "kid": "unsupported-key", "crv": "Ed25519", "x": "AAAA"},
+	}}
+
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	now := time.Unix(1618884475, 0)
+	r := NewJWKSKeyResolver(srv.URL, WithJWKSTTL(time.Minute), WithJWKSRefetchInterval(10*time.Second)).(*jwksResolver)
+	r.nowFunc = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	t.Run("verifies", func(t *testing.T) {
+		for kid, sh := range map[string]sigHolder{"ec-key": signEccP256(pk), "hmac-key": signHmacSha256(secret)} {
+			req := testReq()
+			signMessage(t, testSigner(kid, sh), req)
+
+			v := testVerifier("static-key", verifyHmacSha256(secret))
+			v.resolver = r
+
+			if _, err := v.Verify(req); err != nil {
+				t.Errorf("verification with %s failed: %s", kid, err)
+			}
+		}
+
+		if js.fetches != 1 {
+			t.Error("expected key set to be cached. Fetches:", js.fetches)
+		}
+	})
+
+	t.Run("refetches unknown key", func(t *testing.T) {
+		fetches := js.fetches
+
+		if _, err := r.ResolveKey(ctx, "new-key"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if js.fetches != fetches {
+			t.Error("expected no refetch within the refetch interval")
+		}
+
+		now = now.Add(10 * time.Second)
+
+		key, err := r.ResolveKey(ctx, "new-key")
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if !key.IsZero() {
+			t.Error("expected no key to be found")
+		}
+
+		if js.fetches != fetches+1 {
+			t.Error("expected one refetch. Fetches:", js.fetches-fetches)
+		}
+
+		js.mu.Lock()
+		js.keys = append(js.keys, map[string]string{"kty": "oct", "kid": "new-key", "alg": "hmac-sha512", "k": "c2VjcmV0"})
+		js.mu.Unlock()
+
+		now = now.Add(10 * time.Second)
+
+		key, err = r.ResolveKey(ctx, "new-key")
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if key.Alg() != "hmac-sha512" {
+			t.Error("expected new key to be found. Got alg:", key.Alg())
+		}
+	})
+
+	t.Run("unsupported key skipped", func(t *testing.T) {
+		key, err := r.ResolveKey(ctx, "unsupported-key")
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if !key.IsZero() {
+			t.Error("expected unsupported key to be skipped")
+		}
+	})
+
+	t.Run("ttl expiry", func(t *testing.T) {
+		fetches := js.fetches
+
+		if _, err := r.ResolveKey(ctx, "ec-key"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if js.fetches != fetches {
+			t.Error("expected cached key set before ttl")
+		}
+
+		now = now.Add(time.Minute)
+
+		if _, err := r.ResolveKey(ctx, "ec-key"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+
+		if js.fetches != fetches+1 {
+			t.Error("expected key set to be fetched after ttl")
+		}
+	})
+}
+
+func TestJWKSKeyResolver_UnknownKeyFlood(t *testing.T) {
+	const n = 50
+
+	release := make(chan struct{})
+	js := &jwksServer{keys: []map[string]string{
+		{"kty": "oct", "kid": "hmac-key", "alg": "HS256", "k": "c2VjcmV0"},
+	}}
+
+	var block sync.Once
+	requested := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		js.mu.Lock()
+		first := js.fetches == 0
+		js.mu.Unlock()
+
+		// Hold the refetch until every lookup is waiting for it.
+		if !first {
+			block.Do(func() { close(requested) })
+			<-release
+		}
+
+		js.ServeHTTP(rw, req)
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1618884475, 0)
+	r := NewJWKSKeyResolver(srv.URL).(*jwksResolver)
+	r.nowFunc = func() time.Time { return now }
+
+	ctx := context.Background()
+	if key, err := r.ResolveKey(ctx, "hmac-key"); err != nil || key.IsZero() {
+		t.Fatal("could not resolve key:", err)
+	}
+
+	now = now.Add(r.refetchInterval)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if key, err := r.ResolveKey(ctx, fmt.Sprintf("random-%d", i)); err != nil || !key.IsZero() {
+				t.Errorf("unexpected key or error: %v", err)
+			}
+		}(i)
+	}
+
+	<-requested
+
+	// Known keys are resolved while the fetch is in progress.
+	if key, err := r.ResolveKey(ctx, "hmac-key"); err != nil || key.IsZero() {
+		t.Error("could not resolve key during fetch:", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	// Later unknown key ids within the refetch interval aren't fetched.
+	for i := 0; i < n; i++ {
+		if _, err := r.ResolveKey(ctx, fmt.Sprintf("random-again-%d", i)); err != nil {
+			t.Error("unexpected error:", err)
+		}
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if js.fetches > 2 {
+		t.Errorf("expected at most one refetch for %d unknown key ids. Got: %d", 2*n, js.fetches-1)
+	}
+}
+
+func TestJWKSKeyResolver_FetchError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	req := testReq()
+	signMessage(t, testSigner("some-key", signHmacSha256([]byte("secret"))), req)
+
+	v := testVerifier("static-key", verifyHmacSha256([]byte("secret")))
+	v.resolver = NewJWKSKeyResolver(srv.URL)
+
+	if _, err := v.Verify(req); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key error. Got:", err)
+	}
+}
+
+func TestParseKeyFromJWK(t *testing.T) {
+	// The test-key-rsa-pss, test-key-ecc-p256 and test-key-ed25519 keys from the Draft Standard,
+	// as JWKs.
+	rsaJWK := `{"kty":"RSA","alg":"PS512","e":"AQAB","n":"r4tmm3r20Wd_PbqvP1s2-QEtvpuRaV8Yq40gjUR8y2Rjxa6dpG2GXHbPfvMs8ct-Lh1GH45x28Rw3Ry53mm-oAXjyQ86OnDkZ5N8lYbggD4O3w6M6pAvLkhk95AndTrifbIFPNU8PPMO7OyrFAHqgDsznjPFmTOtCEcN2Z1FpWgchwuYLPL-Wokqltd11nqqzi-bJ9cvSKADYdUAAN5WUtzdpiy6LbTgSxP7ociU4Tn0g5I6aDZJ7A8Lzo0KSyZYoA485mqcO0GVAdVw9lq4aOT9v6d-nb4bnNkQVklLQ3fVAvJm-xdDOp9LCNCN48V2pnDOkFV6-U9nV5oyc6XI2w"}`
+	ecJWKPriv := `{"kty":"EC","crv":"P-256","x":"qIVYZVLCrPZHGHjP17CTW0_-D9Lfw0EkjqF7xB4FivA","y":"Mc4nN9LTDOBhfoUeg8Ye9WedFRhnZXZJA12Qp0zZ6F0","d":"UpuF81l-kOxbjf7T4mNSv0r5tN67Gim7rnf6EFpcYDs"}`
+	edJWK := `{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs"}`
+	edJWKPriv := `{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs","d":"n4Ni-HpISpVObnQMW0wOhCKROaIKqKtW_2ZYb2p9KcU"}`
+
+	key, alg, err := ParseKeyFromJWK([]byte(rsaJWK))
+	if err != nil {
+		t.Fatal("could not parse rsa jwk:", err)
+	}
+
+	want, err := ParseRSAPublicKeyPEM([]byte(testKeyRSAPSSPub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pk, ok := key.(*rsa.PublicKey); !ok || !pk.Equal(want) || alg != "rsa-pss-sha512" {
+		t.Errorf("unexpected rsa key %T with alg %q", key, alg)
+	}
+
+	key, alg, err = ParseKeyFromJWK([]byte(ecJWKPriv))
+	if err != nil {
+		t.Fatal("could not parse ec jwk:", err)
+	}
+
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok || alg != "ecdsa-p256-sha256" {
+		t.Fatalf("unexpected ec key %T with alg %q", key, alg)
+	}
+
+	// The private key signs for its public key.
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	if err := SignRequest(req, WithSignEcdsaP256Sha256("test-key-ecc-p256", priv)); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if err := VerifyRequest(req, WithVerifyEcdsaP256Sha256("test-key-ecc-p256", &priv.PublicKey)); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(ecJWK("p384", &p384.PublicKey))
+	if key, alg, err := ParseKeyFromJWK(b); err != nil || !p384.PublicKey.Equal(key) || alg != "ecdsa-p384-sha384" {
+		t.Errorf("unexpected p-384 key %T with alg %q: %v", key, alg, err)
+	}
+
+	key, alg, err = ParseKeyFromJWK([]byte(edJWK))
+	if err != nil {
+		t.Fatal("could not parse ed25519 jwk:", err)
+	}
+
+	edPub, ok := key.(ed25519.PublicKey)
+	if !ok || base64.StdEncoding.EncodeToString(edPub) != "JrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=" || alg != "ed25519" {
+		t.Errorf("unexpected ed25519 key %T with alg %q", key, alg)
+	}
+
+	if key, _, err := ParseKeyFromJWK([]byte(edJWKPriv)); err != nil || !edPub.Equal(key.(ed25519.PrivateKey).Public()) {
+		t.Errorf("unexpected ed25519 private key: %v", err)
+	}
+
+	if key, alg, err := ParseKeyFromJWK([]byte(`{"kty":"oct","alg":"HS256","k":"c2VjcmV0"}`)); err != nil || string(key.([]byte)) != "secret" || alg != "hmac-sha256" {
+		t.Errorf("unexpected symmetric key %v with alg %q: %v", key, alg, err)
+	}
+
+	tcs := []struct {
+		name string
+		in   string
+		want error
+	}{
+		{"unknown kty", `{"kty":"foo"}`, errUnsupportedJWK},
+		{"unknown curve", `{"kty":"EC","crv":"P-224","x":"AA","y":"AA"}`, errUnsupportedJWK},
+		{"missing x", `{"kty":"EC","crv":"P-256","y":"AA"}`, errInvalidJWK},
+		{"rsa without primes", `{"kty":"RSA","n":"AQAB","e":"AQAB","d":"AQAB"}`, errInvalidJWK},
+		{"short ed25519", `{"kty":"OKP","crv":"Ed25519","x":"AAAA"}`, errInvalidJWK},
+		{"mismatched ed25519", `{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo","d":"n4Ni-HpISpVObnQMW0wOhCKROaIKqKtW_2ZYb2p9KcU"}`, errInvalidJWK},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := ParseKeyFromJWK([]byte(tc.in)); err != tc.want {
+				t.Errorf("expected %v. Got: %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestSerializeKeyToJWK(t *testing.T) {
+	rsaKey, err := ParseRSAPrivateKeyPEM(readFixture(t, "rsa-pkcs8.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each private key is round tripped and used to sign, then verified with the original
+	// public key, and the round tripped public key.
+	tcs := []struct {
+		name    string
+		alg     string
+		jwa     string
+		priv    interface{}
+		pub     interface{}
+		signOpt func(key interface{}) SigningOption
+		verOpt  func(key interface{}) VerifyOption
+	}{
+		{
+			name: "rsa", alg: "rsa-pss-sha512", jwa: "PS512", priv: rsaKey, pub: &rsaKey.PublicKey,
+			signOpt: func(k interface{}) SigningOption { return WithSignRsaPssSha512("key", k.(*rsa.PrivateKey)) },
+			verOpt:  func(k interface{}) VerifyOption { return WithVerifyRsaPssSha512("key", k.(*rsa.PublicKey)) },
+		},
+		{
+			name: "p-256", alg: "ecdsa-p256-sha256", jwa: "ES256", priv: p256, pub: &p256.PublicKey,
+			signOpt: func(k interface{}) SigningOption { return WithSignEcdsaP256Sha256("key", k.(*ecdsa.PrivateKey)) },
+			verOpt:  func(k interface{}) VerifyOption { return WithVerifyEcdsaP256Sha256("key", k.(*ecdsa.PublicKey)) },
+		},
+		{
+			name: "p-384", alg: "ecdsa-p384-sha384", jwa: "ES384", priv: p384, pub: &p384.PublicKey,
+			signOpt: func(k interface{}) SigningOption { return WithSignEcdsaP384Sha384("key", k.(*ecdsa.PrivateKey)) },
+			verOpt:  func(k interface{}) VerifyOption { return WithVerifyEcdsaP384Sha384("key", k.(*ecdsa.PublicKey)) },
+		},
+		{
+			name: "hmac", alg: "hmac-sha256", jwa: "HS256", priv: []byte(testSecret), pub: []byte(testSecret),
+			signOpt: func(k interface{}) SigningOption { return WithHmacSha256("key", k.([]byte)) },
+			verOpt:  func(k interface{}) VerifyOption { return WithHmacSha256("key", k.([]byte)) },
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := SerializeKeyToJWK("key", tc.alg, tc.priv)
+			if err != nil {
+				t.Fatal("could not serialize private key:", err)
+			}
+
+			priv, alg, err := ParseKeyFromJWK(b)
+			if err != nil || alg != tc.alg {
+				t.Fatalf("could not parse private key %s, with alg %q: %v", b, alg, err)
+			}
+
+			b, err = SerializeKeyToJWK("key", tc.alg, tc.pub)
+			if err != nil {
+				t.Fatal("could not serialize public key:", err)
+			}
+
+			var fields map[string]string
+			if err := json.Unmarshal(b, &fields); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := fields["d"]; ok || fields["kid"] != "key" || fields["alg"] != tc.jwa {
+				t.Errorf("unexpected public jwk fields: %v", fields)
+			}
+
+			pub, _, err := ParseKeyFromJWK(b)
+			if err != nil {
+				t.Fatal("could not parse public key:", err)
+			}
+
+			for _, verifyWith := range []interface{}{tc.pub, pub} {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				if err := SignRequest(req, tc.signOpt(priv)); err != nil {
+					t.Fatal("signing failed:", err)
+				}
+
+				if err := VerifyRequest(req, tc.verOpt(verifyWith)); err != nil {
+					t.Error("verification failed:", err)
+				}
+			}
+		})
+	}
+
+	b, err := SerializeKeyToJWK("ed", "ed25519", edKey)
+	if err != nil {
+		t.Fatal("could not serialize ed25519 key:", err)
+	}
+
+	if key, alg, err := ParseKeyFromJWK(b); err != nil || !edKey.Equal(key) || alg != "ed25519" {
+		t.Errorf("unexpected ed25519 key %T with alg %q: %v", key, alg, err)
+	}
+
+	if b, err = SerializeKeyToJWK("ed", "ed25519", edPub); err != nil || bytes.Contains(b, []byte(`"d"`)) {
+		t.Errorf("unexpected ed25519 public jwk %s: %v", b, err)
+	}
+
+	if _, err := SerializeKeyToJWK("key", "", "not a key"); err != errUnsupportedJWK {
+		t.Error("expected unsupported key error. Got:", err)
+	}
+}
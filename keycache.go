@@ -0,0 +1,156 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheOption configures the cache of WithCachedKeyResolver.
+type CacheOption func(c *cachedResolver)
+
+// WithCacheMetrics calls counter on every lookup in the cache, with whether the key was found.
+func WithCacheMetrics(counter func(hit bool)) CacheOption {
+	return func(c *cachedResolver) { c.counter = counter }
+}
+
+// WithCachedKeyResolver looks up keys using resolver, as with WithKeyResolver, caching each key
+// found for ttl. At most maxKeys keys are cached, dropping the least recently used. Failed
+// lookups aren't cached, so are retried on the next request. Concurrent lookups of the same
+// uncached key share a single call to resolver.
+func WithCachedKeyResolver(resolver KeyResolver, ttl time.Duration, maxKeys int, opts ...CacheOption) VerifyOption {
+	c := &cachedResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		maxKeys:  maxKeys,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*resolveCall),
+		nowFunc:  time.Now,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return WithKeyResolver(c)
+}
+
+type cachedResolver struct {
+	resolver KeyResolver
+	ttl      time.Duration
+	maxKeys  int
+	counter  func(hit bool)
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+
+	// Resolver calls in progress, by key id.
+	inflight map[string]*resolveCall
+
+	// For testing
+	nowFunc func() time.Time
+}
+
+type cacheEntry struct {
+	keyID   string
+	key     VerificationKey
+	expires time.Time
+}
+
+// resolveCall is a resolver call shared by concurrent lookups of the same key id.
+type resolveCall struct {
+	done chan struct{}
+	key  VerificationKey
+	err  error
+}
+
+func (c *cachedResolver) ResolveKey(ctx context.Context, keyID string) (VerificationKey, error) {
+	c.mu.Lock()
+	if key, ok := c.lookup(keyID); ok {
+		c.mu.Unlock()
+		c.count(true)
+		return key, nil
+	}
+
+	call, ok := c.inflight[keyID]
+	if !ok {
+		call = &resolveCall{done: make(chan struct{})}
+		c.inflight[keyID] = call
+	}
+	c.mu.Unlock()
+	c.count(false)
+
+	if ok {
+		select {
+		case <-call.done:
+			return call.key, call.err
+		case <-ctx.Done():
+			return VerificationKey{}, ctx.Err()
+		}
+	}
+
+	call.key, call.err = c.resolver.ResolveKey(ctx, keyID)
+
+	c.mu.Lock()
+	if call.err == nil && !call.key.IsZero() {
+		c.store(keyID, call.key)
+	}
+	delete(c.inflight, keyID)
+	c.mu.Unlock()
+
+	close(call.done)
+	return call.key, call.err
+}
+
+func (c *cachedResolver) count(hit bool) {
+	if c.counter != nil {
+		c.counter(hit)
+	}
+}
+
+// lookup returns the cached key for keyID, if it hasn't expired. c.mu must be held.
+func (c *cachedResolver) lookup(keyID string) (VerificationKey, bool) {
+	el, ok := c.entries[keyID]
+	if !ok {
+		return VerificationKey{}, false
+	}
+
+	e := el.Value.(*cacheEntry)
+	if !c.nowFunc().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, keyID)
+		return VerificationKey{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	return e.key, true
+}
+
+// store caches key for keyID, dropping the least recently used keys over the limit. c.mu must
+// be held.
+func (c *cachedResolver) store(keyID string, key VerificationKey) {
+	if c.maxKeys <= 0 {
+		return
+	}
+
+	e := &cacheEntry{keyID: keyID, key: key, expires: c.nowFunc().Add(c.ttl)}
+	if el, ok := c.entries[keyID]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[keyID] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxKeys {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).keyID)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// onceResolver is a KeyResolver that panics when a key id is resolved twice.
+type onceResolver struct {
+	mockResolver
+	seen map[string]bool
+}
+
+func (o *onceResolver) ResolveKey(ctx context.Context, keyID string) (VerificationKey, error) {
+	if o.seen[keyID] {
+		panic("key resolved twice: " + keyID)
+	}
+	o.seen[keyID] = true
+
+	return o.mockResolver.ResolveKey(ctx, keyID)
+}
+
+func TestCachedKeyResolver(t *testing.T) {
+	secret := []byte(testSecret)
+
+	r := &onceResolver{
+		mockResolver: mockResolver{keys: map[string]verHolder{
+			"key1": verifyHmacSha256(secret),
+			"key2": verifyHmacSha256(secret),
+		}},
+		seen: make(map[string]bool),
+	}
+
+	var hits []bool
+	tv, mw := newTestVerifyMiddleware(WithCachedKeyResolver(r, time.Minute, 10, WithCacheMetrics(func(hit bool) {
+		hits = append(hits, hit)
+	})))
+	h := mw.Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, keyID := range []string{"key1", "key1", "key2", "key1", "key2"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if err := SignRequest(req, WithHmacSha256(keyID, secret)); err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if res, err := tv.LastResult(); rec.Code != http.StatusOK || res.KeyID != keyID {
+			t.Errorf("unexpected result for %s: %d %+v: %v", keyID, rec.Code, res, err)
+		}
+	}
+
+	if want := []bool{false, true, false, true, true}; !reflect.DeepEqual(hits, want) {
+		t.Errorf("unexpected cache hits %v, want %v", hits, want)
+	}
+
+	if r.calls != 2 {
+		t.Error("expected two resolver calls. Got:", r.calls)
+	}
+}
+
+func TestCachedKeyResolver_Eviction(t *testing.T) {
+	secret := []byte(testSecret)
+	ctx := context.Background()
+
+	r := &mockResolver{keys: map[string]verHolder{
+		"key1": verifyHmacSha256(secret),
+		"key2": verifyHmacSha256(secret),
+		"key3": verifyHmacSha256(secret),
+	}}
+
+	now := time.Unix(1618884475, 0)
+	c := newVerifier([]VerifyOption{WithCachedKeyResolver(r, time.Minute, 2)}).resolver.(*cachedResolver)
+	c.nowFunc = func() time.Time { return now }
+
+	resolve := func(keyID string, wantCalls int) {
+		t.Helper()
+
+		if key, err := c.ResolveKey(ctx, keyID); err != nil || key.IsZero() {
+			t.Fatalf("could not resolve %s: %v", keyID, err)
+		}
+
+		if r.calls != wantCalls {
+			t.Errorf("expected %d resolver calls after %s. Got: %d", wantCalls, keyID, r.calls)
+		}
+	}
+
+	resolve("key1", 1)
+	resolve("key2", 2)
+	resolve("key1", 2)
+
+	// key2 is the least recently used, so is dropped for key3.
+	resolve("key3", 3)
+	resolve("key1", 3)
+	resolve("key2", 4)
+
+	// Keys expire after the ttl.
+	now = now.Add(time.Minute)
+	resolve("key2", 5)
+
+	// Unknown keys aren't cached.
+	for i := 0; i < 2; i++ {
+		if key, _ := c.ResolveKey(ctx, "unknown"); !key.IsZero() {
+			t.Error("unexpected key for an unknown key id")
+		}
+	}
+
+	if r.calls != 7 {
+		t.Error("expected unknown keys to be resolved each time. Got:", r.calls)
+	}
+}
+
+// blockingResolver is a KeyResolver that waits for release before resolving.
+type blockingResolver struct {
+	mockResolver
+	release chan struct{}
+}
+
+func (b *blockingResolver) ResolveKey(ctx context.Context, keyID string) (VerificationKey, error) {
+	<-b.release
+	return b.mockResolver.ResolveKey(ctx, keyID)
+}
+
+func TestCachedKeyResolver_ConcurrentMisses(t *testing.T) {
+	const n = 20
+	secret := []byte(testSecret)
+
+	r := &blockingResolver{
+		mockResolver: mockResolver{keys: map[string]verHolder{"key1": verifyHmacSha256(secret)}},
+		release:      make(chan struct{}),
+	}
+
+	var misses int32
+	c := newVerifier([]VerifyOption{WithCachedKeyResolver(r, time.Minute, 10, WithCacheMetrics(func(hit bool) {
+		if !hit {
+			atomic.AddInt32(&misses, 1)
+		}
+	}))}).resolver.(*cachedResolver)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			key, err := c.ResolveKey(context.Background(), "key1")
+			if err == nil && key.IsZero() {
+				err = errors.New("no key resolved")
+			}
+			errs <- err
+		}()
+	}
+
+	// Every lookup has missed the cache and is waiting on the first resolver call.
+	for atomic.LoadInt32(&misses) < n {
+		time.Sleep(time.Millisecond)
+	}
+	close(r.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error("could not resolve key:", err)
+		}
+	}
+
+	if r.calls != 1 {
+		t.Error("expected concurrent misses to share one resolver call. Got:", r.calls)
+	}
+}
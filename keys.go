@@ -0,0 +1,146 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+var (
+	errNoPEMBlock     = errors.New("no pem block found")
+	errWrongKeyType   = errors.New("pem block holds a different type of key")
+	errUnknownPEMKey  = errors.New("pem block holds an unsupported key encoding")
+	errEmptyMasterKey = errors.New("empty master key")
+)
+
+func decodePEM(pemBytes []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+
+	return block.Bytes, nil
+}
+
+// ParseRSAPublicKeyPEM parses a PEM encoded RSA public key, in either PKIX ("PUBLIC KEY") or
+// PKCS #1 ("RSA PUBLIC KEY") form, for use with WithVerifyRsaPssSha512 and friends.
+func ParseRSAPublicKeyPEM(pemBytes []byte) (*rsa.PublicKey, error) {
+	der, err := decodePEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if pk, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return pk, nil
+	}
+
+	pki, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errUnknownPEMKey
+	}
+
+	pk, ok := pki.(*rsa.PublicKey)
+	if !ok {
+		return nil, errWrongKeyType
+	}
+
+	return pk, nil
+}
+
+// ParseECPublicKeyPEM parses a PEM encoded PKIX ("PUBLIC KEY") ECDSA public key, for use with
+// WithVerifyEcdsaP256Sha256 and friends.
+func ParseECPublicKeyPEM(pemBytes []byte) (*ecdsa.PublicKey, error) {
+	der, err := decodePEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pki, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errUnknownPEMKey
+	}
+
+	pk, ok := pki.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errWrongKeyType
+	}
+
+	return pk, nil
+}
+
+// ParseRSAPrivateKeyPEM parses a PEM encoded RSA private key, in either PKCS #8 ("PRIVATE KEY")
+// or PKCS #1 ("RSA PRIVATE KEY") form, for use with WithSignRsaPssSha512 and friends.
+func ParseRSAPrivateKeyPEM(pemBytes []byte) (*rsa.PrivateKey, error) {
+	der, err := decodePEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if pk, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return pk, nil
+	}
+
+	pki, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errUnknownPEMKey
+	}
+
+	pk, ok := pki.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errWrongKeyType
+	}
+
+	return pk, nil
+}
+
+// ParseECPrivateKeyPEM parses a PEM encoded ECDSA private key, in either PKCS #8 ("PRIVATE KEY")
+// or SEC 1 ("EC PRIVATE KEY") form, for use with WithSignEcdsaP256Sha256 and friends.
+func ParseECPrivateKeyPEM(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+	der, err := decodePEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if pk, err := x509.ParseECPrivateKey(der); err == nil {
+		return pk, nil
+	}
+
+	pki, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errUnknownPEMKey
+	}
+
+	pk, ok := pki.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errWrongKeyType
+	}
+
+	return pk, nil
+}
+
+// DeriveHmacKey derives a 32 byte `hmac-sha256` key for keyID from masterKey, using HKDF with
+// sha-256 (RFC 5869), no salt, and keyID as the info. It is the default derivation for
+// WithHmacSha256Derived; signers use it to find the key to sign with for their key id.
+func DeriveHmacKey(masterKey []byte, keyID string) ([]byte, error) {
+	if len(masterKey) == 0 {
+		return nil, errEmptyMasterKey
+	}
+
+	// Extract, with a zero salt, then a single round of expand, which is all 32 bytes need.
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(masterKey)
+
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(keyID))
+	expand.Write([]byte{1})
+
+	return expand.Sum(nil), nil
+}
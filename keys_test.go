@@ -0,0 +1,123 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Fixtures in testdata were generated with:
+//
+//	openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:2048 -out rsa-pkcs8.pem
+//	openssl rsa -in rsa-pkcs8.pem -traditional -out rsa-pkcs1.pem
+//	openssl rsa -in rsa-pkcs8.pem -pubout -out rsa-pub-pkix.pem
+//	openssl rsa -in rsa-pkcs8.pem -RSAPublicKey_out -out rsa-pub-pkcs1.pem
+//	openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256 -out ec-pkcs8.pem
+//	openssl ec -in ec-pkcs8.pem -out ec-sec1.pem
+//	openssl ec -in ec-pkcs8.pem -pubout -out ec-pub.pem
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal("could not read fixture:", err)
+	}
+
+	return b
+}
+
+func TestParseRSAKeysPEM(t *testing.T) {
+	var pubs []string
+	for _, f := range []string{"rsa-pub-pkix.pem", "rsa-pub-pkcs1.pem"} {
+		pk, err := ParseRSAPublicKeyPEM(readFixture(t, f))
+		if err != nil {
+			t.Fatalf("could not parse %s: %s", f, err)
+		}
+		pubs = append(pubs, pk.N.String())
+	}
+
+	for _, f := range []string{"rsa-pkcs8.pem", "rsa-pkcs1.pem"} {
+		pk, err := ParseRSAPrivateKeyPEM(readFixture(t, f))
+		if err != nil {
+			t.Fatalf("could not parse %s: %s", f, err)
+		}
+		pubs = append(pubs, pk.N.String())
+	}
+
+	for _, n := range pubs {
+		if n != pubs[0] {
+			t.Fatal("parsed keys differ")
+		}
+	}
+}
+
+func TestParseECKeysPEM(t *testing.T) {
+	pub, err := ParseECPublicKeyPEM(readFixture(t, "ec-pub.pem"))
+	if err != nil {
+		t.Fatal("could not parse public key:", err)
+	}
+
+	for _, f := range []string{"ec-pkcs8.pem", "ec-sec1.pem"} {
+		pk, err := ParseECPrivateKeyPEM(readFixture(t, f))
+		if err != nil {
+			t.Fatalf("could not parse %s: %s", f, err)
+		}
+
+		if !pk.PublicKey.Equal(pub) {
+			t.Errorf("%s does not match the public key", f)
+		}
+	}
+}
+
+func TestParseKeysPEM_Errors(t *testing.T) {
+	if _, err := ParseRSAPublicKeyPEM([]byte("not a pem")); err != errNoPEMBlock {
+		t.Error("expected no pem block error. Got:", err)
+	}
+
+	if _, err := ParseRSAPublicKeyPEM(readFixture(t, "ec-pub.pem")); err != errWrongKeyType {
+		t.Error("expected wrong key type error. Got:", err)
+	}
+
+	if _, err := ParseECPublicKeyPEM(readFixture(t, "rsa-pub-pkcs1.pem")); err != errUnknownPEMKey {
+		t.Error("expected unknown key error. Got:", err)
+	}
+
+	if _, err := ParseRSAPrivateKeyPEM(readFixture(t, "ec-sec1.pem")); err != errUnknownPEMKey {
+		t.Error("expected unknown key error. Got:", err)
+	}
+
+	if _, err := ParseECPrivateKeyPEM(readFixture(t, "rsa-pkcs8.pem")); err != errWrongKeyType {
+		t.Error("expected wrong key type error. Got:", err)
+	}
+}
+
+func TestDeriveHmacKey(t *testing.T) {
+	// RFC 5869 test case 3, truncated to 32 bytes.
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	want, _ := hex.DecodeString("8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d")
+
+	if got, err := DeriveHmacKey(ikm, ""); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("unexpected key %x: %v", got, err)
+	}
+
+	k1, _ := DeriveHmacKey([]byte(testSecret), "client-1")
+	k2, _ := DeriveHmacKey([]byte(testSecret), "client-2")
+	if bytes.Equal(k1, k2) {
+		t.Error("expected different key ids to derive different keys")
+	}
+
+	if again, _ := DeriveHmacKey([]byte(testSecret), "client-1"); !bytes.Equal(k1, again) {
+		t.Error("expected derivation to be deterministic")
+	}
+
+	if _, err := DeriveHmacKey(nil, "client-1"); err != errEmptyMasterKey {
+		t.Error("expected empty master key error. Got:", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"errors"
+	"sync"
+)
+
+// KeyStore holds verification keys that can change while the verifier is in use, eg to rotate
+// keys in a long running service. See WithKeyStore.
+type KeyStore interface {
+	// Add adds or replaces the key for keyID.
+	Add(keyID string, key VerificationKey) error
+
+	// Remove removes the key for keyID. Removing an unknown key is an UnknownKeyError.
+	Remove(keyID string) error
+
+	// Lookup returns the key for keyID, if present.
+	Lookup(keyID string) (VerificationKey, bool)
+}
+
+var errInvalidKey = errors.New("invalid key")
+
+// InMemoryKeyStore is a KeyStore that is safe for concurrent use.
+type InMemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]VerificationKey
+}
+
+// NewInMemoryKeyStore returns an empty InMemoryKeyStore.
+func NewInMemoryKeyStore() *InMemoryKeyStore {
+	return &InMemoryKeyStore{keys: make(map[string]VerificationKey)}
+}
+
+// Add adds or replaces the key for keyID.
+func (s *InMemoryKeyStore) Add(keyID string, key VerificationKey) error {
+	if keyID == "" || key.IsZero() {
+		return errInvalidKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[keyID] = key
+	return nil
+}
+
+// AddKeys adds the keys configured by the `WithVerify*` and `WithHmac*` options in opts, eg
+// `store.AddKeys(httpsig.WithVerifyEcdsaP256Sha256("key2", pub))`. Other options are ignored.
+func (s *InMemoryKeyStore) AddKeys(opts ...VerifyOption) error {
+	v := &verifier{keys: make(map[string]verHolder)}
+	for _, o := range opts {
+		o.configureVerify(v)
+	}
+
+	for keyID, vh := range v.keys {
+		if err := s.Add(keyID, VerificationKey{vh: vh}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove removes the key for keyID. Verifications already using the key are unaffected.
+func (s *InMemoryKeyStore) Remove(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[keyID]; !ok {
+		return &UnknownKeyError{KeyID: keyID}
+	}
+
+	delete(s.keys, keyID)
+	return nil
+}
+
+// Lookup returns the key for keyID, if present.
+func (s *InMemoryKeyStore) Lookup(keyID string) (VerificationKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[keyID]
+	return key, ok
+}
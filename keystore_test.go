@@ -0,0 +1,87 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestInMemoryKeyStore(t *testing.T) {
+	secret := []byte(testSecret)
+	store := NewInMemoryKeyStore()
+
+	if err := store.Add("", NewHmacSha256Key(secret)); err == nil {
+		t.Error("expected empty key id to fail")
+	}
+
+	if err := store.Remove("key1"); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key. Got:", err)
+	}
+
+	if err := store.AddKeys(WithHmacSha256("key1", secret), WithClockSkew(0)); err != nil {
+		t.Fatal("could not add keys:", err)
+	}
+
+	req := testReq()
+	signMessage(t, testSigner("key1", signHmacSha256(secret)), req)
+
+	v := testVerifier("other", verifyHmacSha256([]byte("other")))
+	v.store = store
+
+	if _, err := v.Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if err := store.Remove("key1"); err != nil {
+		t.Fatal("could not remove key:", err)
+	}
+
+	if _, err := v.Verify(req); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key after removal. Got:", err)
+	}
+}
+
+// Run with -race to check concurrent changes to the store during verification.
+func TestInMemoryKeyStore_Concurrent(t *testing.T) {
+	secret := []byte(testSecret)
+	store := NewInMemoryKeyStore()
+	if err := store.Add("key1", NewHmacSha256Key(secret)); err != nil {
+		t.Fatal("could not add key:", err)
+	}
+
+	req := testReq()
+	signMessage(t, testSigner("key1", signHmacSha256(secret)), req)
+
+	v := testVerifier("other", verifyHmacSha256([]byte("other")))
+	v.store = store
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				keyID := fmt.Sprintf("rotated-%d-%d", i, j)
+				_ = store.Add(keyID, NewHmacSha256Key(secret))
+				_ = store.Remove(keyID)
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := v.Verify(req); err != nil {
+					t.Error("verification failed:", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WithLogger sets the logger NewVerifyMiddleware logs verification results to. Failures are
+// logged as warnings, with the key id and algorithm of the first signature if it could be
+// parsed, the error, and the remote address of the request. Successes are logged at debug
+// level, with the key id and algorithm that verified the request.
+func WithLogger(logger *slog.Logger) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.logger = logger },
+	}
+}
+
+// NewVerifyMiddlewareWithFallback returns a middleware that verifies requests as with
+// NewVerifyMiddleware, but only logs failures to logger, as with WithLogger, and lets the
+// requests through. It is for observing verification before enforcing it. With
+// WithEnforceAfter, requests that fail verification are rejected from then on.
+func NewVerifyMiddlewareWithFallback(logger *slog.Logger, opts ...VerifyOption) Middleware {
+	opts = append(append([]VerifyOption(nil), opts...), WithLogger(logger), &optImpl{
+		v: func(v *verifier) { v.observe = true },
+	})
+
+	return NewVerifyMiddleware(opts...)
+}
+
+// WithEnforceAfter starts rejecting requests that fail verification at t, for
+// NewVerifyMiddlewareWithFallback. Until then, failures are only logged.
+func WithEnforceAfter(t time.Time) VerifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.enforceAfter = t },
+	}
+}
+
+// observing reports whether failed verifications at now are let through rather than rejected.
+func (v *verifier) observing(now time.Time) bool {
+	return v.observe && (v.enforceAfter.IsZero() || now.Before(v.enforceAfter))
+}
+
+// logResult logs the result of verifying r, if v has a logger.
+func (v *verifier) logResult(r *http.Request, res VerifyResult, err error) {
+	if v.logger == nil {
+		return
+	}
+
+	ctx := r.Context()
+	if err == nil {
+		v.logger.DebugContext(ctx, "verified http message signature",
+			slog.String("key_id", res.KeyID), slog.String("alg", res.Alg))
+		return
+	}
+
+	var attrs []interface{}
+	if info, perr := signatureSummary(r.Header); perr == nil && len(info.Labels) > 0 {
+		if sp := info.PerLabel[info.Labels[0]]; sp != nil {
+			attrs = append(attrs, slog.String("key_id", sp.KeyID), slog.String("alg", sp.Alg))
+		}
+	}
+
+	attrs = append(attrs, slog.Any("error", err), slog.String("remote_addr", r.RemoteAddr))
+	v.logger.WarnContext(ctx, "http message signature verification failed", attrs...)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NonceStore records the nonces of verified signatures, so replayed signatures can be
+// rejected. See WithNonceStore.
+type NonceStore interface {
+	// Mark records nonce as seen, reporting false if it was already marked and not yet
+	// forgotten. The check and the record must be a single atomic operation, so that
+	// concurrent replays of a nonce can't both be accepted.
+	Mark(ctx context.Context, nonce string) (bool, error)
+}
+
+// InMemoryNonceStore is a NonceStore that remembers nonces in memory for a fixed time. It is
+// only suitable for a single verifying process.
+type InMemoryNonceStore struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // nonce to expiry
+
+	done chan struct{}
+	once sync.Once
+
+	// For testing
+	nowFunc func() time.Time
+}
+
+// NewInMemoryNonceStore returns a NonceStore that remembers each nonce for ttl after it is
+// marked. Pick a ttl at least as long as signatures are accepted for, eg with
+// WithCreatedWindow, or replayed signatures will be accepted once their nonce is forgotten.
+//
+// Forgotten nonces are cleaned up in the background, until Close is called.
+func NewInMemoryNonceStore(ttl time.Duration) *InMemoryNonceStore {
+	s := &InMemoryNonceStore{
+		ttl:     ttl,
+		nonces:  make(map[string]time.Time),
+		done:    make(chan struct{}),
+		nowFunc: time.Now,
+	}
+
+	if ttl > 0 {
+		go s.cleanup()
+	}
+
+	return s
+}
+
+// Seen reports whether nonce was marked less than the store's ttl ago.
+func (s *InMemoryNonceStore) Seen(_ context.Context, nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.nonces[nonce]
+	return ok && s.nowFunc().Before(exp), nil
+}
+
+// Mark records nonce as seen, for the store's ttl, reporting false if it was marked less than
+// the ttl ago.
+func (s *InMemoryNonceStore) Mark(_ context.Context, nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.nowFunc()
+	if exp, ok := s.nonces[nonce]; ok && now.Before(exp) {
+		return false, nil
+	}
+
+	s.nonces[nonce] = now.Add(s.ttl)
+	return true, nil
+}
+
+// Close stops the background cleanup of forgotten nonces.
+func (s *InMemoryNonceStore) Close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *InMemoryNonceStore) cleanup() {
+	t := time.NewTicker(s.ttl)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.forget()
+		}
+	}
+}
+
+// forget removes expired nonces.
+func (s *InMemoryNonceStore) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.nowFunc()
+	for n, exp := range s.nonces {
+		if !now.Before(exp) {
+			delete(s.nonces, n)
+		}
+	}
+}
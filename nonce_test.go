@@ -0,0 +1,130 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerify_NonceStore(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	now := time.Unix(1618884475, 0)
+	store := NewInMemoryNonceStore(time.Minute)
+	defer store.Close()
+	store.nowFunc = func() time.Time { return now }
+
+	sign := func(nonce string) *Message {
+		s := testSigner("some-key", signHmacSha256(secret))
+		if nonce != "" {
+			s.nonceFunc = func() string { return nonce }
+		}
+
+		req := testReq()
+		signMessage(t, s, req)
+		return req
+	}
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	v.nonceStore = store
+
+	first := sign("nonce-1")
+
+	if _, err := v.Verify(first); err != nil {
+		t.Fatal("first use of nonce rejected:", err)
+	}
+
+	if _, err := v.Verify(first); !IsReplayedNonceError(err) {
+		t.Error("expected replayed nonce error. Got:", err)
+	}
+
+	if _, err := v.Verify(sign("nonce-2")); err != nil {
+		t.Error("new nonce rejected:", err)
+	}
+
+	// Signatures without a nonce aren't checked.
+	unsigned := sign("")
+	for i := 0; i < 2; i++ {
+		if _, err := v.Verify(unsigned); err != nil {
+			t.Error("signature without nonce rejected:", err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, err := v.Verify(first); err != nil {
+		t.Error("expected nonce to be forgotten after ttl. Got:", err)
+	}
+}
+
+func TestInMemoryNonceStore_Forget(t *testing.T) {
+	now := time.Unix(1618884475, 0)
+	store := NewInMemoryNonceStore(time.Minute)
+	store.Close()
+	store.nowFunc = func() time.Time { return now }
+
+	_, _ = store.Mark(context.Background(), "old")
+	now = now.Add(30 * time.Second)
+	_, _ = store.Mark(context.Background(), "new")
+	now = now.Add(30 * time.Second)
+
+	store.forget()
+
+	if _, ok := store.nonces["old"]; ok {
+		t.Error("expected expired nonce to be removed")
+	}
+
+	if seen, _ := store.Seen(context.Background(), "new"); !seen {
+		t.Error("expected unexpired nonce to be kept")
+	}
+}
+
+func TestVerify_NonceStoreConcurrentReplay(t *testing.T) {
+	const n = 20
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	store := NewInMemoryNonceStore(time.Minute)
+	defer store.Close()
+
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.nonceFunc = func() string { return "nonce-1" }
+
+	req := testReq()
+	signMessage(t, s, req)
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	v.nonceStore = store
+
+	var wg sync.WaitGroup
+	var accepted, replayed int32
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			_, err := v.Verify(req)
+			switch {
+			case err == nil:
+				atomic.AddInt32(&accepted, 1)
+			case IsReplayedNonceError(err):
+				atomic.AddInt32(&replayed, 1)
+			default:
+				t.Error("unexpected error:", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if accepted != 1 || replayed != n-1 {
+		t.Errorf("expected one of %d concurrent uses of a nonce accepted. Accepted: %d, replayed: %d", n, accepted, replayed)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build otel
+
+package httpsig
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracing traces signing with a `httpsig.sign` span, and verification with a
+// `httpsig.verify` span, as children of the span in the request's context. Spans have the
+// `httpsig.key_id` and `httpsig.alg` attributes, as string slices when signing with more than
+// one key, and failed verifications have a `httpsig.error_type` of `not_signed`, `unknown_key`,
+// `expired`, `malformed` or `invalid`.
+//
+// WithTracing is only available when building with the otel tag, eg `go build -tags otel`, and
+// go.opentelemetry.io/otel in your go.mod.
+func WithTracing(t trace.Tracer) SignOrVerifyOption {
+	ot := otelTracer{t: t}
+
+	return &optImpl{
+		s: func(s *signer) { s.tracers = append(s.tracers, ot) },
+		v: func(v *verifier) { v.tracers = append(v.tracers, ot) },
+	}
+}
+
+type otelTracer struct {
+	t trace.Tracer
+}
+
+func (ot otelTracer) start(ctx context.Context, name string) (context.Context, span) {
+	ctx, sp := ot.t.Start(ctx, name)
+	return ctx, otelSpan{sp: sp}
+}
+
+type otelSpan struct {
+	sp trace.Span
+}
+
+func (s otelSpan) keys(keyIDs, algs []string) {
+	if len(keyIDs) == 1 {
+		s.sp.SetAttributes(attribute.String("httpsig.key_id", keyIDs[0]), attribute.String("httpsig.alg", algs[0]))
+		return
+	}
+
+	s.sp.SetAttributes(attribute.StringSlice("httpsig.key_id", keyIDs), attribute.StringSlice("httpsig.alg", algs))
+}
+
+func (s otelSpan) fail(err error) {
+	s.sp.RecordError(err)
+	s.sp.SetStatus(codes.Error, err.Error())
+	s.sp.SetAttributes(attribute.String("httpsig.error_type", errorReason(err)))
+}
+
+func (s otelSpan) end() { s.sp.End() }
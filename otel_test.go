@@ -0,0 +1,76 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build otel
+
+package httpsig
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	tr := tp.Tracer("httpsig-test")
+
+	ctx, parent := tr.Start(context.Background(), "parent")
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil).WithContext(ctx)
+	if err := SignRequest(req, WithHmacSha256("test-key", []byte(testSecret)), WithTracing(tr)); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	h := NewVerifyMiddleware(WithHmacSha256("test-key", []byte(testSecret)), WithTracing(tr)).
+		Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil).WithContext(ctx))
+	parent.End()
+
+	spans := exp.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans. Got: %d", len(spans))
+	}
+
+	attrs := func(i int) map[attribute.Key]string {
+		m := make(map[attribute.Key]string)
+		for _, a := range spans[i].Attributes {
+			m[a.Key] = a.Value.Emit()
+		}
+		return m
+	}
+
+	tcs := []struct {
+		name  string
+		attrs map[attribute.Key]string
+	}{
+		{"httpsig.sign", map[attribute.Key]string{"httpsig.key_id": "test-key", "httpsig.alg": ""}},
+		{"httpsig.verify", map[attribute.Key]string{"httpsig.key_id": "test-key", "httpsig.alg": "hmac-sha256"}},
+		{"httpsig.verify", map[attribute.Key]string{"httpsig.error_type": "not_signed"}},
+	}
+
+	for i, tc := range tcs {
+		if spans[i].Name != tc.name {
+			t.Errorf("span %d: expected name %q. Got: %q", i, tc.name, spans[i].Name)
+		}
+
+		if spans[i].Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d: expected a child of the request's span", i)
+		}
+
+		got := attrs(i)
+		for k, v := range tc.attrs {
+			if got[k] != v {
+				t.Errorf("span %d: expected %s=%q. Got: %q", i, k, v, got[k])
+			}
+		}
+	}
+}
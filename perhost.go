@@ -0,0 +1,89 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// HostSigningRule signs requests to the hosts matching Host with Options, for
+// NewPerHostSignTransport.
+type HostSigningRule struct {
+	// Host is an exact host, eg `api.example.com`, or a pattern as with path.Match, eg
+	// `*.example.com`. Hosts are matched with and without their port, ignoring case.
+	Host string
+
+	// Options configure signing, as for NewSignTransport.
+	Options []SigningOption
+
+	// Used for requests matching no other rule.
+	fallback bool
+}
+
+// WithDefaultSigning returns the rule for requests to hosts matching no other rule.
+func WithDefaultSigning(opts ...SigningOption) HostSigningRule {
+	return HostSigningRule{Options: opts, fallback: true}
+}
+
+// matches reports whether the rule applies to host.
+func (hr HostSigningRule) matches(host string) bool {
+	host = strings.ToLower(host)
+	pattern := strings.ToLower(hr.Host)
+
+	if ok, _ := path.Match(pattern, host); ok {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		ok, _ := path.Match(pattern, h)
+		return ok
+	}
+
+	return false
+}
+
+// NewPerHostSignTransport returns a client transport that wraps transport, signing each
+// request as with NewSignTransport, with the options of the rule matching `req.URL.Host`. Of
+// several matching rules, the one with the longest Host wins. Requests matching no rule are
+// signed with the rule from WithDefaultSigning, or sent unsigned without one.
+func NewPerHostSignTransport(transport http.RoundTripper, rules ...HostSigningRule) http.RoundTripper {
+	var hosts []HostSigningRule
+	var signers []http.RoundTripper
+	var fallback http.RoundTripper
+	for _, hr := range rules {
+		if hr.fallback {
+			fallback = NewSignTransport(transport, hr.Options...)
+			continue
+		}
+
+		if _, err := path.Match(hr.Host, ""); err != nil {
+			return rt(func(*http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("invalid host pattern %q: %w", hr.Host, err)
+			})
+		}
+
+		hosts = append(hosts, hr)
+		signers = append(signers, NewSignTransport(transport, hr.Options...))
+	}
+
+	return rt(func(r *http.Request) (*http.Response, error) {
+		t, best := fallback, -1
+		for i, hr := range hosts {
+			if len(hr.Host) > best && hr.matches(r.URL.Host) {
+				t, best = signers[i], len(hr.Host)
+			}
+		}
+
+		if t == nil {
+			t = transport
+		}
+
+		return t.RoundTrip(r)
+	})
+}
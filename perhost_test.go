@@ -0,0 +1,73 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPerHostSignTransport(t *testing.T) {
+	ct := &captureTransport{}
+	client := http.Client{Transport: NewPerHostSignTransport(ct,
+		HostSigningRule{Host: "*.example.com", Options: []SigningOption{WithHmacSha256("wildcard", []byte("wildcard secret"))}},
+		HostSigningRule{Host: "api.example.com", Options: []SigningOption{WithHmacSha256("api", []byte("api secret"))}},
+		HostSigningRule{Host: "other.test", Options: []SigningOption{WithHmacSha256("other", []byte("other secret"))}},
+	)}
+
+	tcs := []struct {
+		url    string
+		keyID  string
+		secret string
+	}{
+		{"http://api.example.com/", "api", "api secret"},
+		{"http://API.example.com:8080/", "api", "api secret"},
+		{"http://www.example.com/", "wildcard", "wildcard secret"},
+		{"http://other.test/", "other", "other secret"},
+		{"http://unknown.test/", "", ""},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.url, func(t *testing.T) {
+			resp, err := client.Get(tc.url)
+			if err != nil {
+				t.Fatal("request failed:", err)
+			}
+			resp.Body.Close()
+
+			if tc.keyID == "" {
+				if _, ok := ct.req.Header["Signature"]; ok {
+					t.Error("expected an unsigned request")
+				}
+				return
+			}
+
+			req := httptest.NewRequest("GET", tc.url, nil)
+			req.Header = ct.req.Header
+			if err := VerifyRequest(req, WithHmacSha256(tc.keyID, []byte(tc.secret))); err != nil {
+				t.Error("verification failed:", err)
+			}
+		})
+	}
+
+	client.Transport = NewPerHostSignTransport(ct, WithDefaultSigning(WithHmacSha256("default", []byte("default secret"))))
+	resp, err := client.Get("http://unknown.test/")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	req := httptest.NewRequest("GET", "http://unknown.test/", nil)
+	req.Header = ct.req.Header
+	if err := VerifyRequest(req, WithHmacSha256("default", []byte("default secret"))); err != nil {
+		t.Error("verification with the default failed:", err)
+	}
+
+	client.Transport = NewPerHostSignTransport(ct, HostSigningRule{Host: "[bad"})
+	if _, err := client.Get("http://unknown.test/"); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build prometheus
+
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewInstrumentedVerifyMiddleware is NewVerifyMiddleware, but records metrics for each
+// verification with reg:
+//
+//	httpsig_verify_total{status="success"|"error"}
+//	httpsig_verify_errors_total{reason="unknown_key"|"invalid"|"expired"|"malformed"}
+//	httpsig_verify_duration_seconds
+//
+// Unsigned requests are counted as malformed. Metrics already registered with reg, eg by
+// another instrumented middleware, are shared.
+//
+// NewInstrumentedVerifyMiddleware is only available when building with the prometheus tag, eg
+// `go build -tags prometheus`, and github.com/prometheus/client_golang in your go.mod.
+func NewInstrumentedVerifyMiddleware(reg prometheus.Registerer, opts ...VerifyOption) Middleware {
+	pt := promTracer{
+		total: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "httpsig_verify_total",
+			Help: "Signature verifications, by status.",
+		}, []string{"status"})),
+		errors: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "httpsig_verify_errors_total",
+			Help: "Failed signature verifications, by reason.",
+		}, []string{"reason"})),
+		duration: register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "httpsig_verify_duration_seconds",
+			Help:    "Time taken to verify signatures.",
+			Buckets: prometheus.DefBuckets,
+		})),
+	}
+
+	opts = append(opts[:len(opts):len(opts)], &optImpl{
+		v: func(v *verifier) { v.tracers = append(v.tracers, pt) },
+	})
+
+	return NewVerifyMiddleware(opts...)
+}
+
+// NewInstrumentedSignTransport is NewSignTransport, but records metrics for each signed request
+// with reg:
+//
+//	httpsig_sign_total{status="success"|"error"}
+//	httpsig_sign_duration_seconds
+//
+// Metrics already registered with reg, eg by another instrumented transport, are shared.
+//
+// NewInstrumentedSignTransport is only available when building with the prometheus tag, eg
+// `go build -tags prometheus`, and github.com/prometheus/client_golang in your go.mod.
+func NewInstrumentedSignTransport(reg prometheus.Registerer, transport http.RoundTripper, opts ...SigningOption) http.RoundTripper {
+	pt := promTracer{
+		total: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "httpsig_sign_total",
+			Help: "Signed requests, by status.",
+		}, []string{"status"})),
+		duration: register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "httpsig_sign_duration_seconds",
+			Help:    "Time taken to sign requests.",
+			Buckets: prometheus.DefBuckets,
+		})),
+	}
+
+	opts = append(opts[:len(opts):len(opts)], &optImpl{
+		s: func(s *signer) { s.tracers = append(s.tracers, pt) },
+	})
+
+	return NewSignTransport(transport, opts...)
+}
+
+// register registers c with reg, returning the collector already registered in its place, if
+// there is one.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+
+	return c
+}
+
+// promTracer records metrics for spans, rather than tracing them.
+type promTracer struct {
+	total    *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration prometheus.Histogram
+}
+
+func (pt promTracer) start(ctx context.Context, name string) (context.Context, span) {
+	return ctx, &promSpan{pt: pt, start: time.Now()}
+}
+
+type promSpan struct {
+	pt    promTracer
+	start time.Time
+	err   error
+}
+
+func (s *promSpan) keys(keyIDs, algs []string) {}
+
+func (s *promSpan) fail(err error) { s.err = err }
+
+func (s *promSpan) end() {
+	s.pt.duration.Observe(time.Since(s.start).Seconds())
+
+	if s.err == nil {
+		s.pt.total.WithLabelValues("success").Inc()
+		return
+	}
+
+	s.pt.total.WithLabelValues("error").Inc()
+	if s.pt.errors != nil {
+		reason := errorReason(s.err)
+		if reason == "not_signed" {
+			reason = "malformed"
+		}
+		s.pt.errors.WithLabelValues(reason).Inc()
+	}
+}
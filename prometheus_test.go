@@ -0,0 +1,82 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build prometheus
+
+package httpsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedVerifyMiddleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := NewInstrumentedVerifyMiddleware(reg, WithHmacSha256("test-key", []byte(testSecret))).
+		Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	serve := func(keyID string) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if keyID != "" {
+			if err := SignRequest(req, WithHmacSha256(keyID, []byte(testSecret))); err != nil {
+				t.Fatal("signing failed:", err)
+			}
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("test-key")
+	serve("test-key")
+	serve("other-key")
+	serve("")
+
+	// A second middleware shares the metrics.
+	NewInstrumentedVerifyMiddleware(reg, WithHmacSha256("test-key", []byte(testSecret)))
+
+	want := `
+# HELP httpsig_verify_errors_total Failed signature verifications, by reason.
+# TYPE httpsig_verify_errors_total counter
+httpsig_verify_errors_total{reason="malformed"} 1
+httpsig_verify_errors_total{reason="unknown_key"} 1
+# HELP httpsig_verify_total Signature verifications, by status.
+# TYPE httpsig_verify_total counter
+httpsig_verify_total{status="error"} 2
+httpsig_verify_total{status="success"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "httpsig_verify_total", "httpsig_verify_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(reg, "httpsig_verify_duration_seconds"); n != 1 {
+		t.Errorf("expected a duration histogram. Got: %d", n)
+	}
+}
+
+func TestInstrumentedSignTransport(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer s.Close()
+
+	reg := prometheus.NewRegistry()
+	c := &http.Client{Transport: NewInstrumentedSignTransport(reg, http.DefaultTransport, WithHmacSha256("test-key", []byte(testSecret)))}
+
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	want := `
+# HELP httpsig_sign_total Signed requests, by status.
+# TYPE httpsig_sign_total counter
+httpsig_sign_total{status="success"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "httpsig_sign_total"); err != nil {
+		t.Error(err)
+	}
+}
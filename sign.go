@@ -5,24 +5,29 @@
 package httpsig
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/ghoti143/httpsig/internal/sfv"
 )
 
 type sigImpl struct {
 	w    io.Writer
-	sign func() []byte
+	sign func() ([]byte, error)
 }
 
 type sigHolder struct {
@@ -34,108 +39,478 @@ type signer struct {
 	headers []string
 	keys    map[string]sigHolder
 
+	// Sign exactly headers, failing when one is missing, rather than adding defaults and
+	// skipping headers that are unset.
+	exact bool
+
+	// Sign every header of each message instead of headers, with WithSignAllHeaders.
+	allHeaders bool
+
+	// Components signed first, in order, with WithComponentOrder.
+	order []string
+
+	// Skip missing headers, even if listed exactly or in order.
+	skipMissing bool
+
+	// A configuration error, returned when signing.
+	err error
+
+	// If set, used to label signatures instead of `sig`.
+	label string
+
+	// Include the created parameter in signatures.
+	created bool
+
+	// If set, called for each signed message to produce the nonce parameter.
+	nonceFunc func() string
+
+	// If non-zero, signatures expire this long after signing.
+	expires time.Duration
+
+	// If set, used as the @authority instead of the message's.
+	authority string
+
+	// Set a Content-Digest header on requests, and sign it.
+	contentDigest bool
+
+	// Recover and send the full request body, even if it was partially read.
+	bufferBody bool
+
+	// Retry requests rejected with an Accept-Signature header, signed as it asks.
+	acceptSignature bool
+
+	// If set, checks or normalizes signed header values, in place of rejecting newlines.
+	sanitize func(name, value string) (string, error)
+
+	// Encode signatures with base64url rather than base64.
+	base64URL bool
+
+	// Let NewAutoRetrySignTransport retry POST requests.
+	retryPost bool
+
+	// Further signatures, each with their own label and configuration.
+	additional []additionalSignature
+
+	// If set, keep the message's existing signatures, relabelling one that clashes with a new
+	// signature to this.
+	forwardLabel string
+
+	// Each traces signing.
+	tracers []tracer
+
+	// Derived components outside the standard.
+	custom customComponents
+
 	// For testing
 	nowFunc func() time.Time
 }
 
-func (s *signer) Sign(msg *message) (http.Header, error) {
-	var b bytes.Buffer
+type additionalSignature struct {
+	label string
+	s     *signer
+}
+
+func (s *signer) Sign(msg *Message) (http.Header, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
 
-	var items []string
+	var inputs, sigs sfv.Dictionary
+	seen := make(map[string]bool)
 
-	// canonicalize headers
-	for _, h := range s.headers {
-		// Skip unset headers
-		if len(h) > 0 && h[0] != '@' && len(msg.Header.Values(h)) == 0 {
-			continue
+	add := func(label string, input sfv.InnerList, sig []byte) error {
+		if seen[label] {
+			return fmt.Errorf("duplicate signature label %q", label)
 		}
+		seen[label] = true
 
-		// handle specialty components, section 2.3
-		var err error
-		switch h {
-		case "@method":
-			err = canonicalizeMethod(&b, msg.Method)
-		case "@path":
-			err = canonicalizePath(&b, msg.URL.Path)
-		case "@query":
-			err = canonicalizeQuery(&b, msg.URL.RawQuery)
-		case "@authority":
-			err = canonicalizeAuthority(&b, msg.Authority)
-		default:
-			// handle default (header) components
-			err = canonicalizeHeader(&b, h, msg.Header)
-		}
+		inputs = append(inputs, sfv.DictMember{Key: label, Value: input})
+		sigs = append(sigs, sfv.DictMember{Key: label, Value: sfv.Item{Value: sig}})
+		return nil
+	}
 
+	// Sort the key ids so labels are stable between requests.
+	keyIDs := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keyIDs = append(keyIDs, k)
+	}
+	sort.Strings(keyIDs)
+
+	for i, k := range keyIDs {
+		input, sig, err := s.signKey(msg, k)
 		if err != nil {
 			return nil, err
 		}
 
-		items = append(items, h)
+		label := fmt.Sprintf("sig%d", i+1) // 1 indexed icky
+		if s.label != "" {
+			label = s.label
+			if len(keyIDs) > 1 {
+				label = fmt.Sprintf("%s%d", s.label, i+1)
+			}
+		}
+
+		if err := add(label, input, sig); err != nil {
+			return nil, err
+		}
 	}
 
-	now := s.nowFunc()
+	for _, a := range s.additional {
+		if len(a.s.keys) != 1 {
+			return nil, fmt.Errorf("additional signature %q must have exactly one key", a.label)
+		}
 
-	sps := make(map[string]string)
-	sigs := make(map[string]string)
-	i := 1 // 1 indexed icky
-	for k, si := range s.keys {
-		sp := &signatureParams{
-			items:   items,
-			keyID:   k,
-			created: now,
-			alg:     si.alg,
+		if a.s.err != nil {
+			return nil, a.s.err
 		}
-		sps[fmt.Sprintf("sig%d", i)] = sp.canonicalize()
 
-		signer := si.signer()
-		if _, err := signer.w.Write(b.Bytes()); err != nil {
-			return nil, err
+		for k := range a.s.keys {
+			input, sig, err := a.s.signKey(msg, k)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := add(a.label, input, sig); err != nil {
+				return nil, err
+			}
 		}
+	}
 
-		if err := canonicalizeSignatureParams(signer.w, sp); err != nil {
+	if s.forwardLabel != "" {
+		fwdInputs, fwdSigs, err := s.forwarded(msg.Header, seen)
+		if err != nil {
 			return nil, err
 		}
 
-		sigs[fmt.Sprintf("sig%d", i)] = base64.StdEncoding.EncodeToString(signer.sign())
-
-		i++
+		inputs = append(fwdInputs, inputs...)
+		sigs = append(fwdSigs, sigs...)
 	}
 
-	// for each configured key id,
-	// canonicalize signing options appended to byte slice
-	// create signature
+	si, err := sfv.SerializeDictionary(inputs)
+	if err != nil {
+		return nil, err
+	}
 
-	// add new headers with params for all key ids and signatures
+	serializeSig := serializeSFByteSequence
+	if s.base64URL {
+		serializeSig = func(b []byte) string { return encodeByteSequence(b, base64.URLEncoding) }
+	}
 
-	// TODO: make this stable
-	var parts []string
-	var sigparts []string
-	for k, v := range sps {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
-		sigparts = append(sigparts, fmt.Sprintf("%s=:%s:", k, sigs[k]))
+	sv, err := serializeSignatures(sigs, serializeSig)
+	if err != nil {
+		return nil, err
 	}
 
 	hdr := make(http.Header)
-	hdr.Set("signature-input", strings.Join(parts, ", "))
-	hdr.Set("signature", strings.Join(sigparts, ", "))
+	hdr.Set("signature-input", si)
+	hdr.Set("signature", sv)
 
 	return hdr, nil
 }
 
+// serializeSignatures serializes sigs as a `Signature` header, with each signature encoded by
+// serializeSig. This allows base64url signatures, eg `sig1=:a-_b:`, which aren't a valid
+// structured field byte sequence, so aren't supported by sfv.
+func serializeSignatures(sigs sfv.Dictionary, serializeSig func([]byte) string) (string, error) {
+	parts := make([]string, 0, len(sigs))
+	for _, m := range sigs {
+		it, ok := m.Value.(sfv.Item)
+		if !ok {
+			return "", errMalformedSignature
+		}
+
+		sig, ok := it.Value.([]byte)
+		if !ok {
+			return "", errMalformedSignature
+		}
+
+		parts = append(parts, m.Key+"="+serializeSig(sig))
+	}
+
+	return strings.Join(parts, ", "), nil
+}
+
+// forwarded returns the existing signatures of hdr, to be sent along with new signatures with
+// the labels in seen. An existing signature with the same label as a new one is relabelled
+// with s.forwardLabel.
+func (s *signer) forwarded(hdr http.Header, seen map[string]bool) (sfv.Dictionary, sfv.Dictionary, error) {
+	if len(hdr.Values("Signature")) == 0 {
+		return nil, nil, nil
+	}
+
+	inputs, err := sfv.ParseDictionary(strings.Join(hdr.Values("Signature-Input"), ", "))
+	if err != nil {
+		return nil, nil, errMalformedSignature
+	}
+
+	sigs, err := sfv.ParseDictionary(strings.Join(hdr.Values("Signature"), ", "))
+	if err != nil || len(sigs) != len(inputs) {
+		return nil, nil, errMalformedSignature
+	}
+
+	var fwdInputs, fwdSigs sfv.Dictionary
+	for _, in := range inputs {
+		sig, ok := sigs.Get(in.Key)
+		if !ok {
+			return nil, nil, errMalformedSignature
+		}
+
+		label := in.Key
+		if seen[label] {
+			label = s.forwardLabel
+		}
+
+		if seen[label] {
+			return nil, nil, fmt.Errorf("duplicate signature label %q", label)
+		}
+		seen[label] = true
+
+		fwdInputs = append(fwdInputs, sfv.DictMember{Key: label, Value: in.Value})
+		fwdSigs = append(fwdSigs, sfv.DictMember{Key: label, Value: sig})
+	}
+
+	return fwdInputs, fwdSigs, nil
+}
+
+// hopByHopHeaders aren't signed with WithSignAllHeaders, as proxies may change them.
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// allHeaders returns the components signed for msg with WithSignAllHeaders: `@method`, `@path`
+// and `@authority` for requests, or `@status` for responses, then every header that isn't hop
+// by hop or a signature, sorted by name. `host` is left to `@authority`.
+func allHeaders(msg *Message) []string {
+	components := []string{"@method", "@path", "@authority"}
+	if msg.StatusCode != 0 {
+		components = []string{"@status"}
+	}
+
+	skip := make(map[string]bool)
+	for _, v := range msg.Header.Values("Connection") {
+		for _, h := range strings.Split(v, ",") {
+			skip[strings.ToLower(strings.TrimSpace(h))] = true
+		}
+	}
+
+	var names []string
+	for h := range msg.Header {
+		h = strings.ToLower(h)
+		if hopByHopHeaders[h] || skip[h] || h == "host" || h == "signature" || h == "signature-input" {
+			continue
+		}
+		names = append(names, h)
+	}
+	sort.Strings(names)
+
+	return append(components, names...)
+}
+
+// orderComponents returns order, followed by the components of headers not in order.
+func orderComponents(order, headers []string) []string {
+	listed := make(map[string]bool, len(order))
+	for _, h := range order {
+		if c, err := parseComponent(h); err == nil {
+			listed[c.id()] = true
+		}
+	}
+
+	out := append([]string(nil), order...)
+	for _, h := range headers {
+		if c, err := parseComponent(h); err != nil || !listed[c.id()] {
+			out = append(out, h)
+		}
+	}
+
+	return out
+}
+
+// signKey returns the signature input and the signature of msg using keyID.
+func (s *signer) signKey(msg *Message, keyID string) (sfv.InnerList, []byte, error) {
+	if s.authority != "" {
+		m := *msg
+		m.Authority = s.authority
+		msg = &m
+	}
+
+	headers := s.headers
+	if s.allHeaders {
+		headers = allHeaders(msg)
+	}
+
+	if len(s.order) > 0 {
+		headers = orderComponents(s.order, headers)
+	}
+
+	var items []string
+
+	for i, h := range headers {
+		c, err := parseComponent(h)
+		if err != nil {
+			return sfv.InnerList{}, nil, err
+		}
+
+		if err := s.custom.validate(h); err != nil {
+			return sfv.InnerList{}, nil, err
+		}
+
+		// Skip unset headers, unless required
+		required := s.exact || i < len(s.order)
+		if (!required || s.skipMissing) && c.name[0] != '@' && len(msg.Header.Values(c.name)) == 0 {
+			continue
+		}
+
+		items = append(items, c.id())
+	}
+
+	sp := s.params(keyID, items)
+
+	base := getBuffer()
+	defer putBuffer(base)
+
+	msg, err := sanitizeHeaders(msg, items, s.sanitize)
+	if err != nil {
+		return sfv.InnerList{}, nil, err
+	}
+
+	if err := writeSigningBase(base, sp, s.custom.on(msg)); err != nil {
+		return sfv.InnerList{}, nil, err
+	}
+
+	sig, err := s.signBase(keyID, base.Bytes())
+	if err != nil {
+		return sfv.InnerList{}, nil, err
+	}
+
+	il, err := sp.innerList()
+	if err != nil {
+		return sfv.InnerList{}, nil, err
+	}
+
+	return il, sig, nil
+}
+
+// params returns the parameters of a new signature by keyID covering items.
+func (s *signer) params(keyID string, items []string) *SignatureParams {
+	now := s.nowFunc()
+
+	var created *time.Time
+	if s.created {
+		created = &now
+	}
+
+	var expires *time.Time
+	if s.expires != 0 {
+		e := now.Add(s.expires)
+		expires = &e
+	}
+
+	var nonce string
+	if s.nonceFunc != nil {
+		nonce = s.nonceFunc()
+	}
+
+	return &SignatureParams{
+		Items:   items,
+		KeyID:   keyID,
+		Created: created,
+		Expires: expires,
+		Alg:     s.keys[keyID].alg,
+		Nonce:   nonce,
+	}
+}
+
+// signBase returns the signature of base using keyID.
+func (s *signer) signBase(keyID string, base []byte) ([]byte, error) {
+	signer := s.keys[keyID].signer()
+	if _, err := signer.w.Write(base); err != nil {
+		return nil, err
+	}
+
+	return signer.sign()
+}
+
+var errInvalidLabel = errors.New("invalid signature label")
+
+// validateLabel returns an error if label can't be used as a structured field dictionary key,
+// as signature labels are.
+func validateLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("%w: %q", errInvalidLabel, label)
+	}
+
+	// RFC 8941 section 3.2: a lowercase letter or *, then lowercase letters, digits, _, -, ., or *
+	for i, r := range label {
+		switch {
+		case r >= 'a' && r <= 'z', r == '*':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.'):
+		default:
+			return fmt.Errorf("%w: %q", errInvalidLabel, label)
+		}
+	}
+
+	return nil
+}
+
 func signRsaPssSha512(pk *rsa.PrivateKey) sigHolder {
 	return sigHolder{
 		alg: "rsa-pss-sha512",
 		signer: func() sigImpl {
-			h := sha256.New()
+			h := sha512Pool.get()
+
+			return sigImpl{
+				w: h,
+				sign: func() ([]byte, error) {
+					b := h.Sum(nil)
+					sha512Pool.put(h)
+
+					return rsa.SignPSS(rand.Reader, pk, crypto.SHA512, b, nil)
+				},
+			}
+		},
+	}
+}
+
+func signRsaPkcs1Sha256(pk *rsa.PrivateKey) sigHolder {
+	return sigHolder{
+		alg: "rsa-pkcs1-sha256",
+		signer: func() sigImpl {
+			h := sha256Pool.get()
+
+			return sigImpl{
+				w: h,
+				sign: func() ([]byte, error) {
+					b := h.Sum(nil)
+					sha256Pool.put(h)
+
+					return rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA256, b)
+				},
+			}
+		},
+	}
+}
+
+func signRsaPkcs1Sha512(pk *rsa.PrivateKey) sigHolder {
+	return sigHolder{
+		alg: "rsa-pkcs1-sha512",
+		signer: func() sigImpl {
+			h := sha512Pool.get()
 
 			return sigImpl{
 				w: h,
-				sign: func() []byte {
+				sign: func() ([]byte, error) {
 					b := h.Sum(nil)
+					sha512Pool.put(h)
 
-					// TODO: might have to deal with this error :)
-					sig, _ := rsa.SignPSS(rand.Reader, pk, crypto.SHA512, b, nil)
-					return sig
+					return rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA512, b)
 				},
 			}
 		},
@@ -146,16 +521,53 @@ func signEccP256(pk *ecdsa.PrivateKey) sigHolder {
 	return sigHolder{
 		alg: "ecdsa-p256-sha256",
 		signer: func() sigImpl {
-			h := sha256.New()
+			h := sha256Pool.get()
+
+			return sigImpl{
+				w: h,
+				sign: func() ([]byte, error) {
+					b := h.Sum(nil)
+					sha256Pool.put(h)
+
+					return ecdsa.SignASN1(rand.Reader, pk, b)
+				},
+			}
+		},
+	}
+}
+
+func signEccP384(pk *ecdsa.PrivateKey) sigHolder {
+	return sigHolder{
+		alg: "ecdsa-p384-sha384",
+		signer: func() sigImpl {
+			h := sha384Pool.get()
+
+			return sigImpl{
+				w: h,
+				sign: func() ([]byte, error) {
+					b := h.Sum(nil)
+					sha384Pool.put(h)
+
+					return ecdsa.SignASN1(rand.Reader, pk, b)
+				},
+			}
+		},
+	}
+}
+
+func signEccP521(pk *ecdsa.PrivateKey) sigHolder {
+	return sigHolder{
+		alg: "ecdsa-p521-sha512",
+		signer: func() sigImpl {
+			h := sha512Pool.get()
 
 			return sigImpl{
 				w: h,
-				sign: func() []byte {
+				sign: func() ([]byte, error) {
 					b := h.Sum(nil)
+					sha512Pool.put(h)
 
-					// TODO: might have to deal with this error :)
-					sig, _ := ecdsa.SignASN1(rand.Reader, pk, b)
-					return sig
+					return ecdsa.SignASN1(rand.Reader, pk, b)
 				},
 			}
 		},
@@ -163,14 +575,60 @@ func signEccP256(pk *ecdsa.PrivateKey) sigHolder {
 }
 
 func signHmacSha256(secret []byte) sigHolder {
+	hp := newHashPool(func() hash.Hash { return hmac.New(sha256.New, secret) })
+
 	// TODO: add alg description
 	return sigHolder{
 		signer: func() sigImpl {
-			h := hmac.New(sha256.New, secret)
+			h := hp.get()
+
+			return sigImpl{
+				w: h,
+				sign: func() ([]byte, error) {
+					sig := h.Sum(nil)
+					hp.put(h)
+					return sig, nil
+				},
+			}
+		},
+	}
+}
+
+func signHmacSha384(secret []byte) sigHolder {
+	hp := newHashPool(func() hash.Hash { return hmac.New(sha512.New384, secret) })
+
+	return sigHolder{
+		alg: "hmac-sha384",
+		signer: func() sigImpl {
+			h := hp.get()
 
 			return sigImpl{
-				w:    h,
-				sign: func() []byte { return h.Sum(nil) },
+				w: h,
+				sign: func() ([]byte, error) {
+					sig := h.Sum(nil)
+					hp.put(h)
+					return sig, nil
+				},
+			}
+		},
+	}
+}
+
+func signHmacSha512(secret []byte) sigHolder {
+	hp := newHashPool(func() hash.Hash { return hmac.New(sha512.New, secret) })
+
+	return sigHolder{
+		alg: "hmac-sha512",
+		signer: func() sigImpl {
+			h := hp.get()
+
+			return sigImpl{
+				w: h,
+				sign: func() ([]byte, error) {
+					sig := h.Sum(nil)
+					hp.put(h)
+					return sig, nil
+				},
 			}
 		},
 	}
@@ -0,0 +1,397 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign_Created(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	t.Run("absent", func(t *testing.T) {
+		s := testSigner("some-key", signHmacSha256(secret))
+		s.created = false
+
+		req := testReq()
+		signMessage(t, s, req)
+
+		if strings.Contains(req.Header.Get("Signature-Input"), "created") {
+			t.Error("unexpected created param. Got:", req.Header.Get("Signature-Input"))
+		}
+
+		// Without created, the window can't be checked, so it is not enforced.
+		v := testVerifier("some-key", verifyHmacSha256(secret))
+		v.createdWindow = time.Minute
+		v.nowFunc = func() time.Time { return time.Unix(1618884475, 0).Add(time.Hour) }
+
+		if _, err := v.Verify(req); err != nil {
+			t.Error("verification failed:", err)
+		}
+	})
+
+	t.Run("present", func(t *testing.T) {
+		req := testReq()
+		signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+
+		if !strings.Contains(req.Header.Get("Signature-Input"), ";created=1618884475") {
+			t.Error("missing created param. Got:", req.Header.Get("Signature-Input"))
+		}
+	})
+
+	t.Run("in the future", func(t *testing.T) {
+		// The signer's clock runs ahead of the verifier's.
+		s := testSigner("some-key", signHmacSha256(secret))
+		s.nowFunc = func() time.Time { return time.Unix(1618884475, 0).Add(30 * time.Second) }
+
+		req := testReq()
+		signMessage(t, s, req)
+
+		v := testVerifier("some-key", verifyHmacSha256(secret))
+		v.createdWindow = time.Minute
+		if _, err := v.Verify(req); err != nil {
+			t.Error("verification failed within window:", err)
+		}
+
+		v.createdWindow = 10 * time.Second
+		if _, err := v.Verify(req); !IsCreatedOutsideWindowError(err) {
+			t.Error("expected created outside window. Got:", err)
+		}
+	})
+}
+
+func TestSign_Expires(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	now := time.Unix(1618884475, 0)
+	clock := func() time.Time { return now }
+
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.expires = time.Second
+	s.nowFunc = clock
+
+	req := testReq()
+	signMessage(t, s, req)
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	v.nowFunc = clock
+
+	if _, err := v.Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	now = now.Add(2 * time.Second)
+
+	if _, err := v.Verify(req); !IsSignatureExpiredError(err) {
+		t.Error("expected expired signature. Got:", err)
+	}
+}
+
+func TestSign_AuthorityOverride(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.authority = "api.example.com"
+
+	// Sent to the proxy, but verified by the service behind it.
+	req := testReq()
+	req.Authority = "proxy.internal:8080"
+	signMessage(t, s, req)
+
+	req.Authority = "api.example.com"
+	if _, err := testVerifier("some-key", verifyHmacSha256(secret)).Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestSignTransport_SigningComponents(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	sign := func(opts ...SigningOption) (*http.Request, error) {
+		ct := &captureTransport{}
+		client := http.Client{Transport: NewSignTransport(ct, append(opts, WithHmacSha256("key1", secret))...)}
+
+		req, err := http.NewRequest("GET", "http://example.com/foo?pet=dog", nil)
+		if err != nil {
+			t.Fatal("could not create request:", err)
+		}
+		req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		return ct.req, nil
+	}
+
+	t.Run("exact components", func(t *testing.T) {
+		req, err := sign(WithSigningComponents("@method", "@query-param;name=pet", "date"))
+		if err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		sp, err := ParseSignatureInput(strings.TrimPrefix(req.Header.Get("Signature-Input"), "sig1="))
+		if err != nil {
+			t.Fatal("could not parse signature input:", err)
+		}
+
+		if got := strings.Join(sp.Items, " "); got != `@method @query-param;name="pet" date` {
+			t.Error("unexpected components. Got:", got)
+		}
+	})
+
+	t.Run("default components", func(t *testing.T) {
+		req, err := sign(WithSigningComponents())
+		if err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		if got := req.Header.Get("Signature-Input"); !strings.HasPrefix(got, `sig1=("@method" "@authority" "@path" "@query")`) {
+			t.Error("unexpected components. Got:", got)
+		}
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		if _, err := sign(WithSigningComponents("@method", "content-type")); !IsMissingHeaderError(err) {
+			t.Error("expected missing header error. Got:", err)
+		}
+	})
+
+	t.Run("unknown component", func(t *testing.T) {
+		if _, err := sign(WithSigningComponents("@method", "@request-uri")); !errors.Is(err, errUnknownComponent) {
+			t.Error("expected unknown component error. Got:", err)
+		}
+	})
+}
+
+func TestSignTransport_ComponentOrder(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	now := time.Unix(1618884475, 0)
+
+	sign := func(opts ...SigningOption) (string, error) {
+		ct := &captureTransport{}
+		opts = append(opts, WithHmacSha256("key1", secret), WithCreated(), withNowFunc(func() time.Time { return now }))
+		client := http.Client{Transport: NewSignTransport(ct, opts...)}
+
+		req, err := http.NewRequest("GET", "http://example.com/foo?pet=dog", nil)
+		if err != nil {
+			t.Fatal("could not create request:", err)
+		}
+		req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+		req.Header.Set("X-Tenant", "acme")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		return ct.req.Header.Get("Signature-Input"), nil
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		got, err := sign(WithComponentOrder("date", "@authority", "@method"))
+		if err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		if want := `sig1=("date" "@authority" "@method" "@path" "@query" "digest");created=1618884475;keyid="key1"`; got != want {
+			t.Errorf("unexpected signature input.\nExpected: %s\nGot:      %s", want, got)
+		}
+	})
+
+	t.Run("repeatable", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			a, err := sign(WithSignAllHeaders(), WithComponentOrder("x-tenant"))
+			if err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			b, err := sign(WithSignAllHeaders(), WithComponentOrder("x-tenant"))
+			if err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			if a != b || !strings.HasPrefix(a, `sig1=("x-tenant" "@method"`) {
+				t.Fatalf("signature inputs differ: %s, %s", a, b)
+			}
+		}
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		if _, err := sign(WithComponentOrder("content-type", "@method")); !IsMissingHeaderError(err) {
+			t.Error("expected missing header error. Got:", err)
+		}
+	})
+
+	t.Run("skip absent header", func(t *testing.T) {
+		got, err := sign(WithComponentOrder("content-type", "@method"), WithSkipMissingComponents())
+		if err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		if !strings.HasPrefix(got, `sig1=("@method" "@path" "@query" "digest")`) {
+			t.Error("unexpected signature input. Got:", got)
+		}
+	})
+}
+
+func TestSignTransport_NowFunc(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	now := func() time.Time { return time.Unix(1618884475, 0) }
+
+	ct := &captureTransport{}
+	client := http.Client{
+		Transport: NewSignTransport(ct,
+			WithSigningComponents("@method"),
+			WithCreated(),
+			WithExpires(time.Minute),
+			WithHmacSha256("key1", secret),
+			WithAdditionalSignature("extra", WithSigningComponents("@path"), WithCreated(), WithHmacSha256("key2", secret)),
+			withNowFunc(now),
+		),
+	}
+
+	resp, err := client.Get("http://example.com/foo")
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+
+	expected := `sig1=("@method");created=1618884475;keyid="key1";expires=1618884535, extra=("@path");created=1618884475;keyid="key2"`
+	if got := ct.req.Header.Get("Signature-Input"); got != expected {
+		t.Errorf("unexpected signature input.\nExpected: %s\nGot:      %s", expected, got)
+	}
+}
+
+func TestSignTransport_SigningLabel(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	sign := func(opts ...SigningOption) (*http.Request, error) {
+		ct := &captureTransport{}
+		client := http.Client{Transport: NewSignTransport(ct, opts...)}
+
+		resp, err := client.Get("http://example.com/foo")
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		return ct.req, nil
+	}
+
+	tcs := []struct {
+		name   string
+		opts   []SigningOption
+		labels []string
+	}{
+		{"one key", []SigningOption{WithSigningLabel("my-service"), WithHmacSha256("key1", secret)}, []string{"my-service"}},
+		{"two keys", []SigningOption{WithSigningLabel("svc_a"), WithHmacSha256("key1", secret), WithHmacSha256("key2", secret)}, []string{"svc_a1", "svc_a2"}},
+		{"with additional", []SigningOption{WithSigningLabel("a.b"), WithHmacSha256("key1", secret),
+			WithAdditionalSignature("*other", WithHmacSha256("key2", secret))}, []string{"a.b", "*other"}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := sign(tc.opts...)
+			if err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			for _, hdr := range []string{"Signature", "Signature-Input"} {
+				parts := strings.Split(req.Header.Get(hdr), ", ")
+				if len(parts) != len(tc.labels) {
+					t.Fatalf("unexpected %s. Got: %s", hdr, req.Header.Get(hdr))
+				}
+
+				for i, l := range tc.labels {
+					if !strings.HasPrefix(parts[i], l+"=") {
+						t.Errorf("expected label %s in %s. Got: %s", l, hdr, parts[i])
+					}
+				}
+			}
+
+			v := testVerifier("key1", verifyHmacSha256(secret))
+			if _, err := v.Verify(NewRequestMessage(req)); err != nil {
+				t.Error("verification failed:", err)
+			}
+		})
+	}
+
+	for _, label := range []string{"", "Sig", "1sig", "sig 1", "sig=1", "-sig"} {
+		if _, err := sign(WithSigningLabel(label), WithHmacSha256("key1", secret)); !errors.Is(err, errInvalidLabel) {
+			t.Errorf("expected invalid label error for %q. Got: %v", label, err)
+		}
+
+		if _, err := sign(WithHmacSha256("key1", secret), WithAdditionalSignature(label, WithHmacSha256("key2", secret))); !errors.Is(err, errInvalidLabel) {
+			t.Errorf("expected invalid additional label error for %q. Got: %v", label, err)
+		}
+	}
+}
+
+func TestSign_Pooled(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	want, err := testSigner("test-key", signHmacSha256(secret)).Sign(testReq())
+	if err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	// Reused buffers and hashes must not carry state between messages.
+	s := testSigner("test-key", signHmacSha256(secret))
+	for i := 0; i < 3; i++ {
+		got, err := s.Sign(testReq())
+		if err != nil {
+			t.Fatal("signing failed:", err)
+		}
+
+		if got.Get("Signature") != want.Get("Signature") {
+			t.Errorf("pooled signature differs. Expected %s, got %s", want.Get("Signature"), got.Get("Signature"))
+		}
+
+		req := testReq()
+		for k, vs := range got {
+			req.Header[k] = vs
+		}
+
+		if _, err := testVerifier("test-key", verifyHmacSha256(secret)).Verify(req); err != nil {
+			t.Error("verification failed:", err)
+		}
+	}
+}
+
+func TestSign_SigningError(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	// Invalid private keys fail to sign, rather than producing an empty signature.
+	badEC := &ecdsa.PrivateKey{PublicKey: pk.PublicKey, D: big.NewInt(0)}
+	badRSA := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: big.NewInt(15), E: 3}, D: big.NewInt(3)}
+
+	for name, sh := range map[string]sigHolder{
+		"ecdsa-p256":   signEccP256(badEC),
+		"ecdsa-p384":   signEccP384(badEC),
+		"ecdsa-p521":   signEccP521(badEC),
+		"rsa-pss":      signRsaPssSha512(badRSA),
+		"rsa-pkcs1-v5": signRsaPkcs1Sha256(badRSA),
+	} {
+		if _, err := testSigner("test-key", sh).Sign(testReq()); err == nil {
+			t.Errorf("%s: expected signing to fail", name)
+		}
+	}
+}
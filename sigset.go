@@ -0,0 +1,75 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errUnknownSignatureSet = errors.New("unknown signature set")
+
+// SignatureSet holds named lists of signing options, eg one per API an application calls, so
+// the options can be shared rather than repeated. The zero value is an empty set, and is safe
+// for concurrent use. See WithSignatureSet.
+type SignatureSet struct {
+	mu   sync.RWMutex
+	opts map[string][]SigningOption
+}
+
+// Add sets the options for label, replacing any already added.
+func (ss *SignatureSet) Add(label string, opts ...SigningOption) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.opts == nil {
+		ss.opts = make(map[string][]SigningOption)
+	}
+
+	ss.opts[label] = append([]SigningOption(nil), opts...)
+}
+
+// Get returns the options for label, or nil if there are none.
+func (ss *SignatureSet) Get(label string) []SigningOption {
+	opts, _ := ss.lookup(label)
+	return opts
+}
+
+// MustGet is Get, but panics if there are no options for label.
+func (ss *SignatureSet) MustGet(label string) []SigningOption {
+	opts, ok := ss.lookup(label)
+	if !ok {
+		panic(fmt.Sprintf("httpsig: %s: %q", errUnknownSignatureSet, label))
+	}
+
+	return opts
+}
+
+func (ss *SignatureSet) lookup(label string) ([]SigningOption, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	opts, ok := ss.opts[label]
+	return append([]SigningOption(nil), opts...), ok
+}
+
+// WithSignatureSet applies the options for label in set, as they are when the option is used.
+// An unknown label is reported as an error when signing.
+func WithSignatureSet(set *SignatureSet, label string) SigningOption {
+	return &optImpl{
+		s: func(s *signer) {
+			opts, ok := set.lookup(label)
+			if !ok {
+				s.err = fmt.Errorf("%w: %q", errUnknownSignatureSet, label)
+				return
+			}
+
+			for _, o := range opts {
+				o.configureSign(s)
+			}
+		},
+	}
+}
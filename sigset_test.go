@@ -0,0 +1,57 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignatureSet(t *testing.T) {
+	secret := []byte(testSecret)
+
+	var set SignatureSet
+	set.Add("billing", WithHmacSha256("billing-key", secret), WithSigningComponents("@method", "@path"))
+	set.Add("search", WithHmacSha256("search-key", secret))
+
+	if got := set.Get("billing"); len(got) != 2 {
+		t.Errorf("expected 2 billing options. Got: %d", len(got))
+	}
+
+	if got := set.Get("unknown"); got != nil {
+		t.Error("expected no options for an unknown label. Got:", got)
+	}
+
+	if got := set.MustGet("search"); len(got) != 1 {
+		t.Errorf("expected 1 search option. Got: %d", len(got))
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/invoices", nil)
+	if err := SignRequest(req, WithSignatureSet(&set, "billing"), WithCreated()); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if got := req.Header.Get("Signature-Input"); !strings.HasPrefix(got, `sig1=("@method" "@path");created=`) || !strings.Contains(got, `keyid="billing-key"`) {
+		t.Error("unexpected signature input. Got:", got)
+	}
+
+	if err := VerifyRequest(req, WithHmacSha256("billing-key", secret)); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	req = httptest.NewRequest("GET", "https://example.com/", nil)
+	if err := SignRequest(req, WithSignatureSet(&set, "unknown")); !errors.Is(err, errUnknownSignatureSet) {
+		t.Error("expected unknown signature set. Got:", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustGet to panic for an unknown label")
+		}
+	}()
+	set.MustGet("unknown")
+}
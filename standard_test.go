@@ -27,8 +27,8 @@ func parse(in string) *url.URL {
 	return out
 }
 
-func testReq() *message {
-	return &message{
+func testReq() *Message {
+	return &Message{
 		Method:    "POST",
 		Authority: "example.com",
 		URL:       parse("https://example.com/foo?param=value&pet=dog"),
@@ -53,6 +53,7 @@ func TestSign_B_2_5(t *testing.T) {
 		keys: map[string]sigHolder{
 			"test-shared-secret": signHmacSha256(k),
 		},
+		created: true,
 
 		nowFunc: func() time.Time { return time.Unix(1618884475, 0) },
 	}
@@ -96,7 +97,7 @@ func TestVerify_B_2_1(t *testing.T) {
 	req.Header.Set("Signature-Input", `sig1=();created=1618884475;keyid="test-key-rsa-pss";alg="rsa-pss-sha512"`)
 	req.Header.Set("Signature", `sig1=:HWP69ZNiom9Obu1KIdqPPcu/C1a5ZUMBbqS/xwJECV8bhIQVmEAAAzz8LQPvtP1iFSxxluDO1KE9b8L+O64LEOvhwYdDctV5+E39Jy1eJiD7nYREBgxTpdUfzTO+Trath0vZdTylFlxK4H3l3s/cuFhnOCxmFYgEa+cw+StBRgY1JtafSFwNcZgLxVwialuH5VnqJS4JN8PHD91XLfkjMscTo4jmVMpFd3iLVe0hqVFl7MDt6TMkwIyVFnEZ7B/VIQofdShO+C/7MuupCSLVjQz5xA+Zs6Hw+W9ESD/6BuGs6LF1TcKLxW+5K+2zvDY/Cia34HNpRW5io7Iv9/b7iQ==:`)
 
-	err = v.Verify(req)
+	_, err = v.Verify(req)
 	if err != nil {
 		t.Error("verification failed:", err)
 	}
@@ -125,10 +126,10 @@ func TestVerify_B_2_2(t *testing.T) {
 	}
 
 	req := testReq()
-	req.Header.Set("Signature-Input", `sig1=("@authority" content-type");created=1618884475;keyid="test-key-rsa-pss"`)
+	req.Header.Set("Signature-Input", `sig1=("@authority" "content-type");created=1618884475;keyid="test-key-rsa-pss"`)
 	req.Header.Set("Signature", `sig1=:ik+OtGmM/kFqENDf9Plm8AmPtqtC7C9a+zYSaxr58b/E6h81ghJS3PcH+m1asiMp8yvccnO/RfaexnqanVB3C72WRNZN7skPTJmUVmoIeqZncdP2mlfxlLP6UbkrgYsk91NS6nwkKC6RRgLhBFqzP42oq8D2336OiQPDAo/04SxZt4Wx9nDGuy2SfZJUhsJqZyEWRk4204x7YEB3VxDAAlVgGt8ewilWbIKKTOKp3ymUeQIwptqYwv0l8mN404PPzRBTpB7+HpClyK4CNp+SVv46+6sHMfJU4taz10s/NoYRmYCGXyadzYYDj0BYnFdERB6NblI/AOWFGl5Axhhmjg==:`)
 
-	err = v.Verify(req)
+	_, err = v.Verify(req)
 	if err != nil {
 		t.Error("verification failed:", err)
 	}
@@ -160,7 +161,7 @@ func TestVerify_B_2_3(t *testing.T) {
 	req := testReq()
 	req.Header.Set("Signature-Input", `sig1=("date" "@method" "@path" "@query" "@authority" "content-type" "digest" "content-length");created=1618884475;keyid="test-key-rsa-pss"`)
 	req.Header.Set("Signature", `sig1=:JuJnJMFGD4HMysAGsfOY6N5ZTZUknsQUdClNG51VezDgPUOW03QMe74vbIdndKwW1BBrHOHR3NzKGYZJ7X3ur23FMCdANe4VmKb3Rc1Q/5YxOO8p7KoyfVa4uUcMk5jB9KAn1M1MbgBnqwZkRWsbv8ocCqrnD85Kavr73lx51k1/gU8w673WT/oBtxPtAn1eFjUyIKyA+XD7kYph82I+ahvm0pSgDPagu917SlqUjeaQaNnlZzO03Iy1RZ5XpgbNeDLCqSLuZFVID80EohC2CQ1cL5svjslrlCNstd2JCLmhjL7xV3NYXerLim4bqUQGRgDwNJRnqobpS6C1NBns/Q==:`)
-	err = v.Verify(req)
+	_, err = v.Verify(req)
 	if err != nil {
 		t.Error("verification failed:", err)
 	}
@@ -190,7 +191,7 @@ func TestVerify_B_2_4(t *testing.T) {
 		req := testReq()
 		req.Header.Set("Signature-Input", `sig1=("content-type" "digest" "content-length");created=1618884475;keyid="test-key-ecc-p256"`)
 		req.Header.Set("Signature", `sig1=:n8RKXkj0iseWDmC6PNSQ1GX2R9650v+lhbb6rTGoSrSSx18zmn6fPOtBx48/WffYLO0n1RHHf9scvNGAgGq52Q==:`)
-		err = v.Verify(req)
+		_, err = v.Verify(req)
 		if err != nil {
 			t.Error("verification failed:", err)
 		}
@@ -215,12 +216,72 @@ func TestVerify_B_2_5(t *testing.T) {
 	req.Header.Set("Signature-Input", `sig1=("@authority" "date" "content-type");created=1618884475;keyid="test-shared-secret"`)
 	req.Header.Set("Signature", `sig1=:fN3AMNGbx0V/cIEKkZOvLOoC3InI+lM2+gTv22x3ia8=:`)
 
-	err = v.Verify(req)
+	_, err = v.Verify(req)
 	if err != nil {
 		t.Error("verification failed:", err)
 	}
 }
 
+// The B.2.5 signature, in base64url, as sent by some implementations.
+const testSignatureB25URL = `sig1=:fN3AMNGbx0V_cIEKkZOvLOoC3InI-lM2-gTv22x3ia8=:`
+
+func TestSign_B_2_5_Base64URL(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := &signer{
+		headers: []string{"@authority", "date", "content-type"},
+		keys: map[string]sigHolder{
+			"test-shared-secret": signHmacSha256(k),
+		},
+		created:   true,
+		base64URL: true,
+
+		nowFunc: func() time.Time { return time.Unix(1618884475, 0) },
+	}
+
+	hdr, err := s.Sign(testReq())
+	if err != nil {
+		t.Error("signing failed:", err)
+	}
+
+	if hdr.Get("Signature") != testSignatureB25URL {
+		t.Error("signature did not match. Got:", hdr.Get("Signature"))
+	}
+}
+
+func TestVerify_B_2_5_Base64URL(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	v := &verifier{
+		keys: map[string]verHolder{
+			"test-shared-secret": verifyHmacSha256(k),
+		},
+		base64URL: true,
+
+		nowFunc: func() time.Time { return time.Unix(1618884475, 0) },
+	}
+
+	req := testReq()
+	req.Header.Set("Signature-Input", `sig1=("@authority" "date" "content-type");created=1618884475;keyid="test-shared-secret"`)
+	req.Header.Set("Signature", testSignatureB25URL)
+
+	if _, err := v.Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	// The standard encoding has characters outside of the base64url alphabet.
+	req.Header.Set("Signature", `sig1=:fN3AMNGbx0V/cIEKkZOvLOoC3InI+lM2+gTv22x3ia8=:`)
+	if _, err := v.Verify(req); !IsMalformedSignatureError(err) {
+		t.Error("expected malformed signature error. Got:", err)
+	}
+}
+
 // The following keypairs are taken from the Draft Standard, so we may recreate the examples in tests.
 // If your robot scans this repo and says it's leaking keys I will be mildly amused.
 
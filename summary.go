@@ -0,0 +1,93 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ghoti143/httpsig/internal/sfv"
+)
+
+// SignatureInfo describes the signatures on a message, as found in its `Signature-Input` and
+// `Signature` headers.
+type SignatureInfo struct {
+	// Labels are the signature labels, in the order of the `Signature-Input` header, followed
+	// by any only in the `Signature` header.
+	Labels []string
+
+	// PerLabel are the parameters of each signature, by label.
+	PerLabel map[string]*SignatureParams
+
+	// RawSignatures are the signature bytes of each signature, by label.
+	RawSignatures map[string][]byte
+}
+
+// SignatureSummary returns the signatures on req, for logging and debugging. Signatures are
+// only parsed, not verified, so the summary includes signatures that would fail verification.
+func SignatureSummary(req *http.Request) (*SignatureInfo, error) {
+	return signatureSummary(req.Header)
+}
+
+// ResponseSignatureSummary is SignatureSummary, for responses.
+func ResponseSignatureSummary(resp *http.Response) (*SignatureInfo, error) {
+	return signatureSummary(resp.Header)
+}
+
+func signatureSummary(hdr http.Header) (*SignatureInfo, error) {
+	inputHdr, sigHdr := hdr.Values("Signature-Input"), hdr.Values("Signature")
+	if len(inputHdr) == 0 && len(sigHdr) == 0 {
+		return nil, errNotSigned
+	}
+
+	inputs, err := sfv.ParseDictionary(strings.Join(inputHdr, ", "))
+	if err != nil {
+		return nil, errMalformedSignature
+	}
+
+	sigs, err := sfv.ParseDictionary(strings.Join(sigHdr, ", "))
+	if err != nil {
+		return nil, errMalformedSignature
+	}
+
+	info := &SignatureInfo{
+		PerLabel:      make(map[string]*SignatureParams, len(inputs)),
+		RawSignatures: make(map[string][]byte, len(sigs)),
+	}
+
+	for _, m := range inputs {
+		il, ok := m.Value.(sfv.InnerList)
+		if !ok {
+			return nil, errMalformedSignature
+		}
+
+		sp, err := signatureParamsFromInnerList(il, false)
+		if err != nil {
+			return nil, errMalformedSignature
+		}
+
+		info.Labels = append(info.Labels, m.Key)
+		info.PerLabel[m.Key] = sp
+	}
+
+	for _, m := range sigs {
+		it, ok := m.Value.(sfv.Item)
+		if !ok {
+			return nil, errMalformedSignature
+		}
+
+		sig, ok := it.Value.([]byte)
+		if !ok {
+			return nil, errMalformedSignature
+		}
+
+		if _, ok := info.PerLabel[m.Key]; !ok {
+			info.Labels = append(info.Labels, m.Key)
+		}
+		info.RawSignatures[m.Key] = sig
+	}
+
+	return info, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignatureSummary(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Signature-Input", `sig1=("@method" "@path");keyid="key1";created=1618884475, sig2=("@method");keyid="key2"`)
+	req.Header.Set("Signature", `sig1=:c2lnMQ==:, sig2=:c2lnMg==:, sig3=:c2lnMw==:`)
+
+	info, err := SignatureSummary(req)
+	if err != nil {
+		t.Fatal("summary failed:", err)
+	}
+
+	created := time.Unix(1618884475, 0)
+	want := &SignatureInfo{
+		Labels: []string{"sig1", "sig2", "sig3"},
+		PerLabel: map[string]*SignatureParams{
+			"sig1": {Items: []string{"@method", "@path"}, KeyID: "key1", Created: &created},
+			"sig2": {Items: []string{"@method"}, KeyID: "key2"},
+		},
+		RawSignatures: map[string][]byte{"sig1": []byte("sig1"), "sig2": []byte("sig2"), "sig3": []byte("sig3")},
+	}
+
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("unexpected summary.\nExpected: %+v\nGot:      %+v", want, info)
+	}
+
+	// Signatures that don't verify are still summarised.
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	if err := SignResponse(resp, WithHmacSha256("key1", []byte(testSecret))); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+	resp.Header.Set("Signature", "sig1=:AAAA:")
+
+	if info, err := ResponseSignatureSummary(resp); err != nil || info.PerLabel["sig1"].KeyID != "key1" {
+		t.Errorf("unexpected response summary %+v: %v", info, err)
+	}
+
+	tcs := []struct {
+		name   string
+		input  string
+		sig    string
+		errFun func(error) bool
+	}{
+		{"unsigned", "", "", IsNotSignedError},
+		{"bad input", `sig1=("@method"`, `sig1=:c2ln:`, IsMalformedSignatureError},
+		{"bad param", `sig1=("@method");created="now"`, `sig1=:c2ln:`, IsMalformedSignatureError},
+		{"signature not bytes", `sig1=("@method")`, `sig1="c2ln"`, IsMalformedSignatureError},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			if tc.input != "" {
+				req.Header.Set("Signature-Input", tc.input)
+				req.Header.Set("Signature", tc.sig)
+			}
+
+			if _, err := SignatureSummary(req); !tc.errFun(err) {
+				t.Error("unexpected error:", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// testVerifyMiddleware records the result of the most recent verification by its middleware,
+// for tests that assert on the key that verified a request rather than the response. It is safe
+// for concurrent use.
+type testVerifyMiddleware struct {
+	mu       sync.Mutex
+	res      VerifyResult
+	err      error
+	verified bool
+}
+
+// newTestVerifyMiddleware returns a middleware that verifies requests as with
+// NewVerifyMiddleware, and the testVerifyMiddleware recording its results.
+func newTestVerifyMiddleware(opts ...VerifyOption) (*testVerifyMiddleware, Middleware) {
+	tv := &testVerifyMiddleware{}
+
+	opts = append(append([]VerifyOption(nil), opts...), &optImpl{
+		v: func(v *verifier) { v.resultHook = tv.record },
+	})
+
+	return tv, NewVerifyMiddleware(opts...)
+}
+
+func (tv *testVerifyMiddleware) record(res VerifyResult, err error) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	tv.res, tv.err, tv.verified = res, err, err == nil
+}
+
+// LastResult returns the result of the most recent verification, and its error. Before any
+// request, both are empty.
+func (tv *testVerifyMiddleware) LastResult() (VerifyResult, error) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	return tv.res, tv.err
+}
+
+// WasVerified reports whether the most recent request was verified.
+func (tv *testVerifyMiddleware) WasVerified() bool {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	return tv.verified
+}
+
+func TestTestVerifyMiddleware(t *testing.T) {
+	secret := []byte(testSecret)
+
+	tv, mw := newTestVerifyMiddleware(WithHmacSha512("key1", secret), WithHmacSha256("key2", secret))
+	h := mw.Then(http.NotFoundHandler())
+
+	if _, err := tv.LastResult(); err != nil || tv.WasVerified() {
+		t.Error("expected no result before any request")
+	}
+
+	serve := func(opts ...SigningOption) {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if len(opts) > 0 {
+			if err := SignRequest(req, opts...); err != nil {
+				t.Fatal("signing failed:", err)
+			}
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(WithHmacSha256("key2", secret))
+	if res, err := tv.LastResult(); err != nil || !tv.WasVerified() || res.KeyID != "key2" || res.Alg != "hmac-sha256" {
+		t.Errorf("unexpected result %+v: %v", res, err)
+	}
+
+	serve(WithHmacSha512("key1", secret))
+	if res, err := tv.LastResult(); err != nil || !tv.WasVerified() || res.KeyID != "key1" || res.Alg != "hmac-sha512" {
+		t.Errorf("unexpected result %+v: %v", res, err)
+	}
+
+	serve()
+	if _, err := tv.LastResult(); !IsNotSignedError(err) || tv.WasVerified() {
+		t.Error("expected not signed. Got:", err)
+	}
+
+	serve(WithHmacSha256("key2", []byte("wrong")))
+	if _, err := tv.LastResult(); !IsInvalidSignatureError(err) || tv.WasVerified() {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
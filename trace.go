@@ -0,0 +1,111 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"sort"
+)
+
+// tracer starts spans around signing and verification, eg for WithTracing and the instrumented
+// middleware and transport. These are only available in builds with the otel and prometheus
+// tags, so the package doesn't otherwise depend on them.
+type tracer interface {
+	start(ctx context.Context, name string) (context.Context, span)
+}
+
+type span interface {
+	// keys records the key ids used, and their algorithms.
+	keys(keyIDs, algs []string)
+
+	// fail records err as the reason the operation failed.
+	fail(err error)
+
+	end()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) keys(keyIDs, algs []string) {}
+func (noopSpan) fail(err error)             {}
+func (noopSpan) end()                       {}
+
+// startSpan starts a span named name with each of ts, as a child of any span in ctx. Without
+// tracers, the span does nothing.
+func startSpan(ctx context.Context, ts []tracer, name string) (context.Context, span) {
+	switch len(ts) {
+	case 0:
+		return ctx, noopSpan{}
+	case 1:
+		return ts[0].start(ctx, name)
+	}
+
+	spans := make(multiSpan, len(ts))
+	for i, t := range ts {
+		ctx, spans[i] = t.start(ctx, name)
+	}
+
+	return ctx, spans
+}
+
+type multiSpan []span
+
+func (ms multiSpan) keys(keyIDs, algs []string) {
+	for _, sp := range ms {
+		sp.keys(keyIDs, algs)
+	}
+}
+
+func (ms multiSpan) fail(err error) {
+	for _, sp := range ms {
+		sp.fail(err)
+	}
+}
+
+func (ms multiSpan) end() {
+	for i := len(ms) - 1; i >= 0; i-- {
+		ms[i].end()
+	}
+}
+
+// keyAttributes returns the key ids s signs with, including those of additional signatures,
+// and their algorithms.
+func (s *signer) keyAttributes() ([]string, []string) {
+	keyIDs := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keyIDs = append(keyIDs, k)
+	}
+	sort.Strings(keyIDs)
+
+	algs := make([]string, 0, len(keyIDs))
+	for _, k := range keyIDs {
+		algs = append(algs, s.keys[k].alg)
+	}
+
+	for _, a := range s.additional {
+		ks, as := a.s.keyAttributes()
+		keyIDs, algs = append(keyIDs, ks...), append(algs, as...)
+	}
+
+	return keyIDs, algs
+}
+
+// errorReason returns a short name for the cause of a verification error, one of `not_signed`,
+// `unknown_key`, `expired`, `malformed` or `invalid`.
+func errorReason(err error) string {
+	switch {
+	case IsNotSignedError(err):
+		return "not_signed"
+	case IsUnknownKeyError(err):
+		return "unknown_key"
+	case IsKeyExpiredError(err), IsSignatureExpiredError(err), IsCreatedOutsideWindowError(err),
+		IsSignatureTooOldError(err):
+		return "expired"
+	case IsMalformedSignatureError(err), IsMissingHeaderError(err), IsHeaderValueError(err):
+		return "malformed"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type recordedSpan struct {
+	name   string
+	keyIDs []string
+	algs   []string
+	err    error
+	ended  bool
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (rt *recordingTracer) start(ctx context.Context, name string) (context.Context, span) {
+	sp := &recordedSpan{name: name}
+	rt.spans = append(rt.spans, sp)
+	return ctx, sp
+}
+
+func (sp *recordedSpan) keys(keyIDs, algs []string) { sp.keyIDs, sp.algs = keyIDs, algs }
+func (sp *recordedSpan) fail(err error)             { sp.err = err }
+func (sp *recordedSpan) end()                       { sp.ended = true }
+
+func TestTracing(t *testing.T) {
+	rt := &recordingTracer{}
+	withTracer := &optImpl{
+		s: func(s *signer) { s.tracers = append(s.tracers, rt) },
+		v: func(v *verifier) { v.tracers = append(v.tracers, rt) },
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	opts := []SigningOption{
+		WithHmacSha256("key1", []byte(testSecret)),
+		WithHmacSha256("key2", []byte(testSecret)),
+		withTracer,
+	}
+	if err := SignRequest(req, opts...); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if err := VerifyRequest(req, WithHmacSha256("key1", []byte(testSecret)), withTracer); err != nil {
+		t.Fatal("verification failed:", err)
+	}
+
+	err := VerifyRequest(httptest.NewRequest("GET", "http://example.com/", nil), withTracer)
+
+	want := []*recordedSpan{
+		{name: "httpsig.sign", keyIDs: []string{"key1", "key2"}, algs: []string{"", ""}, ended: true},
+		{name: "httpsig.verify", keyIDs: []string{"key1"}, algs: []string{"hmac-sha256"}, ended: true},
+		{name: "httpsig.verify", err: err, ended: true},
+	}
+
+	if !reflect.DeepEqual(rt.spans, want) {
+		t.Errorf("unexpected spans.\nExpected: %+v\nGot:      %+v", want, rt.spans)
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	tcs := []struct {
+		err  error
+		want string
+	}{
+		{errNotSigned, "not_signed"},
+		{&UnknownKeyError{KeyID: "key1"}, "unknown_key"},
+		{&KeyExpiredError{KeyID: "key1"}, "expired"},
+		{errSignatureExpired, "expired"},
+		{errMalformedSignature, "malformed"},
+		{errInvalidSignature, "invalid"},
+		{errBodyDigestMismatch, "invalid"},
+	}
+
+	for _, tc := range tcs {
+		if got := errorReason(tc.err); got != tc.want {
+			t.Errorf("%v: expected %q. Got: %q", tc.err, tc.want, got)
+		}
+	}
+}
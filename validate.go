@@ -0,0 +1,177 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ghoti143/httpsig/internal/sfv"
+)
+
+// validationAlgs are the algorithms defined by the standard or supported by this package.
+var validationAlgs = map[string]bool{
+	"rsa-pss-sha512":    true,
+	"rsa-v1_5-sha256":   true,
+	"rsa-pkcs1-sha256":  true,
+	"rsa-pkcs1-sha512":  true,
+	"ecdsa-p256-sha256": true,
+	"ecdsa-p384-sha384": true,
+	"ecdsa-p521-sha512": true,
+	"ed25519":           true,
+	"hmac-sha256":       true,
+	"hmac-sha384":       true,
+	"hmac-sha512":       true,
+}
+
+// ValidationError describes a structural problem with the signature headers of a message.
+type ValidationError struct {
+	// Code names the kind of problem, one of `missing_header`, `malformed_header`,
+	// `duplicate_label`, `label_mismatch`, `malformed_input`, `invalid_component`,
+	// `malformed_params`, `unknown_algorithm` or `malformed_signature`.
+	Code string
+
+	// Field is the header with the problem, with the label of the signature if known, eg
+	// `Signature-Input[sig1]`.
+	Field string
+
+	// Message describes the problem.
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// SignatureValidator checks that the signature headers of requests are well formed, for
+// linting and debugging tools. Signatures aren't verified, so no keys are needed. The zero
+// value is ready to use.
+type SignatureValidator struct{}
+
+// Validate returns the structural problems with the `Signature-Input` and `Signature` headers
+// of req: their syntax, the components and algorithm of each signature, the encoding of the
+// signatures, and whether both headers have the same labels. It is empty if there are none.
+func (SignatureValidator) Validate(req *http.Request) []ValidationError {
+	var vs validation
+
+	inputs := vs.members(req.Header, "Signature-Input", validateInput)
+	sigs := vs.members(req.Header, "Signature", validateSignature)
+
+	if inputs != nil && sigs != nil {
+		vs.mismatched("Signature-Input", inputs, sigs, "no signature with this label")
+		vs.mismatched("Signature", sigs, inputs, "no signature input with this label")
+	}
+
+	return vs.errs
+}
+
+type validation struct {
+	errs []ValidationError
+}
+
+func (vs *validation) add(code, field, format string, args ...interface{}) {
+	vs.errs = append(vs.errs, ValidationError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// members returns the labelled members of the header name, after checking each with check. It
+// is nil if the header is missing or isn't a list of members.
+func (vs *validation) members(hdr http.Header, name string, check func(vs *validation, field, value string)) []member {
+	values := hdr.Values(name)
+	if len(values) == 0 {
+		vs.add("missing_header", name, "header not set")
+		return nil
+	}
+
+	joined := strings.Join(values, ", ")
+
+	var v verifier
+	ms, err := v.splitMembers(joined)
+	if err != nil {
+		vs.add("malformed_header", name, "not a list of labelled signatures")
+		return nil
+	}
+
+	n := len(vs.errs)
+	seen := make(map[string]bool, len(ms))
+	for _, m := range ms {
+		field := name + "[" + m.label + "]"
+		if seen[m.label] {
+			vs.add("duplicate_label", field, "label used more than once")
+			continue
+		}
+		seen[m.label] = true
+
+		check(vs, field, m.value)
+	}
+
+	// Catch any remaining syntax errors, eg in labels.
+	if len(vs.errs) == n {
+		if _, err := sfv.ParseDictionary(joined); err != nil {
+			vs.add("malformed_header", name, "invalid structured field dictionary")
+		}
+	}
+
+	return ms
+}
+
+// mismatched adds an error for each label of ms that isn't in others.
+func (vs *validation) mismatched(name string, ms, others []member, msg string) {
+	labels := make(map[string]bool, len(others))
+	for _, m := range others {
+		labels[m.label] = true
+	}
+
+	for _, m := range ms {
+		if !labels[m.label] {
+			vs.add("label_mismatch", name+"["+m.label+"]", "%s", msg)
+		}
+	}
+}
+
+// validateInput checks the `Signature-Input` member value.
+func validateInput(vs *validation, field, value string) {
+	il, err := sfv.ParseInnerList(value)
+	if err != nil {
+		vs.add("malformed_input", field, "not an inner list of components")
+		return
+	}
+
+	n := len(vs.errs)
+	for _, it := range il.Items {
+		c, err := componentFromItem(it)
+		if err == nil {
+			err = validateComponent(c.id())
+		}
+
+		if err != nil {
+			vs.add("invalid_component", field, "component %v: %v", it.Value, err)
+		} else if name := it.Value.(string); name != c.name {
+			// Signature bases use lowercase names, so other names never verify.
+			vs.add("invalid_component", field, "component %q: not lowercase", name)
+		}
+	}
+
+	if len(vs.errs) != n {
+		return
+	}
+
+	sp, err := signatureParamsFromInnerList(il, false)
+	if err != nil {
+		vs.add("malformed_params", field, "invalid signature parameters")
+		return
+	}
+
+	if sp.Alg != "" && !validationAlgs[sp.Alg] {
+		vs.add("unknown_algorithm", field, "unknown algorithm %q", sp.Alg)
+	}
+}
+
+// validateSignature checks the `Signature` member value.
+func validateSignature(vs *validation, field, value string) {
+	if sig, err := parseSFByteSequence(value); err != nil || len(sig) == 0 {
+		vs.add("malformed_signature", field, "not a base64 byte sequence")
+	}
+}
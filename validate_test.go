@@ -0,0 +1,62 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSignatureValidator(t *testing.T) {
+	signed := httptest.NewRequest("GET", "https://example.com/", nil)
+	if err := SignRequest(signed, WithHmacSha256("key1", []byte(testSecret))); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	sig := signed.Header.Get("Signature")
+	input := signed.Header.Get("Signature-Input")
+
+	tcs := []struct {
+		name  string
+		hdr   http.Header
+		codes []string
+	}{
+		{"valid", http.Header{"Signature-Input": {input}, "Signature": {sig}}, nil},
+		{"valid alg", http.Header{"Signature-Input": {`sig1=("@method" "date");alg="ed25519"`}, "Signature": {"sig1=:YQ==:"}}, nil},
+		{"unsigned", http.Header{}, []string{"missing_header", "missing_header"}},
+		{"missing signature", http.Header{"Signature-Input": {input}}, []string{"missing_header"}},
+		{"not a dictionary", http.Header{"Signature-Input": {"nope"}, "Signature": {sig}}, []string{"malformed_header"}},
+		{"bad label", http.Header{"Signature-Input": {`Sig1=("@method")`}, "Signature": {"Sig1=:YQ==:"}}, []string{"malformed_header", "malformed_header"}},
+		{"not an inner list", http.Header{"Signature-Input": {`sig1="@method"`}, "Signature": {"sig1=:YQ==:"}}, []string{"malformed_input"}},
+		{"unknown component", http.Header{"Signature-Input": {`sig1=("@nope" "Date")`}, "Signature": {"sig1=:YQ==:"}}, []string{"invalid_component", "invalid_component"}},
+		{"bad params", http.Header{"Signature-Input": {`sig1=("@method");created="now"`}, "Signature": {"sig1=:YQ==:"}}, []string{"malformed_params"}},
+		{"unknown alg", http.Header{"Signature-Input": {`sig1=("@method");alg="rot13"`}, "Signature": {"sig1=:YQ==:"}}, []string{"unknown_algorithm"}},
+		{"bad base64", http.Header{"Signature-Input": {input}, "Signature": {"sig1=:not base64:"}}, []string{"malformed_signature"}},
+		{"empty signature", http.Header{"Signature-Input": {input}, "Signature": {"sig1=::"}}, []string{"malformed_signature"}},
+		{"duplicate label", http.Header{"Signature-Input": {input, input}, "Signature": {sig}}, []string{"duplicate_label"}},
+		{"mismatched labels", http.Header{"Signature-Input": {input}, "Signature": {"sig2=:YQ==:"}}, []string{"label_mismatch", "label_mismatch"}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://example.com/", nil)
+			req.Header = tc.hdr
+
+			var codes []string
+			for _, err := range (SignatureValidator{}).Validate(req) {
+				if err.Field == "" || err.Message == "" {
+					t.Errorf("incomplete validation error: %+v", err)
+				}
+				codes = append(codes, err.Code)
+			}
+
+			if !reflect.DeepEqual(codes, tc.codes) {
+				t.Errorf("unexpected codes %v, want %v", codes, tc.codes)
+			}
+		})
+	}
+}
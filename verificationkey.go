@@ -0,0 +1,83 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+)
+
+var errKeyCount = errors.New("option must configure exactly one verification key")
+
+// VerificationKey is a key for verifying signatures, with its algorithm, eg as returned by a
+// KeyResolver. Create one with NewHmacSha256Key, NewEcdsaKey or KeyFromVerifyOption. The zero
+// value holds no key.
+type VerificationKey struct {
+	vh verHolder
+}
+
+// IsZero reports whether k holds no key.
+func (k VerificationKey) IsZero() bool {
+	return k.vh.verifier == nil
+}
+
+// Alg returns the algorithm of k, or an empty string for keys without one.
+func (k VerificationKey) Alg() string {
+	return k.vh.alg
+}
+
+// NewHmacSha256Key returns the key verifying `hmac-sha256` signatures with the shared secret.
+func NewHmacSha256Key(secret []byte) VerificationKey {
+	return VerificationKey{vh: verifyHmacSha256(secret)}
+}
+
+// NewEcdsaKey returns the key verifying signatures with pk. The algorithm is chosen by the
+// key's curve: `ecdsa-p256-sha256`, `ecdsa-p384-sha384`, or `ecdsa-p521-sha512`.
+func NewEcdsaKey(pk *ecdsa.PublicKey) (VerificationKey, error) {
+	vh, err := ecdsaCurveVerHolder(pk)
+	if err != nil {
+		return VerificationKey{}, err
+	}
+
+	return VerificationKey{vh: vh}, nil
+}
+
+// KeyFromVerifyOption returns the key configured by one of the `WithVerify*` or `WithHmac*`
+// options, eg `KeyFromVerifyOption(httpsig.WithVerifyRsaPssSha512("", pub))`. The key id given
+// to the option is ignored.
+func KeyFromVerifyOption(opt VerifyOption) (VerificationKey, error) {
+	v := &verifier{keys: make(map[string]verHolder)}
+	opt.configureVerify(v)
+
+	if len(v.keys) != 1 {
+		return VerificationKey{}, errKeyCount
+	}
+
+	for _, vh := range v.keys {
+		return VerificationKey{vh: vh}, nil
+	}
+
+	return VerificationKey{}, errKeyCount
+}
+
+// ecdsaCurveVerHolder returns the verifier for pk, with the algorithm of its curve.
+func ecdsaCurveVerHolder(pk *ecdsa.PublicKey) (verHolder, error) {
+	if pk == nil || pk.Curve == nil {
+		return verHolder{}, errInvalidKey
+	}
+
+	switch pk.Curve {
+	case elliptic.P256():
+		return verifyEccP256(pk), nil
+	case elliptic.P384():
+		return verifyEccP384(pk), nil
+	case elliptic.P521():
+		return verifyEccP521(pk), nil
+	default:
+		return verHolder{}, fmt.Errorf("%w: unsupported curve %s", errKeyAlgMismatch, pk.Curve.Params().Name)
+	}
+}
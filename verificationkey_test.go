@@ -0,0 +1,140 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ghoti143/httpsig"
+)
+
+// dbResolver is a KeyResolver outside the package, as for keys loaded from a database.
+type dbResolver struct {
+	keys map[string]httpsig.VerificationKey
+}
+
+func (d *dbResolver) ResolveKey(_ context.Context, keyID string) (httpsig.VerificationKey, error) {
+	if keyID == "broken" {
+		return httpsig.VerificationKey{}, errors.New("database is down")
+	}
+
+	return d.keys[keyID], nil
+}
+
+func TestKeyResolver_External(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	ecKey, err := httpsig.NewEcdsaKey(&pk.PublicKey)
+	if err != nil {
+		t.Fatal("could not create key:", err)
+	}
+
+	hmacKey, err := httpsig.KeyFromVerifyOption(httpsig.WithHmacSha512("", []byte(secret)))
+	if err != nil {
+		t.Fatal("could not create key:", err)
+	}
+
+	r := &dbResolver{keys: map[string]httpsig.VerificationKey{
+		"hmac256": httpsig.NewHmacSha256Key([]byte(secret)),
+		"hmac512": hmacKey,
+		"ec":      ecKey,
+	}}
+
+	if ecKey.Alg() != "ecdsa-p384-sha384" || hmacKey.Alg() != "hmac-sha512" {
+		t.Errorf("unexpected algorithms %q, %q", ecKey.Alg(), hmacKey.Alg())
+	}
+
+	tcs := []struct {
+		name    string
+		opt     httpsig.SigningOption
+		wantErr func(error) bool
+	}{
+		{"hmac-sha256", httpsig.WithHmacSha256("hmac256", []byte(secret)), nil},
+		{"hmac-sha512", httpsig.WithHmacSha512("hmac512", []byte(secret)), nil},
+		{"ecdsa", httpsig.WithSignEcdsaP384Sha384("ec", pk), nil},
+		{"wrong secret", httpsig.WithHmacSha256("hmac256", []byte("wrong")), httpsig.IsInvalidSignatureError},
+		{"unknown", httpsig.WithHmacSha256("unknown", []byte(secret)), httpsig.IsUnknownKeyError},
+		{"resolver error", httpsig.WithHmacSha256("broken", []byte(secret)), httpsig.IsUnknownKeyError},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://example.com/", nil)
+			if err := httpsig.SignRequest(req, tc.opt); err != nil {
+				t.Fatal("signing failed:", err)
+			}
+
+			err := httpsig.VerifyRequest(req, httpsig.WithKeyResolver(r))
+			if tc.wantErr == nil && err != nil {
+				t.Error("verification failed:", err)
+			}
+
+			if tc.wantErr != nil && !tc.wantErr(err) {
+				t.Error("unexpected error:", err)
+			}
+		})
+	}
+}
+
+func TestVerificationKey_Invalid(t *testing.T) {
+	if _, err := httpsig.NewEcdsaKey(nil); err == nil {
+		t.Error("expected a nil key to fail")
+	}
+
+	if _, err := httpsig.KeyFromVerifyOption(httpsig.WithClockSkew(time.Second)); err == nil {
+		t.Error("expected an option without a key to fail")
+	}
+
+	if !(httpsig.VerificationKey{}).IsZero() || httpsig.NewHmacSha256Key([]byte(secret)).IsZero() {
+		t.Error("unexpected IsZero result")
+	}
+}
+
+// mapStore is a KeyStore outside the package, standing in for a Redis or database backed store.
+type mapStore map[string]httpsig.VerificationKey
+
+func (m mapStore) Add(keyID string, key httpsig.VerificationKey) error {
+	m[keyID] = key
+	return nil
+}
+
+func (m mapStore) Remove(keyID string) error {
+	delete(m, keyID)
+	return nil
+}
+
+func (m mapStore) Lookup(keyID string) (httpsig.VerificationKey, bool) {
+	key, ok := m[keyID]
+	return key, ok
+}
+
+func TestKeyStore_External(t *testing.T) {
+	store := mapStore{}
+	_ = store.Add("key1", httpsig.NewHmacSha256Key([]byte(secret)))
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	if err := httpsig.SignRequest(req, httpsig.WithHmacSha256("key1", []byte(secret))); err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	if err := httpsig.VerifyRequest(req, httpsig.WithKeyStore(store)); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	_ = store.Remove("key1")
+	if err := httpsig.VerifyRequest(req, httpsig.WithKeyStore(store)); !httpsig.IsUnknownKeyError(err) {
+		t.Error("expected unknown key. Got:", err)
+	}
+}
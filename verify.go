@@ -5,16 +5,22 @@
 package httpsig
 
 import (
-	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"log/slog"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 )
@@ -29,173 +35,865 @@ type verHolder struct {
 	verifier func() verImpl
 }
 
+// KeyResolver looks up verification keys by key id, for keys that aren't known when the
+// verifier is created.
+type KeyResolver interface {
+	// ResolveKey returns the key for keyID. Return an error, or a zero VerificationKey, if
+	// the key does not exist.
+	ResolveKey(ctx context.Context, keyID string) (VerificationKey, error)
+}
+
+// keyMeta tracks a key being replaced by another, as set by WithKeyRotation.
+type keyMeta struct {
+	// When the rotation was registered, ie when the verifier was created.
+	registeredAt time.Time
+
+	// How long after registeredAt the key remains valid.
+	overlap time.Duration
+
+	// The key id of the replacement key.
+	replacedBy string
+}
+
 type verifier struct {
 	keys map[string]verHolder
 
+	// Times after which keys, by key id, are no longer used.
+	keyExpiry map[string]time.Time
+
+	// Metadata for keys being rotated out, by key id.
+	keyMeta map[string]keyMeta
+
+	// If set, checked for keys not in keys.
+	store KeyStore
+
+	// If set, used to look up keys not in keys or store.
+	resolver KeyResolver
+
+	// If set, derives an hmac-sha256 key for key ids not in keys or store.
+	derive func(keyID string) ([]byte, error)
+
+	// Tolerance for clock differences between signer and verifier when checking expires.
+	skew time.Duration
+
+	// If non-zero, the maximum distance between created and now, in either direction.
+	createdWindow time.Duration
+
+	// If non-zero, the maximum time since created.
+	maxAge time.Duration
+
+	// Reject signatures without created when maxAge is set.
+	requireCreated bool
+
+	// If set, called with the nonce of each otherwise valid signature.
+	nonceValidator func(nonce string) error
+
+	// If set, used to reject signatures with a nonce that has been seen before.
+	nonceStore NonceStore
+
+	// Check request bodies against their Content-Digest header.
+	contentDigest bool
+
+	// If set, the only algorithms accepted.
+	algs []string
+
+	// Component identifiers that every signature must cover.
+	required []string
+
+	// Components asked for in the Accept-Signature header of responses to unsigned requests.
+	accept []string
+
+	// If set, checks or normalizes signed header values, in place of rejecting newlines.
+	sanitize func(name, value string) (string, error)
+
+	// Decode signatures as base64url rather than base64.
+	base64URL bool
+
+	// Accept whitespace and missing colons in signature headers.
+	lenient bool
+
+	// Reject signatures with unknown parameters.
+	strict bool
+
+	// Try every key for signatures with an unknown key id.
+	obfuscatedKeyID bool
+
+	// Limits on the length of signed header values and the `Signature-Input` header. Zero is
+	// the default limit, and negative is unlimited.
+	maxHeaderValueLength    int
+	maxSignatureInputLength int
+
+	// Limit on the number of signed components. Zero is the default limit, and negative is
+	// unlimited.
+	maxComponents int
+
+	// Let unsigned requests through the middleware.
+	passthrough bool
+
+	// Responds to rejected requests, in the middleware.
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	// Each traces verification.
+	tracers []tracer
+
+	// If set, the middleware logs verification results to it.
+	logger *slog.Logger
+
+	// Called with each result of the middleware, for testVerifyMiddleware in tests.
+	resultHook func(res VerifyResult, err error)
+
+	// Let requests failing verification through the middleware, until enforceAfter if set.
+	observe      bool
+	enforceAfter time.Time
+
+	// Derived components outside the standard.
+	custom customComponents
+
+	// Tried in turn when verification with this configuration fails, for NewChainVerifier.
+	chain []*verifier
+
 	// For testing
 	nowFunc func() time.Time
 }
 
+// VerifyResult describes the signature that was verified.
+type VerifyResult struct {
+	// KeyID is the key id of the signature.
+	KeyID string
+
+	// Alg is the algorithm of the key that verified the signature, which may be empty for
+	// keys configured without one.
+	Alg string
+}
+
+// Clone returns a copy of v, with its own keys. Changes to the copy, eg to its clock, don't
+// affect v, so a copy can hold the state of a single verification.
+func (v *verifier) Clone() *verifier {
+	cv := *v
+
+	cv.keys = make(map[string]verHolder, len(v.keys))
+	for k, vh := range v.keys {
+		cv.keys[k] = vh
+	}
+
+	return &cv
+}
+
+const (
+	defaultMaxHeaderValueLength    = 8192
+	defaultMaxSignatureInputLength = 4096
+	defaultMaxComponents           = 20
+)
+
+// checkLength returns a malformed signature error if the values of the header name are longer
+// in total than limit, or def if limit is zero.
+func checkLength(name string, values []string, limit, def int) error {
+	if limit == 0 {
+		limit = def
+	}
+
+	if limit < 0 {
+		return nil
+	}
+
+	n := 0
+	for _, val := range values {
+		n += len(val)
+	}
+
+	if n > limit {
+		return fmt.Errorf("%w: %s longer than %d bytes", errMalformedSignature, name, limit)
+	}
+
+	return nil
+}
+
+// checkHeaderLengths checks the length of each value of the headers in items.
+func (v *verifier) checkHeaderLengths(msg *Message, items []string) error {
+	for _, it := range items {
+		c, err := parseComponent(it)
+		if err != nil {
+			return err
+		}
+
+		if c.name[0] == '@' {
+			continue
+		}
+
+		for _, val := range msg.Header.Values(c.name) {
+			if err := checkLength(c.name, []string{val}, v.maxHeaderValueLength, defaultMaxHeaderValueLength); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// now returns the current time, from nowFunc if set.
+func (v *verifier) now() time.Time {
+	if v.nowFunc == nil {
+		return time.Now()
+	}
+
+	return v.nowFunc()
+}
+
 // XXX: note about fail fast.
-func (v *verifier) Verify(msg *message) error {
-	sigHdr := msg.Header.Get("Signature")
+func (v *verifier) Verify(msg *Message) (VerifyResult, error) {
+	return v.VerifyWithContext(context.Background(), msg)
+}
+
+// VerifyWithContext is Verify, but stops early with the context's error if ctx is done before
+// verification completes.
+func (v *verifier) VerifyWithContext(ctx context.Context, msg *Message) (VerifyResult, error) {
+	if len(v.chain) > 0 {
+		return v.verifyChain(ctx, msg)
+	}
+
+	return v.verifyMessage(ctx, msg)
+}
+
+func (v *verifier) verifyMessage(ctx context.Context, msg *Message) (VerifyResult, error) {
+	if err := ctx.Err(); err != nil {
+		return VerifyResult{}, err
+	}
+
+	// Headers may be split over several lines, eg by proxies.
+	sigHdr := strings.Join(msg.Header.Values("Signature"), ", ")
 	if sigHdr == "" {
-		return errNotSigned
+		return VerifyResult{}, errNotSigned
 	}
 
-	paramHdr := msg.Header.Get("Signature-Input")
+	paramHdr := strings.Join(msg.Header.Values("Signature-Input"), ", ")
 	if paramHdr == "" {
-		return errNotSigned
+		return VerifyResult{}, errNotSigned
 	}
 
-	sigParts := strings.Split(sigHdr, ", ")
-	paramParts := strings.Split(paramHdr, ", ")
+	if err := checkLength("signature-input", msg.Header.Values("Signature-Input"), v.maxSignatureInputLength, defaultMaxSignatureInputLength); err != nil {
+		return VerifyResult{}, err
+	}
+
+	sigParts, err := v.splitMembers(sigHdr)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	paramParts, err := v.splitMembers(paramHdr)
+	if err != nil {
+		return VerifyResult{}, err
+	}
 
 	if len(sigParts) != len(paramParts) {
-		return errMalformedSignature
+		return VerifyResult{}, errMalformedSignature
 	}
 
 	// TODO: could be smarter about selecting the sig to verify, eg based
 	// on algorithm
 	var sigID string
-	var params *signatureParams
-	for _, p := range paramParts {
-		pParts := strings.SplitN(p, "=", 2)
-		if len(pParts) != 2 {
-			return errMalformedSignature
+	var params *SignatureParams
+	var ver verHolder
+	var firstID string
+	var first *SignatureParams
+	for i, p := range paramParts {
+		candidate, err := parseSignatureInput(p.value, v.strict)
+		if err != nil {
+			return VerifyResult{}, errMalformedSignature
 		}
 
-		candidate, err := parseSignatureInput(pParts[1])
-		if err != nil {
-			return errMalformedSignature
+		if i == 0 {
+			firstID = p.label
+			first = candidate
 		}
 
-		if _, ok := v.keys[candidate.keyID]; ok {
-			sigID = pParts[0]
+		if vh, ok := v.lookupKey(candidate.KeyID); ok {
+			sigID = p.label
 			params = candidate
+			ver = vh
 			break
 		}
 	}
 
+	// Fall back to the resolver for the first signature, if no key is known.
+	if params == nil && v.resolver != nil {
+		key, err := v.resolver.ResolveKey(ctx, first.KeyID)
+		if err != nil {
+			return VerifyResult{}, &UnknownKeyError{KeyID: first.KeyID, Err: err}
+		}
+
+		if !key.IsZero() {
+			sigID = firstID
+			params = first
+			ver = key.vh
+		}
+	}
+
+	// Otherwise try every key on the first signature, if the key id may be obfuscated.
+	trial := false
+	if params == nil && v.obfuscatedKeyID && len(v.keys) > 0 {
+		sigID, params, trial = firstID, first, true
+	}
+
 	if params == nil {
-		return errUnknownKey
+		return VerifyResult{}, &UnknownKeyError{KeyID: first.KeyID}
 	}
 
-	var signature string
-	for _, s := range sigParts {
-		sParts := strings.SplitN(s, "=", 2)
-		if len(sParts) != 2 {
-			return errMalformedSignature
+	if !trial {
+		if err := v.checkKeyExpiry(params.KeyID); err != nil {
+			return VerifyResult{}, err
 		}
+	}
 
-		if sParts[0] == sigID {
-			// TODO: error if not surrounded by colons
-			signature = strings.Trim(sParts[1], ":")
+	var sig []byte
+	for _, s := range sigParts {
+		if s.label == sigID {
+			sig, err = v.signatureBytes(s.value)
+			if err != nil {
+				return VerifyResult{}, err
+			}
 			break
 		}
 	}
 
-	if signature == "" {
-		return errMalformedSignature
+	if len(sig) == 0 {
+		return VerifyResult{}, errMalformedSignature
 	}
 
-	ver := v.keys[params.keyID]
-	if ver.alg != "" && params.alg != "" && ver.alg != params.alg {
-		return errAlgMismatch
+	if !trial {
+		if err := v.checkParams(params, ver); err != nil {
+			return VerifyResult{}, err
+		}
 	}
 
 	// verify signature. if invalid, error
-	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err := ctx.Err(); err != nil {
+		return VerifyResult{}, err
+	}
+
+	// TODO: wrap the errors within
+	base := getBuffer()
+	defer putBuffer(base)
+
+	msg, err = sanitizeHeaders(msg, params.Items, v.sanitize)
 	if err != nil {
-		return errMalformedSignature
+		return VerifyResult{}, err
+	}
+
+	if err := v.checkHeaderLengths(msg, params.Items); err != nil {
+		return VerifyResult{}, err
+	}
+
+	if err := writeSigningBase(base, params, v.custom.on(msg)); err != nil {
+		return VerifyResult{}, err
+	}
+
+	if trial {
+		return v.verifyAnyKey(ctx, params, base.Bytes(), sig)
+	}
+
+	return v.verifyBase(ctx, params, ver, base.Bytes(), sig)
+}
+
+// verifyAnyKey verifies sig with each key of v in turn, for WithObfuscatedKeyID. The result is
+// for the first key that verifies it, with that key's id.
+func (v *verifier) verifyAnyKey(ctx context.Context, params *SignatureParams, base, sig []byte) (VerifyResult, error) {
+	keyIDs := make([]string, 0, len(v.keys))
+	for k := range v.keys {
+		keyIDs = append(keyIDs, k)
+	}
+	sort.Strings(keyIDs)
+
+	// Why keys were skipped before cryptographic verification. If no key got that far, this is
+	// the real cause rather than an unknown key.
+	var skipped error
+	verified := false
+
+	for _, k := range keyIDs {
+		ver := v.keys[k]
+		if err := v.checkKeyExpiry(k); err != nil {
+			skipped = err
+			continue
+		}
+
+		if err := v.checkParams(params, ver); err != nil {
+			skipped = err
+			continue
+		}
+
+		verified = true
+		res, err := v.verifyBase(ctx, params, ver, base, sig)
+		if errors.Is(err, errInvalidSignature) {
+			continue
+		}
+
+		if err == nil {
+			res.KeyID = k
+		}
+		return res, err
+	}
+
+	if !verified && skipped != nil {
+		return VerifyResult{}, skipped
+	}
+
+	return VerifyResult{}, &UnknownKeyError{KeyID: params.KeyID}
+}
+
+// checkKeyExpiry returns a KeyExpiredError if keyID has expired, or was rotated out.
+func (v *verifier) checkKeyExpiry(keyID string) error {
+	if exp, ok := v.keyExpiry[keyID]; ok && !v.now().Before(exp) {
+		return &KeyExpiredError{KeyID: keyID, ExpiredAt: exp}
+	}
+
+	if m, ok := v.keyMeta[keyID]; ok {
+		if end := m.registeredAt.Add(m.overlap); !v.now().Before(end) {
+			return &KeyExpiredError{KeyID: keyID, ExpiredAt: end, ReplacedBy: m.replacedBy}
+		}
 	}
 
+	return nil
+}
+
+// checkParams returns an error if the algorithm, components or number of components of params
+// aren't accepted for the key ver.
+func (v *verifier) checkParams(params *SignatureParams, ver verHolder) error {
+	limit := v.maxComponents
+	if limit == 0 {
+		limit = defaultMaxComponents
+	}
+
+	if limit > 0 && len(params.Items) > limit {
+		return fmt.Errorf("%w: more than %d components", errMalformedSignature, limit)
+	}
+
+	if ver.alg != "" && params.Alg != "" && ver.alg != params.Alg {
+		return &AlgMismatchError{KeyID: params.KeyID, WantAlg: ver.alg, GotAlg: params.Alg}
+	}
+
+	if len(v.algs) > 0 {
+		alg := params.Alg
+		if alg == "" {
+			alg = ver.alg
+		}
+
+		if !sliceHas(v.algs, alg) {
+			return &AlgMismatchError{KeyID: params.KeyID, WantAlg: strings.Join(v.algs, ", "), GotAlg: alg}
+		}
+	}
+
+	return checkRequired(v.required, params.Items)
+}
+
+// verifyBase checks sig is the signature of base by ver, then checks the times and nonce of
+// params.
+func (v *verifier) verifyBase(ctx context.Context, params *SignatureParams, ver verHolder, base, sig []byte) (VerifyResult, error) {
 	verifier := ver.verifier()
+	if _, err := verifier.w.Write(base); err != nil {
+		return VerifyResult{}, err
+	}
+
+	if err := verifier.verify(sig); err != nil {
+		return VerifyResult{}, errInvalidSignature
+	}
 
-	//TODO: skip the buffer.
+	now := v.now()
 
-	var b bytes.Buffer
+	if params.Expires != nil && !now.Before(params.Expires.Add(v.skew)) {
+		return VerifyResult{}, errSignatureExpired
+	}
 
-	// canonicalize headers
-	// TODO: wrap the errors within
-	for _, h := range params.items {
-
-		// handle specialty components, section 2.3
-		var err error
-		switch h {
-		case "@method":
-			err = canonicalizeMethod(&b, msg.Method)
-		case "@path":
-			err = canonicalizePath(&b, msg.URL.Path)
-		case "@query":
-			err = canonicalizeQuery(&b, msg.URL.RawQuery)
-		case "@authority":
-			err = canonicalizeAuthority(&b, msg.Authority)
-		default:
-			// handle default (header) components
-			err = canonicalizeHeader(&b, h, msg.Header)
+	if v.createdWindow != 0 && params.Created != nil {
+		if d := now.Sub(*params.Created); d > v.createdWindow || d < -v.createdWindow {
+			return VerifyResult{}, errCreatedOutsideWindow
+		}
+	}
+
+	if v.maxAge != 0 {
+		if params.Created == nil && v.requireCreated {
+			return VerifyResult{}, &SignatureTooOldError{MaxAge: v.maxAge}
 		}
 
+		if params.Created != nil && now.Sub(*params.Created) > v.maxAge {
+			return VerifyResult{}, &SignatureTooOldError{Created: *params.Created, MaxAge: v.maxAge}
+		}
+	}
+
+	if v.nonceValidator != nil {
+		if err := v.nonceValidator(params.Nonce); err != nil {
+			return VerifyResult{}, err
+		}
+	}
+
+	if v.nonceStore != nil && params.Nonce != "" {
+		marked, err := v.nonceStore.Mark(ctx, params.Nonce)
 		if err != nil {
-			return err
+			return VerifyResult{}, err
+		}
+
+		if !marked {
+			return VerifyResult{}, errReplayedNonce
+		}
+	}
+
+	alg := ver.alg
+	if alg == "" {
+		alg = params.Alg
+	}
+
+	return VerifyResult{KeyID: params.KeyID, Alg: alg}, nil
+}
+
+// lookupKey returns the configured key for keyID, from the verifier's own keys, its
+// KeyStore, or derived from its master key.
+func (v *verifier) lookupKey(keyID string) (verHolder, bool) {
+	if vh, ok := v.keys[keyID]; ok {
+		return vh, true
+	}
+
+	if v.store != nil {
+		if key, ok := v.store.Lookup(keyID); ok && !key.IsZero() {
+			return key.vh, true
+		}
+	}
+
+	if v.derive != nil {
+		if secret, err := v.derive(keyID); err == nil && len(secret) > 0 {
+			return verifyHmacSha256(secret), true
+		}
+	}
+
+	return verHolder{}, false
+}
+
+type member struct {
+	label string
+	value string
+}
+
+// splitMembers splits a Signature or Signature-Input header into its labelled members. Unless
+// lenient, whitespace is only allowed after the commas between members.
+func (v *verifier) splitMembers(hdr string) ([]member, error) {
+	sep := ", "
+	if v.lenient {
+		sep = ","
+	}
+
+	var members []member
+	for _, m := range splitUnquoted(hdr, sep) {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 {
+			return nil, errMalformedSignature
+		}
+
+		label, value := parts[0], parts[1]
+		if v.lenient {
+			label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+		}
+
+		if label == "" || strings.ContainsAny(label, " \t") || strings.TrimSpace(value) != value {
+			return nil, errMalformedSignature
+		}
+
+		members = append(members, member{label: label, value: value})
+	}
+
+	return members, nil
+}
+
+// splitUnquoted splits s around each sep that isn't within a structured field string, like
+// `"a, b"`, so that strings can hold separators and escaped quotes.
+func splitUnquoted(s, sep string) []string {
+	var parts []string
+
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++ // skip the escaped character
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
 		}
 	}
 
-	if _, err := verifier.w.Write(b.Bytes()); err != nil {
-		return err
+	return append(parts, s[start:])
+}
+
+// signatureBytes decodes the signature value in, a structured field byte sequence like
+// `:c2ln:`, or its base64url equivalent. Unless lenient, the colons around it are required.
+func (v *verifier) signatureBytes(in string) ([]byte, error) {
+	if v.lenient {
+		if !strings.HasPrefix(in, ":") {
+			in = ":" + in
+		}
+		if len(in) < 2 || !strings.HasSuffix(in, ":") {
+			in += ":"
+		}
+	}
+
+	if v.base64URL {
+		return decodeByteSequence(in, base64.URLEncoding)
 	}
 
-	if err = canonicalizeSignatureParams(verifier.w, params); err != nil {
-		return err
+	return parseSFByteSequence(in)
+}
+
+// parseSFByteSequence decodes the structured field byte sequence s, like `:c2ln:`.
+func parseSFByteSequence(s string) ([]byte, error) {
+	return decodeByteSequence(s, base64.StdEncoding)
+}
+
+// serializeSFByteSequence encodes b as a structured field byte sequence, like `:c2ln:`.
+func serializeSFByteSequence(b []byte) string {
+	return encodeByteSequence(b, base64.StdEncoding)
+}
+
+// decodeByteSequence decodes s, which must be wrapped in colons, with enc.
+func decodeByteSequence(s string, enc *base64.Encoding) ([]byte, error) {
+	if len(s) < 2 || s[0] != ':' || s[len(s)-1] != ':' {
+		return nil, errMalformedSignature
 	}
 
-	err = verifier.verify(sig)
+	b, err := enc.DecodeString(s[1 : len(s)-1])
 	if err != nil {
-		return errInvalidSignature
+		return nil, errMalformedSignature
 	}
 
-	// TODO: could put in some wiggle room
-	if params.expires != nil && params.expires.After(time.Now()) {
-		return errSignatureExpired
+	return b, nil
+}
+
+// encodeByteSequence encodes b with enc, wrapped in colons.
+func encodeByteSequence(b []byte, enc *base64.Encoding) string {
+	return ":" + enc.EncodeToString(b) + ":"
+}
+
+// checkRequired returns a MissingComponentError for the first of required not in items.
+func checkRequired(required, items []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+
+	covered := make(map[string]bool, len(items))
+	for _, i := range items {
+		covered[normalizeComponentID(i)] = true
+	}
+
+	for _, r := range required {
+		if !covered[normalizeComponentID(r)] {
+			return &MissingComponentError{Component: r}
+		}
 	}
 
 	return nil
 }
 
+// normalizeComponentID returns the component identifier in, as SignatureParams items are
+// formatted, so identifiers can be compared.
+func normalizeComponentID(in string) string {
+	c, err := parseComponent(in)
+	if err != nil {
+		return in
+	}
+
+	return c.id()
+}
+
 // XXX use vice here too.
 
 var (
 	errNotSigned          = errors.New("signature headers not found")
 	errMalformedSignature = errors.New("unable to parse signature headers")
 	errUnknownKey         = errors.New("unknown key id")
+	errKeyExpired         = errors.New("key expired")
 	errAlgMismatch        = errors.New("algorithm mismatch for key id")
 	errSignatureExpired   = errors.New("signature expired")
 	errInvalidSignature   = errors.New("invalid signature")
+	errMissingHeader      = errors.New("header not found")
+	errMissingComponent   = errors.New("required component not signed")
+
+	errCreatedOutsideWindow = errors.New("signature created time outside of allowed window")
+	errSignatureTooOld      = errors.New("signature too old")
+	errBodyDigestMismatch   = errors.New("body does not match content digest")
+	errReplayedNonce        = errors.New("signature nonce already seen")
+	errHeaderValue          = errors.New("header value rejected")
 )
 
-// These error checking funcs aren't needed yet, so don't export them
+// UnknownKeyError is returned when none of the signatures on a message use a known key id.
+// KeyID holds the first key id found on the message. If a KeyResolver failed to find the key,
+// its error is kept in Err.
+type UnknownKeyError struct {
+	KeyID string
+	Err   error
+}
+
+func (e *UnknownKeyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %q: %s", errUnknownKey, e.KeyID, e.Err)
+	}
+	return fmt.Sprintf("%s: %q", errUnknownKey, e.KeyID)
+}
+
+func (e *UnknownKeyError) Is(target error) bool { return target == errUnknownKey }
+
+func (e *UnknownKeyError) Unwrap() error { return e.Err }
 
-/*
+// KeyExpiredError is returned when the key for a signature has passed its expiry, as set with
+// WithKeyExpiry.
+type KeyExpiredError struct {
+	KeyID     string
+	ExpiredAt time.Time
 
-func IsNotSignedError(err error) bool          { return errors.Is(err, notSignedError) }
-func IsMalformedSignatureError(err error) bool { return errors.Is(err, malformedSignatureError) }
-func IsUnknownKeyError(err error) bool         { return errors.Is(err, unknownKeyError) }
-func IsAlgMismatchError(err error) bool        { return errors.Is(err, algMismatchError) }
-func IsSignatureExpiredError(err error) bool   { return errors.Is(err, signatureExpiredError) }
-func IsInvalidSignatureError(err error) bool   { return errors.Is(err, invalidSignatureError) }
+	// ReplacedBy is the key id of the key that replaced KeyID, if it was rotated out with
+	// WithKeyRotation.
+	ReplacedBy string
+}
 
-*/
+func (e *KeyExpiredError) Error() string {
+	if e.ReplacedBy != "" {
+		return fmt.Sprintf("%s: %q at %s, replaced by %q", errKeyExpired, e.KeyID, e.ExpiredAt.Format(time.RFC3339), e.ReplacedBy)
+	}
+	return fmt.Sprintf("%s: %q at %s", errKeyExpired, e.KeyID, e.ExpiredAt.Format(time.RFC3339))
+}
+
+func (e *KeyExpiredError) Is(target error) bool { return target == errKeyExpired }
+
+// SignatureTooOldError is returned when a signature was created more than MaxAge ago, as set
+// with WithMaxSignatureAge. Created is zero for signatures without a `created` time, which
+// are rejected with WithRequireCreated.
+type SignatureTooOldError struct {
+	Created time.Time
+	MaxAge  time.Duration
+}
+
+func (e *SignatureTooOldError) Error() string {
+	if e.Created.IsZero() {
+		return fmt.Sprintf("%s: no created time, max age %s", errSignatureTooOld, e.MaxAge)
+	}
+	return fmt.Sprintf("%s: created at %s, max age %s", errSignatureTooOld, e.Created.Format(time.RFC3339), e.MaxAge)
+}
+
+func (e *SignatureTooOldError) Is(target error) bool { return target == errSignatureTooOld }
+
+// AlgMismatchError is returned when the algorithm declared in a signature does not match the
+// algorithm configured for its key id.
+type AlgMismatchError struct {
+	KeyID   string
+	WantAlg string
+	GotAlg  string
+}
+
+func (e *AlgMismatchError) Error() string {
+	return fmt.Sprintf("%s %q: want %q, got %q", errAlgMismatch, e.KeyID, e.WantAlg, e.GotAlg)
+}
+
+func (e *AlgMismatchError) Is(target error) bool { return target == errAlgMismatch }
+
+// MissingHeaderError is returned when a header covered by a signature is not present on the
+// message being canonicalized.
+type MissingHeaderError struct {
+	Header string
+}
+
+func (e *MissingHeaderError) Error() string {
+	return fmt.Sprintf("'%s' %s", e.Header, errMissingHeader)
+}
+
+func (e *MissingHeaderError) Is(target error) bool { return target == errMissingHeader }
+
+// HeaderValueError is returned when the value of a header covered by a signature is rejected
+// by the header sanitizer. See WithHeaderSanitization.
+type HeaderValueError struct {
+	Header string
+	Err    error
+}
+
+func (e *HeaderValueError) Error() string {
+	return fmt.Sprintf("'%s' %s: %s", e.Header, errHeaderValue, e.Err)
+}
+
+func (e *HeaderValueError) Is(target error) bool { return target == errHeaderValue }
+
+func (e *HeaderValueError) Unwrap() error { return e.Err }
+
+// MissingComponentError is returned when a signature does not cover a component required by
+// WithRequiredComponents.
+type MissingComponentError struct {
+	Component string
+}
+
+func (e *MissingComponentError) Error() string {
+	return fmt.Sprintf("'%s' %s", e.Component, errMissingComponent)
+}
+
+func (e *MissingComponentError) Is(target error) bool { return target == errMissingComponent }
+
+// IsNotSignedError reports whether err is caused by a message without signature headers.
+func IsNotSignedError(err error) bool { return errors.Is(err, errNotSigned) }
+
+// IsMalformedSignatureError reports whether err is caused by signature headers that can't be parsed.
+func IsMalformedSignatureError(err error) bool { return errors.Is(err, errMalformedSignature) }
+
+// IsUnknownKeyError reports whether err is caused by a signature with an unknown key id.
+// Use errors.As with an *UnknownKeyError for the key id.
+func IsUnknownKeyError(err error) bool { return errors.Is(err, errUnknownKey) }
+
+// IsKeyExpiredError reports whether err is caused by a signature using an expired key.
+// Use errors.As with a *KeyExpiredError for the key id.
+func IsKeyExpiredError(err error) bool { return errors.Is(err, errKeyExpired) }
+
+// IsAlgMismatchError reports whether err is caused by a signature algorithm that doesn't match
+// its key. Use errors.As with an *AlgMismatchError for details.
+func IsAlgMismatchError(err error) bool { return errors.Is(err, errAlgMismatch) }
+
+// IsSignatureExpiredError reports whether err is caused by an expired signature.
+func IsSignatureExpiredError(err error) bool { return errors.Is(err, errSignatureExpired) }
+
+// IsCreatedOutsideWindowError reports whether err is caused by a signature created too far in
+// the past or future. See WithCreatedWindow.
+func IsCreatedOutsideWindowError(err error) bool { return errors.Is(err, errCreatedOutsideWindow) }
+
+// IsSignatureTooOldError reports whether err is caused by a signature created too long ago, or
+// without a created time when one is required. Use errors.As with a *SignatureTooOldError for
+// details. See WithMaxSignatureAge.
+func IsSignatureTooOldError(err error) bool { return errors.Is(err, errSignatureTooOld) }
+
+// IsBodyDigestMismatchError reports whether err is caused by a missing `Content-Digest` header,
+// or one that does not match the body.
+func IsBodyDigestMismatchError(err error) bool { return errors.Is(err, errBodyDigestMismatch) }
+
+// IsMissingComponentError reports whether err is caused by a signature that doesn't cover a
+// required component. Use errors.As with a *MissingComponentError for the component.
+func IsMissingComponentError(err error) bool { return errors.Is(err, errMissingComponent) }
+
+// IsReplayedNonceError reports whether err is caused by a signature with a nonce that has been
+// seen before. See WithNonceStore.
+func IsReplayedNonceError(err error) bool { return errors.Is(err, errReplayedNonce) }
+
+// IsInvalidSignatureError reports whether err is caused by a signature that does not verify.
+func IsInvalidSignatureError(err error) bool { return errors.Is(err, errInvalidSignature) }
+
+// IsMissingHeaderError reports whether err is caused by a signed header missing from the
+// message. Use errors.As with a *MissingHeaderError for the header name.
+func IsMissingHeaderError(err error) bool { return errors.Is(err, errMissingHeader) }
+
+// IsHeaderValueError reports whether err is caused by a signed header value rejected by the
+// header sanitizer. Use errors.As with a *HeaderValueError for the header name.
+func IsHeaderValueError(err error) bool { return errors.Is(err, errHeaderValue) }
 
 func verifyRsaPssSha512(pk *rsa.PublicKey) verHolder {
 	return verHolder{
 		alg: "rsa-pss-sha512",
 		verifier: func() verImpl {
-			h := sha512.New()
+			h := sha512Pool.get()
 
 			return verImpl{
 				w: h,
 				verify: func(s []byte) error {
 					b := h.Sum(nil)
+					sha512Pool.put(h)
 
 					return rsa.VerifyPSS(pk, crypto.SHA512, b, s, nil)
 				},
@@ -204,18 +902,105 @@ func verifyRsaPssSha512(pk *rsa.PublicKey) verHolder {
 	}
 }
 
+func verifyRsaPkcs1Sha256(pk *rsa.PublicKey) verHolder {
+	return verHolder{
+		alg: "rsa-pkcs1-sha256",
+		verifier: func() verImpl {
+			h := sha256Pool.get()
+
+			return verImpl{
+				w: h,
+				verify: func(s []byte) error {
+					b := h.Sum(nil)
+					sha256Pool.put(h)
+
+					return rsa.VerifyPKCS1v15(pk, crypto.SHA256, b, s)
+				},
+			}
+		},
+	}
+}
+
+func verifyRsaPkcs1Sha512(pk *rsa.PublicKey) verHolder {
+	return verHolder{
+		alg: "rsa-pkcs1-sha512",
+		verifier: func() verImpl {
+			h := sha512Pool.get()
+
+			return verImpl{
+				w: h,
+				verify: func(s []byte) error {
+					b := h.Sum(nil)
+					sha512Pool.put(h)
+
+					return rsa.VerifyPKCS1v15(pk, crypto.SHA512, b, s)
+				},
+			}
+		},
+	}
+}
+
 func verifyEccP256(pk *ecdsa.PublicKey) verHolder {
 	return verHolder{
 		alg: "ecdsa-p256-sha256",
 		verifier: func() verImpl {
-			h := sha256.New()
+			h := sha256Pool.get()
+
+			return verImpl{
+				w: h,
+				verify: func(s []byte) error {
+					b := h.Sum(nil)
+					sha256Pool.put(h)
+
+					if pk.Curve != elliptic.P256() || !ecdsa.VerifyASN1(pk, b, s) {
+						return errInvalidSignature
+					}
+
+					return nil
+				},
+			}
+		},
+	}
+}
+
+func verifyEccP384(pk *ecdsa.PublicKey) verHolder {
+	return verHolder{
+		alg: "ecdsa-p384-sha384",
+		verifier: func() verImpl {
+			h := sha384Pool.get()
 
 			return verImpl{
 				w: h,
 				verify: func(s []byte) error {
 					b := h.Sum(nil)
+					sha384Pool.put(h)
 
-					if !ecdsa.VerifyASN1(pk, b, s) {
+					// A key on another curve must never validate, even if the
+					// signature happens to parse.
+					if pk.Curve != elliptic.P384() || !ecdsa.VerifyASN1(pk, b, s) {
+						return errInvalidSignature
+					}
+
+					return nil
+				},
+			}
+		},
+	}
+}
+
+func verifyEccP521(pk *ecdsa.PublicKey) verHolder {
+	return verHolder{
+		alg: "ecdsa-p521-sha512",
+		verifier: func() verImpl {
+			h := sha512Pool.get()
+
+			return verImpl{
+				w: h,
+				verify: func(s []byte) error {
+					b := h.Sum(nil)
+					sha512Pool.put(h)
+
+					if pk.Curve != elliptic.P521() || !ecdsa.VerifyASN1(pk, b, s) {
 						return errInvalidSignature
 					}
 
@@ -227,16 +1012,69 @@ func verifyEccP256(pk *ecdsa.PublicKey) verHolder {
 }
 
 func verifyHmacSha256(secret []byte) verHolder {
+	hp := newHashPool(func() hash.Hash { return hmac.New(sha256.New, secret) })
+
 	// TODO: add alg
 	return verHolder{
 		alg: "hmac-sha256",
 		verifier: func() verImpl {
-			h := hmac.New(sha256.New, secret)
+			h := hp.get()
 
 			return verImpl{
 				w: h,
 				verify: func(in []byte) error {
-					if !hmac.Equal(in, h.Sum(nil)) {
+					sum := h.Sum(nil)
+					hp.put(h)
+
+					if !hmac.Equal(in, sum) {
+						return errInvalidSignature
+					}
+					return nil
+				},
+			}
+		},
+	}
+}
+
+func verifyHmacSha384(secret []byte) verHolder {
+	hp := newHashPool(func() hash.Hash { return hmac.New(sha512.New384, secret) })
+
+	return verHolder{
+		alg: "hmac-sha384",
+		verifier: func() verImpl {
+			h := hp.get()
+
+			return verImpl{
+				w: h,
+				verify: func(in []byte) error {
+					sum := h.Sum(nil)
+					hp.put(h)
+
+					if !hmac.Equal(in, sum) {
+						return errInvalidSignature
+					}
+					return nil
+				},
+			}
+		},
+	}
+}
+
+func verifyHmacSha512(secret []byte) verHolder {
+	hp := newHashPool(func() hash.Hash { return hmac.New(sha512.New, secret) })
+
+	return verHolder{
+		alg: "hmac-sha512",
+		verifier: func() verImpl {
+			h := hp.get()
+
+			return verImpl{
+				w: h,
+				verify: func(in []byte) error {
+					sum := h.Sum(nil)
+					hp.put(h)
+
+					if !hmac.Equal(in, sum) {
 						return errInvalidSignature
 					}
 					return nil
@@ -0,0 +1,1127 @@
+// Copyright (c) 2021 James Bowes. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghoti143/httpsig/internal/sfv"
+)
+
+var (
+	testRSAOnce sync.Once
+	testRSAKey  *rsa.PrivateKey
+)
+
+// rsaTestKey returns a lazily generated RSA key, shared between tests as generation is slow.
+func rsaTestKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+
+	testRSAOnce.Do(func() {
+		var err error
+		testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic("could not generate test rsa key: " + err.Error())
+		}
+	})
+
+	return testRSAKey
+}
+
+func testSigner(keyID string, sh sigHolder) *signer {
+	return &signer{
+		headers: []string{"@authority", "date", "content-type"},
+		keys:    map[string]sigHolder{keyID: sh},
+		created: true,
+		nowFunc: func() time.Time { return time.Unix(1618884475, 0) },
+	}
+}
+
+func testVerifier(keyID string, vh verHolder) *verifier {
+	return &verifier{
+		keys:    map[string]verHolder{keyID: vh},
+		nowFunc: func() time.Time { return time.Unix(1618884475, 0) },
+	}
+}
+
+// signMessage signs msg with s, and sets the resulting signature headers on msg.
+func signMessage(t testing.TB, s *signer, msg *Message) {
+	t.Helper()
+
+	hdr, err := s.Sign(msg)
+	if err != nil {
+		t.Fatal("signing failed:", err)
+	}
+
+	for k, v := range hdr {
+		msg.Header[k] = v
+	}
+}
+
+func TestVerify_RsaPkcs1Sha256(t *testing.T) {
+	pk := rsaTestKey(t)
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-rsa", signRsaPkcs1Sha256(pk)), req)
+
+	if !strings.Contains(req.Header.Get("Signature-Input"), `alg="rsa-pkcs1-sha256"`) {
+		t.Error("signature input is missing alg. Got:", req.Header.Get("Signature-Input"))
+	}
+
+	if _, err := testVerifier("test-key-rsa", verifyRsaPkcs1Sha256(&pk.PublicKey)).Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestVerify_RsaPkcs1Sha256_RejectedByPss(t *testing.T) {
+	pk := rsaTestKey(t)
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-rsa", signRsaPkcs1Sha256(pk)), req)
+
+	v := testVerifier("test-key-rsa", verifyRsaPssSha512(&pk.PublicKey))
+	if _, err := v.Verify(req); !errors.Is(err, errAlgMismatch) {
+		t.Error("expected alg mismatch. Got:", err)
+	}
+
+	// Without an alg parameter to compare, the signature itself must fail.
+	s := testSigner("test-key-rsa", signRsaPkcs1Sha256(pk))
+	sh := s.keys["test-key-rsa"]
+	sh.alg = ""
+	s.keys["test-key-rsa"] = sh
+
+	req = testReq()
+	signMessage(t, s, req)
+
+	if _, err := v.Verify(req); !errors.Is(err, errInvalidSignature) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestVerify_RsaPkcs1Sha512(t *testing.T) {
+	pk := rsaTestKey(t)
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-rsa", signRsaPkcs1Sha512(pk)), req)
+
+	if _, err := testVerifier("test-key-rsa", verifyRsaPkcs1Sha512(&pk.PublicKey)).Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if _, err := testVerifier("test-key-rsa", verifyRsaPkcs1Sha256(&pk.PublicKey)).Verify(req); !errors.Is(err, errAlgMismatch) {
+		t.Error("expected alg mismatch. Got:", err)
+	}
+}
+
+func TestVerify_RsaPssSha512(t *testing.T) {
+	pk := rsaTestKey(t)
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-rsa-pss", signRsaPssSha512(pk)), req)
+
+	if _, err := testVerifier("test-key-rsa-pss", verifyRsaPssSha512(&pk.PublicKey)).Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestVerify_EcdsaP384Sha384(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-ecc", signEccP384(pk)), req)
+
+	if _, err := testVerifier("test-key-ecc", verifyEccP384(&pk.PublicKey)).Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+}
+
+func TestVerify_EcdsaP384Sha384_WrongCurve(t *testing.T) {
+	pk384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	pk256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-ecc", signEccP384(pk384)), req)
+
+	// A p256 key registered for p384 verification, under the same key id.
+	if _, err := testVerifier("test-key-ecc", verifyEccP384(&pk256.PublicKey)).Verify(req); !errors.Is(err, errInvalidSignature) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+
+	if _, err := testVerifier("test-key-ecc", verifyEccP256(&pk256.PublicKey)).Verify(req); !errors.Is(err, errAlgMismatch) {
+		t.Error("expected alg mismatch. Got:", err)
+	}
+}
+
+func TestVerify_Ecdsa_WrongCurve(t *testing.T) {
+	keys := make(map[elliptic.Curve]*ecdsa.PrivateKey)
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		pk, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal("could not generate key:", err)
+		}
+		keys[c] = pk
+	}
+
+	// Each verifier is given a key on another curve, that signed with the expected hash.
+	tcs := map[string]struct {
+		sh sigHolder
+		vh verHolder
+	}{
+		"p256": {signEccP256(keys[elliptic.P384()]), verifyEccP256(&keys[elliptic.P384()].PublicKey)},
+		"p384": {signEccP384(keys[elliptic.P521()]), verifyEccP384(&keys[elliptic.P521()].PublicKey)},
+		"p521": {signEccP521(keys[elliptic.P256()]), verifyEccP521(&keys[elliptic.P256()].PublicKey)},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			req := testReq()
+			signMessage(t, testSigner("test-key-ecc", tc.sh), req)
+
+			if _, err := testVerifier("test-key-ecc", tc.vh).Verify(req); !errors.Is(err, errInvalidSignature) {
+				t.Error("expected invalid signature. Got:", err)
+			}
+		})
+	}
+}
+
+func TestVerify_EcdsaP521Sha512(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-ecc", signEccP521(pk)), req)
+
+	if _, err := testVerifier("test-key-ecc", verifyEccP521(&pk.PublicKey)).Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	// Tampering after signing must be caught.
+	req.Header.Set("Content-Type", "text/plain")
+	if _, err := testVerifier("test-key-ecc", verifyEccP521(&pk.PublicKey)).Verify(req); !errors.Is(err, errInvalidSignature) {
+		t.Error("expected invalid signature. Got:", err)
+	}
+}
+
+func TestVerify_EcdsaP521Sha512_AlgMismatch(t *testing.T) {
+	pk256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	pk521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	req := testReq()
+	signMessage(t, testSigner("test-key-ecc", signEccP256(pk256)), req)
+
+	if _, err := testVerifier("test-key-ecc", verifyEccP521(&pk521.PublicKey)).Verify(req); !errors.Is(err, errAlgMismatch) {
+		t.Error("expected alg mismatch. Got:", err)
+	}
+}
+
+func TestVerify_Hmac(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	wrong := []byte("the-cat-bonnet-store-is-closed")
+
+	variants := []struct {
+		name   string
+		sign   func([]byte) sigHolder
+		verify func([]byte) verHolder
+	}{
+		{"hmac-sha256", signHmacSha256, verifyHmacSha256},
+		{"hmac-sha384", signHmacSha384, verifyHmacSha384},
+		{"hmac-sha512", signHmacSha512, verifyHmacSha512},
+	}
+
+	for i, variant := range variants {
+		other := variants[(i+1)%len(variants)]
+
+		// hmac-sha256 signatures don't carry an alg, so a variant mismatch is only
+		// caught by the mac itself.
+		mismatch := errAlgMismatch
+		if variant.sign(secret).alg == "" {
+			mismatch = errInvalidSignature
+		}
+
+		tcs := []struct {
+			name   string
+			ver    verHolder
+			tamper func(*Message)
+			err    error
+		}{
+			{"valid", variant.verify(secret), func(*Message) {}, nil},
+			{"wrong secret", variant.verify(wrong), func(*Message) {}, errInvalidSignature},
+			{"wrong variant", other.verify(secret), func(*Message) {}, mismatch},
+			{"tampered", variant.verify(secret), func(m *Message) { m.Header.Set("Date", "Wed, 21 Apr 2021 02:07:55 GMT") }, errInvalidSignature},
+		}
+
+		for _, tc := range tcs {
+			t.Run(variant.name+" "+tc.name, func(t *testing.T) {
+				req := testReq()
+				signMessage(t, testSigner("test-shared-secret", variant.sign(secret)), req)
+				tc.tamper(req)
+
+				_, err := testVerifier("test-shared-secret", tc.ver).Verify(req)
+				if !errors.Is(err, tc.err) {
+					t.Errorf("expected %v. Got: %v", tc.err, err)
+				}
+			})
+		}
+	}
+}
+
+func TestVerify_ErrorTypes(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	t.Run("unknown key", func(t *testing.T) {
+		req := testReq()
+		signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+
+		_, err := testVerifier("other-key", verifyHmacSha256(secret)).Verify(req)
+		if !IsUnknownKeyError(err) {
+			t.Fatal("expected unknown key error. Got:", err)
+		}
+
+		var uerr *UnknownKeyError
+		if !errors.As(err, &uerr) || uerr.KeyID != "some-key" {
+			t.Error("expected key id on error. Got:", err)
+		}
+	})
+
+	t.Run("alg mismatch", func(t *testing.T) {
+		req := testReq()
+		signMessage(t, testSigner("some-key", signHmacSha512(secret)), req)
+
+		_, err := testVerifier("some-key", verifyHmacSha256(secret)).Verify(req)
+		if !IsAlgMismatchError(err) {
+			t.Fatal("expected alg mismatch error. Got:", err)
+		}
+
+		var aerr *AlgMismatchError
+		if !errors.As(err, &aerr) {
+			t.Fatal("expected alg mismatch error type. Got:", err)
+		}
+
+		if aerr.KeyID != "some-key" || aerr.WantAlg != "hmac-sha256" || aerr.GotAlg != "hmac-sha512" {
+			t.Errorf("unexpected error details: %+v", aerr)
+		}
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		req := testReq()
+		signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+		req.Header.Del("Date")
+
+		_, err := testVerifier("some-key", verifyHmacSha256(secret)).Verify(req)
+		if !IsMissingHeaderError(err) {
+			t.Fatal("expected missing header error. Got:", err)
+		}
+
+		var herr *MissingHeaderError
+		if !errors.As(err, &herr) || herr.Header != "date" {
+			t.Error("expected header name on error. Got:", err)
+		}
+	})
+}
+
+func TestVerifyWithContext_Canceled(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	req := testReq()
+	signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := testVerifier("some-key", verifyHmacSha256(secret)).VerifyWithContext(ctx, req)
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected context canceled. Got:", err)
+	}
+}
+
+// hmacSignParams signs msg using hmac-sha256 with the exact signature params given, for
+// exercising params the signer doesn't produce. Only header components are supported.
+func hmacSignParams(t testing.TB, msg *Message, secret []byte, sp *SignatureParams) {
+	t.Helper()
+
+	si := signHmacSha256(secret).signer()
+	for _, h := range sp.Items {
+		if err := canonicalizeHeader(si.w, h, msg.Header); err != nil {
+			t.Fatal("could not canonicalize header:", err)
+		}
+	}
+
+	if err := canonicalizeSignatureParams(si.w, sp); err != nil {
+		t.Fatal("could not canonicalize signature params:", err)
+	}
+
+	sig, err := si.sign()
+	if err != nil {
+		t.Fatal("could not sign:", err)
+	}
+
+	msg.Header.Set("Signature-Input", "sig1="+sp.String())
+	msg.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+func TestVerify_Expires(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	created := time.Unix(1618884475, 0)
+
+	tcs := []struct {
+		name    string
+		expires time.Time
+		skew    time.Duration
+		err     error
+	}{
+		{"future", created.Add(time.Minute), 0, nil},
+		{"past", created.Add(-time.Minute), 0, errSignatureExpired},
+		{"now", created, 0, errSignatureExpired},
+		{"past within skew", created.Add(-time.Minute), 2 * time.Minute, nil},
+		{"past outside skew", created.Add(-time.Minute), 30 * time.Second, errSignatureExpired},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			expires := tc.expires
+
+			req := testReq()
+			hmacSignParams(t, req, secret, &SignatureParams{
+				Items:   []string{"date"},
+				KeyID:   "some-key",
+				Created: &created,
+				Expires: &expires,
+			})
+
+			v := testVerifier("some-key", verifyHmacSha256(secret))
+			v.skew = tc.skew
+
+			if _, err := v.Verify(req); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v. Got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestVerify_CreatedWindow(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	created := time.Unix(1618884475, 0)
+
+	tcs := []struct {
+		name   string
+		now    time.Time
+		window time.Duration
+		err    error
+	}{
+		{"disabled", created.Add(time.Hour), 0, nil},
+		{"within window", created.Add(time.Minute), 5 * time.Minute, nil},
+		{"too old", created.Add(time.Hour), 5 * time.Minute, errCreatedOutsideWindow},
+		{"in the future", created.Add(-time.Hour), 5 * time.Minute, errCreatedOutsideWindow},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := testReq()
+			signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+
+			v := testVerifier("some-key", verifyHmacSha256(secret))
+			v.createdWindow = tc.window
+			v.nowFunc = func() time.Time { return tc.now }
+
+			if _, err := v.Verify(req); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v. Got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestVerify_MaxSignatureAge(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	created := time.Unix(1618884475, 0)
+
+	tcs := []struct {
+		name           string
+		created        bool
+		now            time.Time
+		requireCreated bool
+		err            error
+	}{
+		{"no created", false, created.Add(time.Hour), false, nil},
+		{"no created required", false, created.Add(time.Hour), true, errSignatureTooOld},
+		{"within max age", true, created.Add(time.Minute), false, nil},
+		{"within max age required", true, created.Add(time.Minute), true, nil},
+		{"too old", true, created.Add(time.Hour), false, errSignatureTooOld},
+		{"too old required", true, created.Add(time.Hour), true, errSignatureTooOld},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testSigner("some-key", signHmacSha256(secret))
+			s.created = tc.created
+
+			req := testReq()
+			signMessage(t, s, req)
+
+			v := testVerifier("some-key", verifyHmacSha256(secret))
+			v.maxAge = 5 * time.Minute
+			v.requireCreated = tc.requireCreated
+			v.nowFunc = func() time.Time { return tc.now }
+
+			if _, err := v.Verify(req); !errors.Is(err, tc.err) {
+				t.Errorf("expected %v. Got: %v", tc.err, err)
+			}
+		})
+	}
+
+	// Either check rejects the signature.
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.expires = time.Minute
+
+	req := testReq()
+	signMessage(t, s, req)
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	v.maxAge = time.Hour
+	v.nowFunc = func() time.Time { return created.Add(2 * time.Minute) }
+
+	if _, err := v.Verify(req); !IsSignatureExpiredError(err) {
+		t.Error("expected expired signature. Got:", err)
+	}
+
+	v.maxAge = 30 * time.Second
+	v.skew = time.Hour
+
+	var tooOld *SignatureTooOldError
+	if _, err := v.Verify(req); !errors.As(err, &tooOld) || tooOld.MaxAge != 30*time.Second || !tooOld.Created.Equal(created) {
+		t.Error("expected signature too old. Got:", err)
+	}
+}
+
+func TestVerify_Nonce(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.nonceFunc = func() string { return "b3k2pp5k7z-50gnwp.yemd" }
+
+	req := testReq()
+	signMessage(t, s, req)
+
+	sp, err := ParseSignatureInput(strings.TrimPrefix(req.Header.Get("Signature-Input"), "sig1="))
+	if err != nil {
+		t.Fatal("could not parse signature input:", err)
+	}
+
+	if sp.Nonce != "b3k2pp5k7z-50gnwp.yemd" {
+		t.Error("nonce did not round trip. Got:", sp.Nonce)
+	}
+
+	seen := map[string]bool{}
+	errReplayed := errors.New("replayed")
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	v.nonceValidator = func(nonce string) error {
+		if seen[nonce] {
+			return errReplayed
+		}
+		seen[nonce] = true
+		return nil
+	}
+
+	if _, err := v.Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	if _, err := v.Verify(req); !errors.Is(err, errReplayed) {
+		t.Error("expected replayed nonce to fail. Got:", err)
+	}
+}
+
+// mockResolver is a KeyResolver backed by a map, counting lookups.
+type mockResolver struct {
+	keys  map[string]verHolder
+	err   error
+	calls int
+}
+
+func (m *mockResolver) ResolveKey(_ context.Context, keyID string) (VerificationKey, error) {
+	m.calls++
+	if m.err != nil {
+		return VerificationKey{}, m.err
+	}
+
+	return VerificationKey{vh: m.keys[keyID]}, nil
+}
+
+func TestVerify_KeyResolver(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	req := testReq()
+	signMessage(t, testSigner("dynamic-key", signHmacSha256(secret)), req)
+
+	t.Run("resolved", func(t *testing.T) {
+		r := &mockResolver{keys: map[string]verHolder{"dynamic-key": verifyHmacSha256(secret)}}
+		v := testVerifier("static-key", verifyHmacSha256(secret))
+		v.resolver = r
+
+		if _, err := v.Verify(req); err != nil {
+			t.Error("verification failed:", err)
+		}
+
+		if r.calls != 1 {
+			t.Error("expected one resolver call. Got:", r.calls)
+		}
+	})
+
+	t.Run("static key first", func(t *testing.T) {
+		r := &mockResolver{}
+		v := testVerifier("dynamic-key", verifyHmacSha256(secret))
+		v.resolver = r
+
+		if _, err := v.Verify(req); err != nil {
+			t.Error("verification failed:", err)
+		}
+
+		if r.calls != 0 {
+			t.Error("expected no resolver calls. Got:", r.calls)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		v := testVerifier("static-key", verifyHmacSha256(secret))
+		v.resolver = &mockResolver{}
+
+		if _, err := v.Verify(req); !IsUnknownKeyError(err) {
+			t.Error("expected unknown key error. Got:", err)
+		}
+	})
+
+	t.Run("resolver error", func(t *testing.T) {
+		errLookup := errors.New("database is down")
+
+		v := testVerifier("static-key", verifyHmacSha256(secret))
+		v.resolver = &mockResolver{err: errLookup}
+
+		_, err := v.Verify(req)
+		if !IsUnknownKeyError(err) || !errors.Is(err, errLookup) {
+			t.Error("expected wrapped unknown key error. Got:", err)
+		}
+	})
+}
+
+func TestVerify_Result(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	req := testReq()
+	signMessage(t, testSigner("some-key", signHmacSha384(secret)), req)
+
+	res, err := testVerifier("some-key", verifyHmacSha384(secret)).Verify(req)
+	if err != nil {
+		t.Fatal("verification failed:", err)
+	}
+
+	if res.KeyID != "some-key" || res.Alg != "hmac-sha384" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	// Failed verification has no result.
+	res, err = testVerifier("some-key", verifyHmacSha512(secret)).Verify(req)
+	if err == nil || res != (VerifyResult{}) {
+		t.Errorf("expected empty result and error. Got: %+v, %v", res, err)
+	}
+}
+
+func TestVerify_RequiredComponents(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	s := testSigner("some-key", signHmacSha256(secret))
+	s.headers = []string{"@method", "@query-param;name=pet", "date"}
+
+	req := testReq()
+	signMessage(t, s, req)
+
+	tcs := []struct {
+		name     string
+		required []string
+		missing  string
+	}{
+		{"none", nil, ""},
+		{"present", []string{"@method", "date"}, ""},
+		{"present with params", []string{`"@query-param";name="pet"`}, ""},
+		{"absent", []string{"@method", "@path", "content-digest"}, "@path"},
+		{"absent param", []string{"@query-param;name=param"}, "@query-param;name=param"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v := testVerifier("some-key", verifyHmacSha256(secret))
+			v.required = tc.required
+
+			_, err := v.Verify(req)
+			if tc.missing == "" {
+				if err != nil {
+					t.Error("verification failed:", err)
+				}
+				return
+			}
+
+			var mce *MissingComponentError
+			if !IsMissingComponentError(err) || !errors.As(err, &mce) || mce.Component != tc.missing {
+				t.Errorf("expected missing component %q. Got: %v", tc.missing, err)
+			}
+		})
+	}
+}
+
+// Regression test for signatures with a future expiry being rejected as expired, checked
+// against the wall clock rather than a fixed time.
+func TestVerify_ExpiresWallClock(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	for _, tc := range []struct {
+		name    string
+		expires time.Duration
+		expired bool
+	}{
+		{"future", time.Hour, false},
+		{"past", -time.Hour, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			created := time.Now().Add(-time.Second)
+			expires := time.Now().Add(tc.expires)
+
+			req := testReq()
+			hmacSignParams(t, req, secret, &SignatureParams{
+				Items:   []string{"date"},
+				KeyID:   "some-key",
+				Created: &created,
+				Expires: &expires,
+			})
+
+			v := testVerifier("some-key", verifyHmacSha256(secret))
+			v.nowFunc = time.Now
+
+			_, err := v.Verify(req)
+			if tc.expired != IsSignatureExpiredError(err) || (!tc.expired && err != nil) {
+				t.Errorf("expected expired to be %t. Got: %v", tc.expired, err)
+			}
+		})
+	}
+}
+
+func TestVerify_LenientParsing(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	req := testReq()
+	signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+
+	sigInput := req.Header.Get("Signature-Input")
+	sig := req.Header.Get("Signature")
+
+	label := strings.SplitN(sig, "=", 2)[0]
+	value := strings.SplitN(sig, "=", 2)[1]
+	input := strings.SplitN(sigInput, "=", 2)[1]
+
+	tcs := []struct {
+		name       string
+		sigInput   string
+		sig        string
+		wellFormed bool
+	}{
+		{"well formed", sigInput, sig, true},
+		{"spaces around equals", label + " = " + input, label + " = " + value, false},
+		{"missing colons", sigInput, label + "=" + strings.Trim(value, ":"), false},
+		{"no space after comma", sigInput + ",other=(\"date\");keyid=\"other\"", sig + ",other=:c2ln:", false},
+	}
+
+	for _, tc := range tcs {
+		for _, lenient := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s lenient %t", tc.name, lenient), func(t *testing.T) {
+				msg := testReq()
+				msg.Header.Set("Signature-Input", tc.sigInput)
+				msg.Header.Set("Signature", tc.sig)
+
+				v := testVerifier("some-key", verifyHmacSha256(secret))
+				v.lenient = lenient
+
+				_, err := v.Verify(msg)
+				switch {
+				case tc.wellFormed || lenient:
+					if err != nil {
+						t.Error("verification failed:", err)
+					}
+				case !IsMalformedSignatureError(err):
+					t.Error("expected malformed signature. Got:", err)
+				}
+			})
+		}
+	}
+}
+
+func TestSFByteSequence(t *testing.T) {
+	b, err := parseSFByteSequence(serializeSFByteSequence([]byte("sig")))
+	if err != nil || string(b) != "sig" {
+		t.Errorf("round trip failed. Got: %q %v", b, err)
+	}
+
+	for _, in := range []string{"", ":", "c2ln", ":c2ln", "c2ln:", "::c2ln::", ":c2l*:"} {
+		if _, err := parseSFByteSequence(in); !IsMalformedSignatureError(err) {
+			t.Errorf("expected %q to be malformed. Got: %v", in, err)
+		}
+	}
+}
+
+func TestVerify_StrictMode(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	input := `("@authority" "date");created=1618884475;keyid="some-key";foo="bar"`
+
+	// Sign by hand, as the signer doesn't add unknown parameters.
+	sp, err := ParseSignatureInput(input)
+	if err != nil {
+		t.Fatal("could not parse signature input:", err)
+	}
+
+	if sp.String() != input {
+		t.Error("unknown parameter not kept. Got:", sp.String())
+	}
+
+	req := testReq()
+
+	var base bytes.Buffer
+	if err := writeSigningBase(&base, sp, req); err != nil {
+		t.Fatal("could not write signature base:", err)
+	}
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(base.Bytes())
+
+	req.Header.Set("Signature-Input", "sig1="+input)
+	req.Header.Set("Signature", "sig1="+serializeSFByteSequence(h.Sum(nil)))
+
+	v := testVerifier("some-key", verifyHmacSha256(secret))
+	if _, err := v.Verify(req); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	v.strict = true
+	if _, err := v.Verify(req); !IsMalformedSignatureError(err) {
+		t.Error("expected malformed signature. Got:", err)
+	}
+
+	// Known parameters pass in strict mode.
+	req = testReq()
+	signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+	if _, err := v.Verify(req); err != nil {
+		t.Error("strict verification failed:", err)
+	}
+}
+
+func TestVerify_AlgorithmAllowlist(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key:", err)
+	}
+
+	hmacReq := testReq()
+	signMessage(t, testSigner("hmac-key", signHmacSha256(secret)), hmacReq)
+
+	eccReq := testReq()
+	signMessage(t, testSigner("ecc-key", signEccP256(pk)), eccReq)
+
+	v := &verifier{
+		keys: map[string]verHolder{
+			"hmac-key": verifyHmacSha256(secret),
+			"ecc-key":  verifyEccP256(&pk.PublicKey),
+		},
+		nowFunc: func() time.Time { return time.Unix(1618884475, 0) },
+	}
+
+	tcs := []struct {
+		name    string
+		algs    []string
+		hmacErr bool
+		eccErr  bool
+	}{
+		{"empty", nil, false, false},
+		{"ecdsa only", []string{"ecdsa-p256-sha256"}, true, false},
+		{"hmac only", []string{"hmac-sha256"}, false, true},
+		{"both", []string{"hmac-sha256", "ecdsa-p256-sha256"}, false, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v.algs = tc.algs
+
+			for _, c := range []struct {
+				req     *Message
+				wantErr bool
+			}{{hmacReq, tc.hmacErr}, {eccReq, tc.eccErr}} {
+				_, err := v.Verify(c.req)
+				if c.wantErr && !IsAlgMismatchError(err) {
+					t.Error("expected alg mismatch. Got:", err)
+				}
+
+				if !c.wantErr && err != nil {
+					t.Error("verification failed:", err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify_KeyExpiry(t *testing.T) {
+	secret := []byte("support-your-local-cat-bonnet-store")
+	now := time.Unix(1618884475, 0)
+
+	req := testReq()
+	signMessage(t, testSigner("some-key", signHmacSha256(secret)), req)
+
+	tcs := []struct {
+		name    string
+		expiry  time.Time
+		expired bool
+	}{
+		{"not expired", now.Add(time.Second), false},
+		{"just expired", now, true},
+		{"long expired", now.Add(-time.Hour), true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v := testVerifier("some-key", verifyHmacSha256(secret))
+			v.keyExpiry = map[string]time.Time{"some-key": tc.expiry}
+
+			_, err := v.Verify(req)
+			if !tc.expired {
+				if err != nil {
+					t.Error("verification failed:", err)
+				}
+				return
+			}
+
+			var kee *KeyExpiredError
+			if !IsKeyExpiredError(err) || IsUnknownKeyError(err) || !errors.As(err, &kee) || kee.KeyID != "some-key" {
+				t.Error("expected key expired error. Got:", err)
+			}
+		})
+	}
+}
+
+func TestVerify_NoNowFunc(t *testing.T) {
+	secret := []byte(testSecret)
+
+	s := testSigner("hmac-key", signHmacSha256(secret))
+	s.nowFunc = time.Now
+	s.expires = time.Minute
+
+	msg := testReq()
+	signMessage(t, s, msg)
+
+	v := &verifier{keys: map[string]verHolder{"hmac-key": verifyHmacSha256(secret)}}
+	if _, err := v.Verify(msg); err != nil {
+		t.Error("verification failed:", err)
+	}
+
+	s.expires = -time.Minute
+	msg = testReq()
+	signMessage(t, s, msg)
+
+	if _, err := v.Verify(msg); !IsSignatureExpiredError(err) {
+		t.Error("expected expired signature. Got:", err)
+	}
+}
+
+func TestVerify_LengthLimits(t *testing.T) {
+	secret := []byte(testSecret)
+	clock := withNowFunc(func() time.Time { return time.Unix(1618884475, 0) })
+
+	signed := func(keyID, contentType string) *Message {
+		msg := testReq()
+		msg.Header.Set("Content-Type", contentType)
+		signMessage(t, testSigner(keyID, signHmacSha256(secret)), msg)
+		return msg
+	}
+
+	verify := func(msg *Message, keyID string, opts ...VerifyOption) error {
+		opts = append(opts, WithHmacSha256(keyID, secret), clock)
+		_, err := newVerifier(opts).Verify(msg)
+		return err
+	}
+
+	t.Run("header value", func(t *testing.T) {
+		if err := verify(signed("k", strings.Repeat("a", 8192)), "k"); err != nil {
+			t.Error("verification at the default limit failed:", err)
+		}
+
+		if err := verify(signed("k", strings.Repeat("a", 8193)), "k"); !IsMalformedSignatureError(err) {
+			t.Error("expected malformed signature over the default limit. Got:", err)
+		}
+
+		// The longest signed value is the date.
+		msg := signed("k", "application/json")
+		n := len(msg.Header.Get("Date"))
+		if err := verify(msg, "k", WithMaxHeaderValueLength(n)); err != nil {
+			t.Error("verification at the limit failed:", err)
+		}
+
+		if err := verify(msg, "k", WithMaxHeaderValueLength(n-1)); !IsMalformedSignatureError(err) {
+			t.Error("expected malformed signature over the limit. Got:", err)
+		}
+
+		if err := verify(signed("k", strings.Repeat("a", 8193)), "k", WithMaxHeaderValueLength(-1)); err != nil {
+			t.Error("verification without a limit failed:", err)
+		}
+	})
+
+	t.Run("signature input", func(t *testing.T) {
+		keyID := strings.Repeat("k", 4096)
+		if err := verify(signed(keyID, "application/json"), keyID); !IsMalformedSignatureError(err) {
+			t.Error("expected malformed signature over the default limit. Got:", err)
+		}
+
+		msg := signed("k", "application/json")
+		n := len(msg.Header.Get("Signature-Input"))
+		if err := verify(msg, "k", WithMaxSignatureInputLength(n)); err != nil {
+			t.Error("verification at the limit failed:", err)
+		}
+
+		if err := verify(msg, "k", WithMaxSignatureInputLength(n-1)); !IsMalformedSignatureError(err) {
+			t.Error("expected malformed signature over the limit. Got:", err)
+		}
+	})
+}
+
+func TestVerify_MaxComponents(t *testing.T) {
+	secret := []byte(testSecret)
+
+	msg := testReq()
+	s := testSigner("k", signHmacSha256(secret))
+	s.headers = nil
+	for i := 1; i <= 21; i++ {
+		h := fmt.Sprintf("x-h%d", i)
+		msg.Header.Set(h, "v")
+		s.headers = append(s.headers, h)
+	}
+	signMessage(t, s, msg)
+
+	verify := func(opts ...VerifyOption) error {
+		opts = append(opts, WithHmacSha256("k", secret), withNowFunc(s.nowFunc))
+		_, err := newVerifier(opts).Verify(msg)
+		return err
+	}
+
+	if err := verify(); !IsMalformedSignatureError(err) {
+		t.Error("expected malformed signature over the default limit. Got:", err)
+	}
+
+	if err := verify(WithMaxComponents(25)); err != nil {
+		t.Error("verification under the limit failed:", err)
+	}
+
+	if err := verify(WithMaxComponents(21)); err != nil {
+		t.Error("verification at the limit failed:", err)
+	}
+}
+
+func TestVerify_ObfuscatedKeyID(t *testing.T) {
+	secret := []byte(testSecret)
+
+	// The signer's key id isn't the one the key is registered under.
+	msg := testReq()
+	s := testSigner("opaque", signHmacSha256(secret))
+	signMessage(t, s, msg)
+
+	verify := func(opts ...VerifyOption) (VerifyResult, error) {
+		opts = append(opts, WithHmacSha256("other", []byte("not the secret")), WithHmacSha256("tenant-key", secret), withNowFunc(s.nowFunc))
+		return newVerifier(opts).Verify(msg)
+	}
+
+	if _, err := verify(); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key. Got:", err)
+	}
+
+	res, err := verify(WithObfuscatedKeyID())
+	if err != nil {
+		t.Fatal("verification failed:", err)
+	}
+
+	if res.KeyID != "tenant-key" {
+		t.Error("unexpected key id:", res.KeyID)
+	}
+
+	// When every key is rejected before verification, the reason is reported.
+	if _, err := verify(WithObfuscatedKeyID(), WithRequiredComponents("x-tenant")); !IsMissingComponentError(err) {
+		t.Error("expected missing component. Got:", err)
+	}
+
+	msg.Header.Set("Content-Type", "text/plain")
+	if _, err := verify(WithObfuscatedKeyID()); !IsUnknownKeyError(err) {
+		t.Error("expected unknown key for an altered message. Got:", err)
+	}
+}
+
+func TestVerify_SplitHeaders(t *testing.T) {
+	secret := []byte(testSecret)
+
+	msg := testReq()
+	s := testSigner("key1", signHmacSha256([]byte("other secret")))
+	s.keys["key2"] = signHmacSha256(secret)
+	signMessage(t, s, msg)
+
+	// Split the combined headers into one line per signature.
+	for _, h := range []string{"Signature-Input", "Signature"} {
+		d, err := sfv.ParseDictionary(msg.Header.Get(h))
+		if err != nil || len(d) != 2 {
+			t.Fatalf("unexpected %s header: %q", h, msg.Header.Get(h))
+		}
+
+		msg.Header.Del(h)
+		for _, m := range d {
+			v, err := sfv.SerializeDictionary(sfv.Dictionary{m})
+			if err != nil {
+				t.Fatal("could not serialize header:", err)
+			}
+			msg.Header.Add(h, v)
+		}
+	}
+
+	res, err := testVerifier("key2", verifyHmacSha256(secret)).Verify(msg)
+	if err != nil {
+		t.Fatal("verification failed:", err)
+	}
+
+	if res.KeyID != "key2" {
+		t.Error("unexpected key id:", res.KeyID)
+	}
+}